@@ -1,6 +1,8 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"github.com/jessevdk/go-flags"
 	log "github.com/sirupsen/logrus"
 	"github.com/willena/s3-exporter/walker"
@@ -8,12 +10,29 @@ import (
 	"time"
 )
 
+const walkersGroup = "Walkers configuration"
+
+// DefaultTarget is the name of the target declared with flags
+const DefaultTarget = "default"
+
 type Config struct {
-	WalkerType     string              `long:"type" description:"Walker type" env:"WALKER_TYPE" required:"true" choice:"s3" choice:"fs"`
+	ConfigFile     string              `long:"config" env:"CONFIG_FILE" required:"false" description:"YAML file declaring the targets to walk; walker options given as flags are ignored when set"`
+	WalkerType     string              `long:"type" description:"Walker type; required unless a configuration file is used" env:"WALKER_TYPE"`
 	Walker         walker.Config       `group:"Walkers configuration" namespace:"walker" env-namespace:"WALKER"`
 	Server         ServerConfiguration `group:"HTTP Server configuration" namespace:"http" env-namespace+:"HTTP"`
 	ScrapeInterval time.Duration       `long:"interval" default:"10m" env:"SCRAPE_INTERVAL" required:"false" description:"Define the minimum delay between scrapes. Set this to a reasonable value to avoid unnecessary stress on drives"`
 	LogLevel       string              `long:"logLevel" default:"debug" env:"LOG_LEVEL" required:"false" description:"Level for logger; available options are: debug, info, warning, error" `
+
+	// Targets are the targets to walk: the ones of the configuration file, or a single one declared with flags
+	Targets []Target `no-flag:"true"`
+}
+
+// Target is a walker to run, along with its options
+type Target struct {
+	Name           string
+	WalkerType     string
+	ScrapeInterval time.Duration
+	Walker         walker.Config
 }
 
 type ServerConfiguration struct {
@@ -23,12 +42,78 @@ type ServerConfiguration struct {
 	ServerCertificate string `long:"certFile" required:"false" env:"CERT_FILE" description:"Required along with keyFile to enable HTTPS"`
 }
 
+// LoadConfig loads the configuration at startup, exiting on invalid configurations
 func LoadConfig() *Config {
-	var opts Config
 	log.Info("Loading configuration from arg or environment variables")
-	_, err := flags.ParseArgs(&opts, os.Args)
+	opts, err := Load()
+	if err != nil {
+		var flagsErr *flags.Error
+		if errors.As(err, &flagsErr) {
+			os.Exit(1)
+		}
+		log.Fatal(err.Error())
+	}
+	return opts
+}
+
+// Load reads the configuration from args, environment variables and the configuration file
+func Load() (*Config, error) {
+	var opts Config
+	parser := flags.NewParser(&opts, flags.Default)
+	walkerOptions, err := addWalkerOptions(parser)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = parser.ParseArgs(os.Args)
 	if err != nil {
-		os.Exit(1)
+		return nil, err
 	}
-	return &opts
+
+	opts.Walker.Options = walkerOptions[opts.WalkerType]
+	opts.Targets, err = opts.targets()
+	if err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
+
+func (c *Config) targets() ([]Target, error) {
+	if c.ConfigFile != "" {
+		log.Infof("Loading targets from %s", c.ConfigFile)
+		return loadTargets(c.ConfigFile, c.ScrapeInterval)
+	}
+
+	if c.WalkerType == "" {
+		return nil, fmt.Errorf("a walker type (--type) or a configuration file (--config) is required")
+	}
+	return []Target{{
+		Name:           DefaultTarget,
+		WalkerType:     c.WalkerType,
+		ScrapeInterval: c.ScrapeInterval,
+		Walker:         c.Walker,
+	}}, nil
+}
+
+// addWalkerOptions exposes the options of every registered walker below the walkers group
+// and restricts --type to the registered walker names
+func addWalkerOptions(parser *flags.Parser) (map[string]interface{}, error) {
+	group := parser.Group.Find(walkersGroup)
+	if group == nil {
+		return nil, fmt.Errorf("could not find the %q options group", walkersGroup)
+	}
+
+	options := map[string]interface{}{}
+	for _, registration := range walker.Registrations() {
+		options[registration.Name] = registration.NewConfig()
+		walkerGroup, err := group.AddGroup(registration.Description, "", options[registration.Name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid options for walker %s: %w", registration.Name, err)
+		}
+		walkerGroup.Namespace = registration.Namespace
+		walkerGroup.EnvNamespace = registration.EnvNamespace
+	}
+
+	parser.FindOptionByLongName("type").Choices = walker.Names()
+	return options, nil
 }
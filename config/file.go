@@ -0,0 +1,170 @@
+package config
+
+import (
+	"fmt"
+	"github.com/jessevdk/go-flags"
+	"github.com/willena/s3-exporter/walker"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// fileConfig is the content of the YAML configuration file.
+// Targets options use the flags names, nested by namespace (e.g. walker: {s3: {endpoint: ...}}).
+type fileConfig struct {
+	Targets []map[string]interface{} `yaml:"targets"`
+}
+
+// targetOptions are the options of one target, parsed the same way as flags
+type targetOptions struct {
+	WalkerType     string        `long:"type" description:"Walker type" required:"true"`
+	ScrapeInterval time.Duration `long:"interval" description:"Define the minimum delay between scrapes"`
+	Walker         walker.Config `group:"Walkers configuration" namespace:"walker"`
+}
+
+// loadTargets reads the targets declared in the YAML file at path.
+// Options left unset take the flags default values; intervals default to defaultInterval.
+func loadTargets(path string, defaultInterval time.Duration) ([]Target, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read configuration file: %w", err)
+	}
+
+	var file fileConfig
+	if err := yaml.UnmarshalStrict(content, &file); err != nil {
+		return nil, fmt.Errorf("could not parse configuration file %s: %w", path, err)
+	}
+	if len(file.Targets) == 0 {
+		return nil, fmt.Errorf("no target declared in configuration file %s", path)
+	}
+
+	var targets []Target
+	names := map[string]bool{}
+	for i, options := range file.Targets {
+		name, _ := options["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("target #%d has no name", i+1)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("target %s is declared twice", name)
+		}
+		names[name] = true
+
+		target, err := parseTarget(name, options, defaultInterval)
+		if err != nil {
+			// Not wrapped: parsing errors of the file must not be mistaken for command line ones
+			return nil, fmt.Errorf("invalid target %s: %s", name, err)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+func parseTarget(name string, options map[string]interface{}, defaultInterval time.Duration) (Target, error) {
+	var opts targetOptions
+	parser := flags.NewParser(&opts, flags.None)
+	walkerOptions, err := addWalkerOptions(parser)
+	if err != nil {
+		return Target{}, err
+	}
+	parser.FindOptionByLongName("interval").Default = []string{defaultInterval.String()}
+	// Targets of the file are only configured by the file
+	ignoreEnvironment(parser.Groups())
+
+	var args []string
+	for _, key := range sortedKeys(options) {
+		if key == "name" {
+			continue
+		}
+		args, err = appendArgs(parser, args, key, options[key])
+		if err != nil {
+			return Target{}, err
+		}
+	}
+
+	if _, err := parser.ParseArgs(args); err != nil {
+		return Target{}, err
+	}
+
+	opts.Walker.Options = walkerOptions[opts.WalkerType]
+	return Target{
+		Name:           name,
+		WalkerType:     opts.WalkerType,
+		ScrapeInterval: opts.ScrapeInterval,
+		Walker:         opts.Walker,
+	}, nil
+}
+
+// appendArgs converts the YAML value of option name in command line arguments.
+// Maps are either values of a map option or options of a nested namespace.
+func appendArgs(parser *flags.Parser, args []string, name string, value interface{}) ([]string, error) {
+	option := parser.FindOptionByLongName(name)
+	values, isMap := value.(map[interface{}]interface{})
+
+	if option == nil {
+		if !isMap {
+			return nil, fmt.Errorf("unknown option %s", name)
+		}
+		nested := stringKeys(values)
+		var err error
+		for _, key := range sortedKeys(nested) {
+			args, err = appendArgs(parser, args, name+"."+key, nested[key])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return args, nil
+	}
+
+	flag := "--" + name
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		entries := stringKeys(typed)
+		for _, key := range sortedKeys(entries) {
+			args = append(args, fmt.Sprintf("%s=%s:%v", flag, key, entries[key]))
+		}
+	case []interface{}:
+		for _, item := range typed {
+			args = append(args, fmt.Sprintf("%s=%v", flag, item))
+		}
+	case bool:
+		if option.Field().Type.Kind() != reflect.Bool {
+			args = append(args, fmt.Sprintf("%s=%v", flag, typed))
+		} else if typed {
+			args = append(args, flag)
+		}
+	case nil:
+	default:
+		args = append(args, fmt.Sprintf("%s=%v", flag, typed))
+	}
+	return args, nil
+}
+
+func ignoreEnvironment(groups []*flags.Group) {
+	for _, group := range groups {
+		for _, option := range group.Options() {
+			option.EnvDefaultKey = ""
+		}
+		ignoreEnvironment(group.Groups())
+	}
+}
+
+func stringKeys(values map[interface{}]interface{}) map[string]interface{} {
+	converted := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		converted[fmt.Sprint(key)] = value
+	}
+	return converted
+}
+
+// sortedKeys returns the keys of values, sorted to get reproducible arguments
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
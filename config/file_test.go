@@ -0,0 +1,112 @@
+package config
+
+import (
+	"github.com/willena/s3-exporter/walker"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes content to a configuration file of a temporary folder and returns its path
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "targets.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadTargets(t *testing.T) {
+	// Targets of the file are only configured by the file
+	os.Setenv("WALKER_S3_BUCKET", "from-environment")
+	defer os.Unsetenv("WALKER_S3_BUCKET")
+
+	path := writeConfig(t, `
+targets:
+  - name: photos
+    type: s3
+    interval: 1h
+    walker:
+      maxDepth: 2
+      custom-labels:
+        team: media
+      bucket-filter: ["^tmp-"]
+      s3:
+        endpoint: https://s3.example.com
+        scope: [photos, archive/2020]
+        versions: true
+        bucket-path-style: false
+        list-workers: 4
+  - name: nfs
+    type: fs
+    walker:
+      folder: /mnt/nfs
+      fs-workers: 8
+`)
+	targets, err := loadTargets(path, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets, got %d", len(targets))
+	}
+
+	photos := targets[0]
+	if photos.Name != "photos" || photos.WalkerType != "s3" || photos.ScrapeInterval != time.Hour {
+		t.Errorf("unexpected photos target: %s %s %s", photos.Name, photos.WalkerType, photos.ScrapeInterval)
+	}
+	if photos.Walker.Depth != 2 || !reflect.DeepEqual(photos.Walker.CustomLabels, map[string]string{"team": "media"}) {
+		t.Errorf("unexpected base options: depth %d, labels %v", photos.Walker.Depth, photos.Walker.CustomLabels)
+	}
+	s3Config, ok := photos.Walker.Options.(*walker.S3WalkerConfig)
+	if !ok {
+		t.Fatalf("unexpected options %T", photos.Walker.Options)
+	}
+	if s3Config.Endpoint != "https://s3.example.com" || !reflect.DeepEqual(s3Config.Scopes, []string{"photos", "archive/2020"}) ||
+		!reflect.DeepEqual(s3Config.BucketFilters, []string{"^tmp-"}) || !s3Config.Versions || s3Config.BucketPathStyle || s3Config.ListWorkers != 4 {
+		t.Errorf("unexpected s3 options: %+v", s3Config.S3Configuration)
+	}
+	// Options left unset take the flags default values, not the environment
+	if s3Config.Region != "us-west" || s3Config.MetadataWorkers != 8 || s3Config.Bucket != "" {
+		t.Errorf("unexpected default s3 options: region %q, metadata workers %d, bucket %q", s3Config.Region, s3Config.MetadataWorkers, s3Config.Bucket)
+	}
+
+	nfs := targets[1]
+	fsConfig, ok := nfs.Walker.Options.(*walker.FsWalkerConfig)
+	if !ok {
+		t.Fatalf("unexpected options %T", nfs.Walker.Options)
+	}
+	if nfs.WalkerType != "fs" || nfs.ScrapeInterval != 10*time.Minute || fsConfig.Folder != "/mnt/nfs" || fsConfig.Workers != 8 {
+		t.Errorf("unexpected nfs target: %s %s %+v", nfs.WalkerType, nfs.ScrapeInterval, fsConfig)
+	}
+	if nfs.Walker.BaseWalkerConfig == photos.Walker.BaseWalkerConfig {
+		t.Error("targets share their options")
+	}
+}
+
+func TestLoadTargetsErrors(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"no target", "targets: []", "no target declared"},
+		{"unknown field", "target: []", "could not parse"},
+		{"no name", "targets: [{type: fs}]", "target #1 has no name"},
+		{"duplicate", "targets: [{name: a, type: fs}, {name: a, type: fs}]", "target a is declared twice"},
+		{"unknown option", "targets: [{name: a, type: fs, walker: {fodler: /}}]", "unknown option walker.fodler"},
+		{"unknown type", "targets: [{name: a, type: nfs}]", "invalid target a"},
+		{"invalid value", "targets: [{name: a, type: fs, walker: {fs-workers: many}}]", "invalid target a"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadTargets(writeConfig(t, test.content), time.Minute)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 	"github.com/willena/s3-exporter/config"
+	"github.com/willena/s3-exporter/targets"
 	"github.com/willena/s3-exporter/utils"
-	"github.com/willena/s3-exporter/walker"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 )
 
@@ -20,18 +26,66 @@ func main() {
 
 	utils.InitLogger(opts.LogLevel)
 
-	walkerInst, err := walker.FromConfig(opts.Walker, opts.WalkerType)
-	if err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	manager := targets.NewManager()
+	prometheus.MustRegister(manager)
+	if err := manager.Apply(ctx, opts.Targets); err != nil {
 		log.Fatal(err.Error())
 	}
 
-	initScheduler(walkerInst, opts.ScrapeInterval)
-	startServer(opts.Server)
+	reload := func() error {
+		return reloadConfig(ctx, manager)
+	}
+	handleReloadSignal(ctx, reload)
+	startServer(ctx, opts.Server, reload)
+}
+
+// reloadConfig reads the configuration again and applies its targets; the running
+// targets are kept if the new configuration is invalid
+func reloadConfig(ctx context.Context, manager *targets.Manager) error {
+	log.Info("Reloading configuration...")
+	opts, err := config.Load()
+	if err == nil {
+		err = manager.Apply(ctx, opts.Targets)
+	}
+	if err != nil {
+		log.Errorf("could not reload configuration, keeping the current one: %s", err.Error())
+		return err
+	}
+
+	utils.InitLogger(opts.LogLevel)
+	log.Info("Configuration reloaded")
+	return nil
 }
 
-func startServer(serverConf config.ServerConfiguration) {
+func handleReloadSignal(ctx context.Context, reload func() error) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				_ = reload()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func startServer(ctx context.Context, serverConf config.ServerConfiguration, reload func() error) {
 	r := mux.NewRouter()
 	r.Handle("/metrics", promhttp.Handler())
+	r.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if err := reload(); err != nil {
+			http.Error(w, "could not reload configuration: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("configuration reloaded\n"))
+	}).Methods(http.MethodPost, http.MethodPut)
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, err := w.Write([]byte(`<html>
 			<head><title>S3 Exporter</title></head>
@@ -46,27 +100,28 @@ func startServer(serverConf config.ServerConfiguration) {
 	})
 
 	listen := serverConf.Listen + ":" + strconv.Itoa(serverConf.Port)
+	server := &http.Server{Addr: listen, Handler: r}
+
+	go func() {
+		<-ctx.Done()
+		log.Info("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("could not shutdown server: %s", err.Error())
+		}
+	}()
+
 	var err error
 	if serverConf.ServerCertificate != "" && serverConf.ServerKeyFile != "" {
 		log.Infof("Starting HTTPS server on %s (Cert: %s, Key%s)", listen, serverConf.ServerCertificate, serverConf.ServerKeyFile)
-		err = http.ListenAndServeTLS(listen, serverConf.ServerCertificate, serverConf.ServerKeyFile, r)
+		err = server.ListenAndServeTLS(serverConf.ServerCertificate, serverConf.ServerKeyFile)
 	} else {
 		log.Infof("Starting HTTP server on %s", listen)
-		err = http.ListenAndServe(listen, r)
+		err = server.ListenAndServe()
 	}
 
-	if err != nil {
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal("Could not start Server: ", err)
 	}
 }
-
-func initScheduler(walkerInst walker.Walker, duration time.Duration) {
-
-	log.Infof("Scheduling scrape every %s", duration.String())
-	utils.Schedule(func() {
-		err := walkerInst.Walk()
-		if err != nil {
-			log.Errorf("could not walk the specified path: ", err.Error())
-		}
-	}, duration)
-}
@@ -0,0 +1,48 @@
+package stats
+
+import "time"
+
+// Bucket settings, used to list the settings that could not be read
+const (
+	BucketSettingVersioning   = "versioning"
+	BucketSettingObjectLock   = "object_lock"
+	BucketSettingEncryption   = "encryption"
+	BucketSettingLifecycle    = "lifecycle"
+	BucketSettingReplication  = "replication"
+	BucketSettingNotification = "notification"
+	BucketSettingPolicy       = "policy"
+)
+
+// BucketUnknown is the value of the settings that could not be read
+const BucketUnknown = "unknown"
+
+// BucketConfig describes the configuration of a bucket
+type BucketConfig struct {
+	// CreationDate is zero when unknown
+	CreationDate time.Time `json:"creationDate"`
+	Region       string    `json:"region"`
+	// Versioning is Enabled, Suspended or Disabled
+	Versioning string `json:"versioning"`
+	MFADelete  string `json:"mfaDelete"`
+	// ObjectLock is Enabled or Disabled; ObjectLockMode is the default retention mode, if any
+	ObjectLock     string `json:"objectLock"`
+	ObjectLockMode string `json:"objectLockMode"`
+	// Encryption is the default encryption algorithm, none when objects are not encrypted by default
+	Encryption        string `json:"encryption"`
+	LifecycleRules    int    `json:"lifecycleRules"`
+	ReplicationRules  int    `json:"replicationRules"`
+	NotificationRules int    `json:"notificationRules"`
+	Policy            bool   `json:"policy"`
+	// Unknown lists the settings that could not be read
+	Unknown []string `json:"unknown,omitempty"`
+}
+
+// Known tells whether setting could be read
+func (c *BucketConfig) Known(setting string) bool {
+	for _, unknown := range c.Unknown {
+		if unknown == setting {
+			return false
+		}
+	}
+	return true
+}
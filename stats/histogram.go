@@ -0,0 +1,58 @@
+package stats
+
+import "sort"
+
+// Histogram counts observations in buckets defined by their upper bounds
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	// Counts holds the number of observations of each bucket (not cumulative);
+	// observations above the last bound are only part of Count
+	Counts []uint64 `json:"counts"`
+	Count  uint64   `json:"count"`
+	Sum    float64  `json:"sum"`
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)),
+	}
+}
+
+// ExponentialBounds returns number bounds, starting at start and multiplied by factor each time
+func ExponentialBounds(start, factor float64, number int) []float64 {
+	bounds := make([]float64, 0, number)
+	for i := 0; i < number; i++ {
+		bounds = append(bounds, start)
+		start *= factor
+	}
+	return bounds
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.Count++
+	h.Sum += value
+	if i := sort.SearchFloat64s(h.Bounds, value); i < len(h.Bounds) {
+		h.Counts[i]++
+	}
+}
+
+// Merge adds the observations of other, which must have the same bounds
+func (h *Histogram) Merge(other *Histogram) {
+	h.Count += other.Count
+	h.Sum += other.Sum
+	for i := range h.Counts {
+		h.Counts[i] += other.Counts[i]
+	}
+}
+
+// Cumulative returns the cumulative count per upper bound
+func (h *Histogram) Cumulative() map[float64]uint64 {
+	buckets := make(map[float64]uint64, len(h.Bounds))
+	var total uint64
+	for i, bound := range h.Bounds {
+		total += h.Counts[i]
+		buckets[bound] = total
+	}
+	return buckets
+}
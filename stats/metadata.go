@@ -0,0 +1,12 @@
+package stats
+
+// ObjectMetadata is the metadata of an object, read from HEAD requests or listings including metadata
+type ObjectMetadata struct {
+	ContentType string
+	// Encryption is the server side encryption algorithm, none for objects not encrypted
+	Encryption        string
+	KMSKeyID          string
+	ReplicationStatus string
+	// MetadataKeys are the names of the user metadata of the object
+	MetadataKeys []string
+}
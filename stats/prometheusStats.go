@@ -2,132 +2,390 @@ package stats
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/willena/s3-exporter/utils"
 	"time"
 )
 
 const METRICS_GROUP = "file_walker"
 
+// PrometheusStats exposes the last published Snapshot of its Recorder as prometheus metrics.
+// A scrape always renders a single, complete snapshot.
 type PrometheusStats struct {
+	*Recorder
+
 	// Simple stats
-	MaxDepth          *prometheus.GaugeVec
-	CollectDuration   *prometheus.GaugeVec
-	TotalObjectsSize  *prometheus.GaugeVec
-	TotalObjectsCount *prometheus.GaugeVec
-	LastWalkStart     *prometheus.GaugeVec
+	MaxDepth          *prometheus.Desc
+	CollectDuration   *prometheus.Desc
+	TotalObjectsSize  *prometheus.Desc
+	TotalObjectsCount *prometheus.Desc
+	LastWalkStart     *prometheus.Desc
+	LastWalkEnd       *prometheus.Desc
+	WalkGeneration    *prometheus.Desc
+	DataAge           *prometheus.Desc
+	PartialData       *prometheus.Desc
+
+	// Walks history
+	WalkOutcome *prometheus.Desc
+	WalksCount  *prometheus.Desc
+	WalkErrors  *prometheus.Desc
+	// BucketErrors counts the walk errors per bucket
+	BucketErrors *prometheus.Desc
+	// EnrichmentErrors counts the errors of optional enrichments, which do not make walks partial
+	EnrichmentErrors *prometheus.Desc
+
+	// API requests
+	Requests          *prometheus.Desc
+	RequestsThrottled *prometheus.Desc
+	RequestLatency    *prometheus.Desc
+	WalkRequests      *prometheus.Desc
+	WalkRequestsCost  *prometheus.Desc
+
+	// Usage reported by the storage, and its cross-check by listing
+	UsageDate          *prometheus.Desc
+	CrossCheckObjects  *prometheus.Desc
+	CrossCheckSize     *prometheus.Desc
+	CrossCheckDate     *prometheus.Desc
+	CrossCheckMismatch *prometheus.Desc
+
+	// Users usage and quotas reported by the storage
+	UserObjectsCount   *prometheus.Desc
+	UserObjectsSize    *prometheus.Desc
+	UserQuotaObjects   *prometheus.Desc
+	UserQuotaSize      *prometheus.Desc
+	BucketQuotaObjects *prometheus.Desc
+	BucketQuotaSize    *prometheus.Desc
 
 	//Per prefix stats
-	PerPrefixObjectsSizeHistogram      *prometheus.HistogramVec
-	PerPrefixObjectsSize               *prometheus.GaugeVec
-	PerPrefixObjectsCount              *prometheus.GaugeVec
-	PerPrefixPerExtensionObjectCount   *prometheus.GaugeVec
-	PerPrefixPerExtensionObjectsSize   *prometheus.GaugeVec
-	PerPrefixPerContentTypeObjectCount *prometheus.GaugeVec
-	PerPrefixPerContentTypeObjectsSize *prometheus.GaugeVec
+	PerPrefixObjectsSizeHistogram      *prometheus.Desc
+	PerPrefixObjectsSize               *prometheus.Desc
+	PerPrefixObjectsCount              *prometheus.Desc
+	PerPrefixPerExtensionObjectCount   *prometheus.Desc
+	PerPrefixPerExtensionObjectsSize   *prometheus.Desc
+	PerPrefixPerContentTypeObjectCount *prometheus.Desc
+	PerPrefixPerContentTypeObjectsSize *prometheus.Desc
+	PerPrefixObjectsAgeHistogram       *prometheus.Desc
+	PerPrefixOldestObject              *prometheus.Desc
+	PerPrefixNewestObject              *prometheus.Desc
 
-	startTime time.Time
-	maxDepth  uint64
+	// Estimated breakdowns from objects metadata, per prefix
+	PerPrefixMetadataSampledCount      *prometheus.Desc
+	PerPrefixMetadataSampledSize       *prometheus.Desc
+	PerPrefixEstimatedContentTypeCount *prometheus.Desc
+	PerPrefixEstimatedContentTypeSize  *prometheus.Desc
+	PerPrefixEstimatedEncryptionCount  *prometheus.Desc
+	PerPrefixEstimatedEncryptionSize   *prometheus.Desc
+	PerPrefixEstimatedKMSKeyCount      *prometheus.Desc
+	PerPrefixEstimatedKMSKeySize       *prometheus.Desc
+	PerPrefixEstimatedReplicationCount *prometheus.Desc
+	PerPrefixEstimatedReplicationSize  *prometheus.Desc
+	PerPrefixEstimatedMetadataKeyCount *prometheus.Desc
+	PerPrefixEstimatedMetadataKeySize  *prometheus.Desc
+	PerPrefixTagsSampledCount          *prometheus.Desc
+	PerPrefixTagsSampledSize           *prometheus.Desc
+	PerPrefixEstimatedTagCount         *prometheus.Desc
+	PerPrefixEstimatedTagSize          *prometheus.Desc
 
-	currentMetrics                []prometheus.Collector
-	constLabels                   prometheus.Labels
-	namesWithPrefix               []string
-	namesWithPrefixAndContentType []string
-	start                         float64
-	namesWithPrefixAndExt         []string
-	factor                        float64
-	number                        int
-	names                         []string
-}
+	// Buckets configuration
+	BucketInfo              *prometheus.Desc
+	BucketCreationDate      *prometheus.Desc
+	BucketLifecycleRules    *prometheus.Desc
+	BucketReplicationRules  *prometheus.Desc
+	BucketNotificationRules *prometheus.Desc
+	BucketPolicy            *prometheus.Desc
 
-func (p *PrometheusStats) ProcessFile(prefix string, size uint64, depth uint64, ext string, contentType string, labels map[string]string) {
+	// Incomplete multipart uploads per prefix
+	PerPrefixUploadsCount        *prometheus.Desc
+	PerPrefixUploadsSize         *prometheus.Desc
+	PerPrefixUploadsAgeHistogram *prometheus.Desc
 
-	if depth >= p.maxDepth {
-		p.maxDepth = depth
-		p.MaxDepth.With(labels).Set(float64(depth))
-	}
+	// Object versions per prefix
+	PerPrefixVersionsCount          *prometheus.Desc
+	PerPrefixVersionsSize           *prometheus.Desc
+	PerPrefixNoncurrentAgeHistogram *prometheus.Desc
 
-	p.TotalObjectsCount.With(labels).Add(1)
-	p.TotalObjectsSize.With(labels).Add(float64(size))
+	// Object lock protection per prefix
+	PerPrefixRetentionCount       *prometheus.Desc
+	PerPrefixRetentionSize        *prometheus.Desc
+	PerPrefixRetainUntilHistogram *prometheus.Desc
+	PerPrefixLegalHoldCount       *prometheus.Desc
+	PerPrefixLegalHoldSize        *prometheus.Desc
+
+	names []string
+}
 
-	prefixLabel := utils.MergeMapsRight(prometheus.Labels{
-		"prefix": prefix,
-	}, labels)
+// Describe implements the prometheus.Collector interface
+func (p *PrometheusStats) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range p.descs() {
+		ch <- desc
+	}
+}
 
-	p.PerPrefixObjectsSizeHistogram.With(prefixLabel).Observe(float64(size))
-	p.PerPrefixObjectsSize.With(prefixLabel).Add(float64(size))
-	p.PerPrefixObjectsCount.With(prefixLabel).Add(1)
+// Collect implements the prometheus.Collector interface
+func (p *PrometheusStats) Collect(ch chan<- prometheus.Metric) {
+	p.collectStatus(ch, p.Status())
 
-	prefixExtLabels := utils.MergeMapsRight(prometheus.Labels{
-		"prefix": prefix,
-		"ext":    ext,
-	}, labels)
+	snapshot := p.Snapshot()
+	if snapshot == nil {
+		return
+	}
 
-	p.PerPrefixPerExtensionObjectCount.With(prefixExtLabels).Add(1)
-	p.PerPrefixPerExtensionObjectsSize.With(prefixExtLabels).Add(float64(size))
+	ch <- prometheus.MustNewConstMetric(p.CollectDuration, prometheus.GaugeValue, float64(snapshot.Duration()))
+	ch <- prometheus.MustNewConstMetric(p.LastWalkStart, prometheus.GaugeValue, float64(snapshot.StartTime.Unix()))
+	ch <- prometheus.MustNewConstMetric(p.LastWalkEnd, prometheus.GaugeValue, float64(snapshot.EndTime.Unix()))
+	ch <- prometheus.MustNewConstMetric(p.WalkGeneration, prometheus.CounterValue, float64(snapshot.Generation))
+	ch <- prometheus.MustNewConstMetric(p.DataAge, prometheus.GaugeValue, time.Since(snapshot.EndTime).Seconds())
+	ch <- prometheus.MustNewConstMetric(p.PartialData, prometheus.GaugeValue, boolToFloat(snapshot.Partial))
+	if snapshot.Requests != nil {
+		for operation, count := range snapshot.Requests {
+			ch <- prometheus.MustNewConstMetric(p.WalkRequests, prometheus.GaugeValue, float64(count), operation)
+		}
+		ch <- prometheus.MustNewConstMetric(p.WalkRequestsCost, prometheus.GaugeValue, snapshot.RequestsCost)
+	}
+	if !snapshot.UsageDate.IsZero() {
+		ch <- prometheus.MustNewConstMetric(p.UsageDate, prometheus.GaugeValue, float64(snapshot.UsageDate.Unix()))
+	}
+	for bucket, check := range snapshot.CrossChecks {
+		ch <- prometheus.MustNewConstMetric(p.CrossCheckObjects, prometheus.GaugeValue, float64(check.Objects), bucket)
+		ch <- prometheus.MustNewConstMetric(p.CrossCheckSize, prometheus.GaugeValue, float64(check.Size), bucket)
+		ch <- prometheus.MustNewConstMetric(p.CrossCheckDate, prometheus.GaugeValue, float64(check.Date.Unix()), bucket)
+		ch <- prometheus.MustNewConstMetric(p.CrossCheckMismatch, prometheus.GaugeValue, boolToFloat(check.Mismatch), bucket)
+	}
+	for user, usage := range snapshot.Users {
+		ch <- prometheus.MustNewConstMetric(p.UserObjectsCount, prometheus.GaugeValue, float64(usage.Objects), user)
+		ch <- prometheus.MustNewConstMetric(p.UserObjectsSize, prometheus.GaugeValue, float64(usage.Size), user)
+		p.collectQuota(ch, p.UserQuotaObjects, p.UserQuotaSize, user, usage.Quota)
+	}
+	for bucket, quota := range snapshot.Quotas {
+		p.collectQuota(ch, p.BucketQuotaObjects, p.BucketQuotaSize, bucket, *quota)
+	}
 
-	prefixContentTypeLabels := utils.MergeMapsRight(prometheus.Labels{
-		"prefix":      prefix,
-		"contentType": contentType,
-	}, labels)
+	for _, series := range snapshot.SortedSeries() {
+		p.collectSeries(ch, series)
+	}
+	for bucket, config := range snapshot.Buckets {
+		p.collectBucket(ch, bucket, config)
+	}
+}
 
-	p.PerPrefixPerContentTypeObjectCount.With(prefixContentTypeLabels).Add(1)
-	p.PerPrefixPerContentTypeObjectsSize.With(prefixContentTypeLabels).Add(float64(size))
+// collectTags renders the tags of the sampled objects of a prefix, scaled like metadata
+func (p *PrometheusStats) collectTags(ch chan<- prometheus.Metric, series *Series, prefix string, tagStats *TagStats) {
+	ch <- p.gauge(p.PerPrefixTagsSampledCount, float64(tagStats.Sampled.Objects), series.Labels, prefix)
+	ch <- p.gauge(p.PerPrefixTagsSampledSize, float64(tagStats.Sampled.Size), series.Labels, prefix)
 
+	countFactor, sizeFactor, ok := sampleFactors(series, prefix, tagStats.Sampled)
+	if !ok {
+		return
+	}
+	for key, values := range tagStats.Values {
+		for value, usage := range values {
+			ch <- p.gauge(p.PerPrefixEstimatedTagCount, float64(usage.Objects)*countFactor, series.Labels, prefix, key, value)
+			ch <- p.gauge(p.PerPrefixEstimatedTagSize, float64(usage.Size)*sizeFactor, series.Labels, prefix, key, value)
+		}
+	}
 }
 
-func (p *PrometheusStats) EndProcessing() {
-	p.CollectDuration.With(nil).Set(float64(time.Now().Sub(p.startTime)))
-	p.updatePrometheusGauges()
+// sampleFactors returns the factors scaling the objects count and size of a sample to the totals of the prefix
+func sampleFactors(series *Series, prefix string, sampled Usage) (countFactor float64, sizeFactor float64, ok bool) {
+	prefixStats, ok := series.Prefixes[prefix]
+	if !ok || sampled.Objects == 0 {
+		return 0, 0, false
+	}
+	countFactor = float64(prefixStats.Objects) / float64(sampled.Objects)
+	sizeFactor = countFactor
+	if sampled.Size > 0 {
+		sizeFactor = float64(prefixStats.Size) / float64(sampled.Size)
+	}
+	return countFactor, sizeFactor, true
 }
 
-func (p *PrometheusStats) StartProcessing() {
-	p.Reset()
-	p.startTime = time.Now()
-	p.LastWalkStart.With(nil).Set(float64(p.startTime.Unix()))
+// collectMetadata renders the breakdowns of the sampled objects of a prefix, scaled to the objects count and size of the prefix
+func (p *PrometheusStats) collectMetadata(ch chan<- prometheus.Metric, series *Series, prefix string, metadataStats *MetadataStats) {
+	ch <- p.gauge(p.PerPrefixMetadataSampledCount, float64(metadataStats.Sampled.Objects), series.Labels, prefix)
+	ch <- p.gauge(p.PerPrefixMetadataSampledSize, float64(metadataStats.Sampled.Size), series.Labels, prefix)
+
+	countFactor, sizeFactor, ok := sampleFactors(series, prefix, metadataStats.Sampled)
+	if !ok {
+		return
+	}
+
+	estimate := func(countDesc *prometheus.Desc, sizeDesc *prometheus.Desc, usages map[string]*Usage) {
+		for key, usage := range usages {
+			ch <- p.gauge(countDesc, float64(usage.Objects)*countFactor, series.Labels, prefix, key)
+			ch <- p.gauge(sizeDesc, float64(usage.Size)*sizeFactor, series.Labels, prefix, key)
+		}
+	}
+	estimate(p.PerPrefixEstimatedContentTypeCount, p.PerPrefixEstimatedContentTypeSize, metadataStats.ContentTypes)
+	estimate(p.PerPrefixEstimatedEncryptionCount, p.PerPrefixEstimatedEncryptionSize, metadataStats.Encryptions)
+	estimate(p.PerPrefixEstimatedKMSKeyCount, p.PerPrefixEstimatedKMSKeySize, metadataStats.KMSKeys)
+	estimate(p.PerPrefixEstimatedReplicationCount, p.PerPrefixEstimatedReplicationSize, metadataStats.ReplicationStatuses)
+	estimate(p.PerPrefixEstimatedMetadataKeyCount, p.PerPrefixEstimatedMetadataKeySize, metadataStats.MetadataKeys)
 }
 
-func (p *PrometheusStats) Reset() {
-	// Create new Gauges each time...
-	// Old ones should still be available during refresh.
-	p.CollectDuration = createGaugeVect("stats_collection_duration", "Time spent reading object and folders", p.constLabels, nil)
-	p.LastWalkStart = createGaugeVect("stats_collection_date", "Date when the stats collection started", p.constLabels, nil)
-	p.MaxDepth = createGaugeVect("max_tree_depth", "Maximum depth of folder tree", p.constLabels, p.names)
-	p.TotalObjectsSize = createGaugeVect("total_objects_size", "Total objects volume in bytes", p.constLabels, p.names)
-	p.TotalObjectsCount = createGaugeVect("total_objects_count", "total number of objects found", p.constLabels, p.names)
-	p.PerPrefixObjectsSizeHistogram = createHistogramVect("objects_sizes_count", "Histogram showing the files size repartition across prefixes", p.constLabels, p.start, p.factor, p.number, p.namesWithPrefix)
-	p.PerPrefixObjectsSize = createGaugeVect("objects_size", "Objects volume across prefixes", p.constLabels, p.namesWithPrefix)
-	p.PerPrefixObjectsCount = createGaugeVect("objects_count", "Objects count across prefixes", p.constLabels, p.namesWithPrefix)
-	p.PerPrefixPerExtensionObjectCount = createGaugeVect("objects_extensions_count", "Repartition of objects per file extension", p.constLabels, p.namesWithPrefixAndExt)
-	p.PerPrefixPerExtensionObjectsSize = createGaugeVect("objects_extensions_size", "Total size of objects per extension", p.constLabels, p.namesWithPrefixAndExt)
-	p.PerPrefixPerContentTypeObjectCount = createGaugeVect("objects_content_type_count", "Repartition of objects per file ContentType", p.constLabels, p.namesWithPrefixAndContentType)
-	p.PerPrefixPerContentTypeObjectsSize = createGaugeVect("objects_content_type_size", "Total size of objects per ContentType", p.constLabels, p.namesWithPrefixAndContentType)
+func (p *PrometheusStats) collectBucket(ch chan<- prometheus.Metric, bucket string, config *BucketConfig) {
+	ch <- prometheus.MustNewConstMetric(p.BucketInfo, prometheus.GaugeValue, 1,
+		bucket, config.Region, config.Versioning, config.MFADelete, config.ObjectLock, config.ObjectLockMode, config.Encryption)
+	if !config.CreationDate.IsZero() {
+		ch <- prometheus.MustNewConstMetric(p.BucketCreationDate, prometheus.GaugeValue, float64(config.CreationDate.Unix()), bucket)
+	}
+	if config.Known(BucketSettingLifecycle) {
+		ch <- prometheus.MustNewConstMetric(p.BucketLifecycleRules, prometheus.GaugeValue, float64(config.LifecycleRules), bucket)
+	}
+	if config.Known(BucketSettingReplication) {
+		ch <- prometheus.MustNewConstMetric(p.BucketReplicationRules, prometheus.GaugeValue, float64(config.ReplicationRules), bucket)
+	}
+	if config.Known(BucketSettingNotification) {
+		ch <- prometheus.MustNewConstMetric(p.BucketNotificationRules, prometheus.GaugeValue, float64(config.NotificationRules), bucket)
+	}
+	if config.Known(BucketSettingPolicy) {
+		ch <- prometheus.MustNewConstMetric(p.BucketPolicy, prometheus.GaugeValue, boolToFloat(config.Policy), bucket)
+	}
+}
 
+// collectQuota exports the limits of quota set, by owner
+func (p *PrometheusStats) collectQuota(ch chan<- prometheus.Metric, objectsDesc *prometheus.Desc, sizeDesc *prometheus.Desc, owner string, quota Quota) {
+	if quota.MaxObjects > 0 {
+		ch <- prometheus.MustNewConstMetric(objectsDesc, prometheus.GaugeValue, float64(quota.MaxObjects), owner)
+	}
+	if quota.MaxSize > 0 {
+		ch <- prometheus.MustNewConstMetric(sizeDesc, prometheus.GaugeValue, float64(quota.MaxSize), owner)
+	}
 }
 
-// Describe implements the prometheus.Collector interface
-func (p *PrometheusStats) Describe(ch chan<- *prometheus.Desc) {
-	for _, metric := range p.currentMetrics {
-		metric.Describe(ch)
+func (p *PrometheusStats) collectStatus(ch chan<- prometheus.Metric, status Status) {
+	for _, outcome := range Outcomes {
+		ch <- prometheus.MustNewConstMetric(p.WalkOutcome, prometheus.GaugeValue, boolToFloat(status.LastOutcome == outcome), string(outcome))
+		ch <- prometheus.MustNewConstMetric(p.WalksCount, prometheus.CounterValue, float64(status.Outcomes[outcome]), string(outcome))
+	}
+	for class, count := range status.Errors {
+		ch <- prometheus.MustNewConstMetric(p.WalkErrors, prometheus.CounterValue, float64(count), class)
+	}
+	for bucket, count := range status.BucketErrors {
+		ch <- prometheus.MustNewConstMetric(p.BucketErrors, prometheus.CounterValue, float64(count), bucket)
+	}
+	for feature, classes := range status.EnrichmentErrors {
+		for class, count := range classes {
+			ch <- prometheus.MustNewConstMetric(p.EnrichmentErrors, prometheus.CounterValue, float64(count), feature, class)
+		}
+	}
+	for operation, statuses := range status.Requests {
+		for code, count := range statuses {
+			ch <- prometheus.MustNewConstMetric(p.Requests, prometheus.CounterValue, float64(count), operation, code)
+		}
+		ch <- prometheus.MustNewConstMetric(p.RequestsThrottled, prometheus.CounterValue, float64(status.Throttled[operation]), operation)
+	}
+	for operation, histogram := range status.Latencies {
+		ch <- prometheus.MustNewConstHistogram(p.RequestLatency, histogram.Count, histogram.Sum, histogram.Cumulative(), operation)
 	}
 }
 
-// Collect implements the prometheus.Collector interface
-func (p *PrometheusStats) Collect(ch chan<- prometheus.Metric) {
-	for _, metric := range p.currentMetrics {
-		metric.Collect(ch)
+func (p *PrometheusStats) collectSeries(ch chan<- prometheus.Metric, series *Series) {
+	ch <- p.gauge(p.MaxDepth, float64(series.MaxDepth), series.Labels)
+	ch <- p.gauge(p.TotalObjectsSize, float64(series.Size), series.Labels)
+	ch <- p.gauge(p.TotalObjectsCount, float64(series.Objects), series.Labels)
+
+	for prefix, prefixStats := range series.Prefixes {
+		if histogram := prefixStats.SizeHistogram; histogram != nil {
+			ch <- prometheus.MustNewConstHistogram(p.PerPrefixObjectsSizeHistogram, histogram.Count, histogram.Sum, histogram.Cumulative(), p.labelValues(series.Labels, prefix)...)
+		}
+		ch <- p.gauge(p.PerPrefixObjectsSize, float64(prefixStats.Size), series.Labels, prefix)
+		ch <- p.gauge(p.PerPrefixObjectsCount, float64(prefixStats.Objects), series.Labels, prefix)
+
+		for ext, usage := range prefixStats.Extensions {
+			ch <- p.gauge(p.PerPrefixPerExtensionObjectCount, float64(usage.Objects), series.Labels, ext, prefix)
+			ch <- p.gauge(p.PerPrefixPerExtensionObjectsSize, float64(usage.Size), series.Labels, ext, prefix)
+		}
+
+		for contentType, usage := range prefixStats.ContentTypes {
+			ch <- p.gauge(p.PerPrefixPerContentTypeObjectCount, float64(usage.Objects), series.Labels, prefix, contentType)
+			ch <- p.gauge(p.PerPrefixPerContentTypeObjectsSize, float64(usage.Size), series.Labels, prefix, contentType)
+		}
+
+		ages := prefixStats.AgeHistogram
+		ch <- prometheus.MustNewConstHistogram(p.PerPrefixObjectsAgeHistogram, ages.Count, ages.Sum, ages.Cumulative(), p.labelValues(series.Labels, prefix)...)
+		if !prefixStats.Oldest.IsZero() {
+			ch <- p.gauge(p.PerPrefixOldestObject, float64(prefixStats.Oldest.Unix()), series.Labels, prefix)
+			ch <- p.gauge(p.PerPrefixNewestObject, float64(prefixStats.Newest.Unix()), series.Labels, prefix)
+		}
 	}
 
+	for prefix, uploadStats := range series.Uploads {
+		histogram := uploadStats.AgeHistogram
+		ch <- p.gauge(p.PerPrefixUploadsCount, float64(uploadStats.Count), series.Labels, prefix)
+		ch <- p.gauge(p.PerPrefixUploadsSize, float64(uploadStats.Size), series.Labels, prefix)
+		ch <- prometheus.MustNewConstHistogram(p.PerPrefixUploadsAgeHistogram, histogram.Count, histogram.Sum, histogram.Cumulative(), p.labelValues(series.Labels, prefix)...)
+	}
+
+	for prefix, versionStats := range series.Versions {
+		for state, usage := range versionStats.States {
+			ch <- p.gauge(p.PerPrefixVersionsCount, float64(usage.Objects), series.Labels, prefix, state)
+			ch <- p.gauge(p.PerPrefixVersionsSize, float64(usage.Size), series.Labels, prefix, state)
+		}
+		histogram := versionStats.NoncurrentAgeHistogram
+		ch <- prometheus.MustNewConstHistogram(p.PerPrefixNoncurrentAgeHistogram, histogram.Count, histogram.Sum, histogram.Cumulative(), p.labelValues(series.Labels, prefix)...)
+	}
+
+	for prefix, retentionStats := range series.Retention {
+		for mode, usage := range retentionStats.Modes {
+			ch <- p.gauge(p.PerPrefixRetentionCount, float64(usage.Objects), series.Labels, prefix, mode)
+			ch <- p.gauge(p.PerPrefixRetentionSize, float64(usage.Size), series.Labels, prefix, mode)
+		}
+		histogram := retentionStats.RetainUntilHistogram
+		ch <- prometheus.MustNewConstHistogram(p.PerPrefixRetainUntilHistogram, histogram.Count, histogram.Sum, histogram.Cumulative(), p.labelValues(series.Labels, prefix)...)
+		ch <- p.gauge(p.PerPrefixLegalHoldCount, float64(retentionStats.LegalHold.Objects), series.Labels, prefix)
+		ch <- p.gauge(p.PerPrefixLegalHoldSize, float64(retentionStats.LegalHold.Size), series.Labels, prefix)
+	}
+
+	for prefix, metadataStats := range series.Metadata {
+		p.collectMetadata(ch, series, prefix, metadataStats)
+	}
+	for prefix, tagStats := range series.Tags {
+		p.collectTags(ch, series, prefix, tagStats)
+	}
+}
+
+func (p *PrometheusStats) gauge(desc *prometheus.Desc, value float64, labels map[string]string, values ...string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, p.labelValues(labels, values...)...)
 }
 
-func (p *PrometheusStats) updatePrometheusGauges() {
-	p.currentMetrics = []prometheus.Collector{}
-	p.currentMetrics = append(
-		p.currentMetrics,
+// labelValues appends the values of the walker labels to values, in the order of the descriptions
+func (p *PrometheusStats) labelValues(labels map[string]string, values ...string) []string {
+	for _, name := range p.names {
+		values = append(values, labels[name])
+	}
+	return values
+}
+
+func (p *PrometheusStats) descs() []*prometheus.Desc {
+	return []*prometheus.Desc{
 		p.MaxDepth,
 		p.CollectDuration,
 		p.TotalObjectsSize,
 		p.TotalObjectsCount,
 		p.LastWalkStart,
+		p.LastWalkEnd,
+		p.WalkGeneration,
+		p.DataAge,
+		p.PartialData,
+		p.WalkOutcome,
+		p.WalksCount,
+		p.WalkErrors,
+		p.BucketErrors,
+		p.EnrichmentErrors,
+		p.Requests,
+		p.RequestsThrottled,
+		p.RequestLatency,
+		p.WalkRequests,
+		p.WalkRequestsCost,
+		p.UsageDate,
+		p.CrossCheckObjects,
+		p.CrossCheckSize,
+		p.CrossCheckDate,
+		p.CrossCheckMismatch,
+		p.UserObjectsCount,
+		p.UserObjectsSize,
+		p.UserQuotaObjects,
+		p.UserQuotaSize,
+		p.BucketQuotaObjects,
+		p.BucketQuotaSize,
 		p.PerPrefixObjectsSizeHistogram,
 		p.PerPrefixObjectsSize,
 		p.PerPrefixObjectsCount,
@@ -135,46 +393,157 @@ func (p *PrometheusStats) updatePrometheusGauges() {
 		p.PerPrefixPerExtensionObjectsSize,
 		p.PerPrefixPerContentTypeObjectCount,
 		p.PerPrefixPerContentTypeObjectsSize,
-	)
+		p.PerPrefixObjectsAgeHistogram,
+		p.PerPrefixOldestObject,
+		p.PerPrefixNewestObject,
+		p.PerPrefixMetadataSampledCount,
+		p.PerPrefixMetadataSampledSize,
+		p.PerPrefixEstimatedContentTypeCount,
+		p.PerPrefixEstimatedContentTypeSize,
+		p.PerPrefixEstimatedEncryptionCount,
+		p.PerPrefixEstimatedEncryptionSize,
+		p.PerPrefixEstimatedKMSKeyCount,
+		p.PerPrefixEstimatedKMSKeySize,
+		p.PerPrefixEstimatedReplicationCount,
+		p.PerPrefixEstimatedReplicationSize,
+		p.PerPrefixEstimatedMetadataKeyCount,
+		p.PerPrefixEstimatedMetadataKeySize,
+		p.PerPrefixTagsSampledCount,
+		p.PerPrefixTagsSampledSize,
+		p.PerPrefixEstimatedTagCount,
+		p.PerPrefixEstimatedTagSize,
+		p.BucketInfo,
+		p.BucketCreationDate,
+		p.BucketLifecycleRules,
+		p.BucketReplicationRules,
+		p.BucketNotificationRules,
+		p.BucketPolicy,
+		p.PerPrefixUploadsCount,
+		p.PerPrefixUploadsSize,
+		p.PerPrefixUploadsAgeHistogram,
+		p.PerPrefixVersionsCount,
+		p.PerPrefixVersionsSize,
+		p.PerPrefixNoncurrentAgeHistogram,
+		p.PerPrefixRetentionCount,
+		p.PerPrefixRetentionSize,
+		p.PerPrefixRetainUntilHistogram,
+		p.PerPrefixLegalHoldCount,
+		p.PerPrefixLegalHoldSize,
+	}
 }
 
-func createGaugeVect(name string, help string, labels prometheus.Labels, names []string) *prometheus.GaugeVec {
-	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name:        METRICS_GROUP + "_" + name,
-		Help:        help,
-		ConstLabels: labels,
-	}, names)
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
 }
 
-func createHistogramVect(name, help string, labels prometheus.Labels, start, factor float64, number int, names []string) *prometheus.HistogramVec {
-	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name:        METRICS_GROUP + "_" + name,
-		Help:        help,
-		ConstLabels: labels,
-		Buckets:     prometheus.ExponentialBuckets(start, factor, number),
-	}, names)
+func createDesc(name string, help string, labels prometheus.Labels, names []string) *prometheus.Desc {
+	return prometheus.NewDesc(METRICS_GROUP+"_"+name, help, names, labels)
 }
 
-func NewPrometheusStatsHolder(constLabels prometheus.Labels, names []string, start, factor float64, number int) StatsInterface {
+func NewPrometheusStatsHolder(constLabels prometheus.Labels, names []string, sizeBuckets []float64, ageBuckets []float64, errorPolicy string) StatsInterface {
 
 	namesWithPrefix := []string{"prefix"}
 	namesWithPrefixAndExt := []string{"ext", "prefix"}
 	namesWithPrefixAndContentType := []string{"prefix", "contentType"}
+	namesWithPrefixAndState := []string{"prefix", "state"}
+	namesWithPrefixAndEncryption := []string{"prefix", "encryption"}
+	namesWithPrefixAndKMSKey := []string{"prefix", "kmsKeyId"}
+	namesWithPrefixAndReplication := []string{"prefix", "replicationStatus"}
+	namesWithPrefixAndMetadataKey := []string{"prefix", "metadataKey"}
+	namesWithPrefixAndTag := []string{"prefix", "tagKey", "tagValue"}
+	namesWithPrefixAndMode := []string{"prefix", "mode"}
 
 	namesWithPrefix = append(namesWithPrefix, names...)
 	namesWithPrefixAndExt = append(namesWithPrefixAndExt, names...)
 	namesWithPrefixAndContentType = append(namesWithPrefixAndContentType, names...)
+	namesWithPrefixAndState = append(namesWithPrefixAndState, names...)
+	namesWithPrefixAndEncryption = append(namesWithPrefixAndEncryption, names...)
+	namesWithPrefixAndKMSKey = append(namesWithPrefixAndKMSKey, names...)
+	namesWithPrefixAndReplication = append(namesWithPrefixAndReplication, names...)
+	namesWithPrefixAndMetadataKey = append(namesWithPrefixAndMetadataKey, names...)
+	namesWithPrefixAndTag = append(namesWithPrefixAndTag, names...)
+	namesWithPrefixAndMode = append(namesWithPrefixAndMode, names...)
 
-	ps := &PrometheusStats{
-		constLabels:                   constLabels,
-		namesWithPrefix:               namesWithPrefix,
-		namesWithPrefixAndExt:         namesWithPrefixAndExt,
-		namesWithPrefixAndContentType: namesWithPrefixAndContentType,
-		start:                         start,
-		factor:                        factor,
-		number:                        number,
-		names:                         names,
-	}
+	return &PrometheusStats{
+		Recorder: NewRecorder(sizeBuckets, ageBuckets, errorPolicy),
 
-	return ps
+		CollectDuration:                    createDesc("stats_collection_duration", "Time spent reading object and folders", constLabels, nil),
+		LastWalkStart:                      createDesc("stats_collection_date", "Date when the stats collection started", constLabels, nil),
+		LastWalkEnd:                        createDesc("stats_collection_end_date", "Date when the published stats collection completed", constLabels, nil),
+		WalkGeneration:                     createDesc("stats_generation", "Number of completed walks; identifies the published stats collection", constLabels, nil),
+		DataAge:                            createDesc("stats_age_seconds", "Time elapsed since the published stats collection completed", constLabels, nil),
+		PartialData:                        createDesc("stats_partial", "Set to 1 when the published stats collection had errors and may be incomplete", constLabels, nil),
+		WalkOutcome:                        createDesc("walk_outcome", "Outcome of the last walk", constLabels, []string{"outcome"}),
+		WalksCount:                         createDesc("walks_total", "Number of walks per outcome", constLabels, []string{"outcome"}),
+		WalkErrors:                         createDesc("walk_errors_total", "Number of errors met while walking, per class", constLabels, []string{"class"}),
+		BucketErrors:                       createDesc("bucket_walk_errors_total", "Number of errors met while walking buckets, per bucket", constLabels, []string{"bucket"}),
+		EnrichmentErrors:                   createDesc("enrichment_errors_total", "Number of errors met while reading optional enrichments (bucket configuration, metadata, tags, retention, cross-checks, bucket regions), per feature and class; they do not make walks partial", constLabels, []string{"feature", "class"}),
+		Requests:                           createDesc("api_requests_total", "Number of API requests per operation and HTTP status; error when no response was received", constLabels, []string{"operation", "status"}),
+		RequestsThrottled:                  createDesc("api_throttled_requests_total", "Number of API requests rejected by throttling, per operation", constLabels, []string{"operation"}),
+		RequestLatency:                     createDesc("api_request_duration_seconds", "Histogram showing the time until the response headers of API requests, per operation", constLabels, []string{"operation"}),
+		WalkRequests:                       createDesc("walk_api_requests", "Number of API requests of the published stats collection, per operation", constLabels, []string{"operation"}),
+		WalkRequestsCost:                   createDesc("walk_estimated_requests_cost", "Estimated cost of the API requests of the published stats collection", constLabels, nil),
+		UsageDate:                          createDesc("usage_update_date", "Date when the storage last updated the usage it reported", constLabels, nil),
+		CrossCheckObjects:                  createDesc("cross_check_objects_count", "Number of objects of buckets counted by the last cross-check listing", constLabels, []string{"bucket"}),
+		CrossCheckSize:                     createDesc("cross_check_objects_size", "Volume of objects of buckets counted by the last cross-check listing", constLabels, []string{"bucket"}),
+		CrossCheckDate:                     createDesc("cross_check_date", "Date of the last cross-check listing of buckets", constLabels, []string{"bucket"}),
+		CrossCheckMismatch:                 createDesc("cross_check_mismatch", "1 when the last cross-check listing of buckets differs from the usage reported by the storage, 0 otherwise", constLabels, []string{"bucket"}),
+		UserObjectsCount:                   createDesc("user_objects_count", "Number of objects of the buckets of users", constLabels, []string{"user"}),
+		UserObjectsSize:                    createDesc("user_objects_size", "Volume of objects of the buckets of users", constLabels, []string{"user"}),
+		UserQuotaObjects:                   createDesc("user_quota_max_objects", "Maximum number of objects of users, when their quota limits it", constLabels, []string{"user"}),
+		UserQuotaSize:                      createDesc("user_quota_max_size", "Maximum volume of objects of users, when their quota limits it", constLabels, []string{"user"}),
+		BucketQuotaObjects:                 createDesc("bucket_quota_max_objects", "Maximum number of objects of buckets, when their quota limits it", constLabels, []string{"bucket"}),
+		BucketQuotaSize:                    createDesc("bucket_quota_max_size", "Maximum volume of objects of buckets, when their quota limits it", constLabels, []string{"bucket"}),
+		MaxDepth:                           createDesc("max_tree_depth", "Maximum depth of folder tree", constLabels, names),
+		TotalObjectsSize:                   createDesc("total_objects_size", "Total objects volume in bytes", constLabels, names),
+		TotalObjectsCount:                  createDesc("total_objects_count", "total number of objects found", constLabels, names),
+		PerPrefixObjectsSizeHistogram:      createDesc("objects_sizes_count", "Histogram showing the files size repartition across prefixes", constLabels, namesWithPrefix),
+		PerPrefixObjectsSize:               createDesc("objects_size", "Objects volume across prefixes", constLabels, namesWithPrefix),
+		PerPrefixObjectsCount:              createDesc("objects_count", "Objects count across prefixes", constLabels, namesWithPrefix),
+		PerPrefixPerExtensionObjectCount:   createDesc("objects_extensions_count", "Repartition of objects per file extension", constLabels, namesWithPrefixAndExt),
+		PerPrefixPerExtensionObjectsSize:   createDesc("objects_extensions_size", "Total size of objects per extension", constLabels, namesWithPrefixAndExt),
+		PerPrefixPerContentTypeObjectCount: createDesc("objects_content_type_count", "Repartition of objects per file ContentType", constLabels, namesWithPrefixAndContentType),
+		PerPrefixPerContentTypeObjectsSize: createDesc("objects_content_type_size", "Total size of objects per ContentType", constLabels, namesWithPrefixAndContentType),
+		PerPrefixObjectsAgeHistogram:       createDesc("objects_age_seconds", "Histogram showing the time elapsed since objects were last modified across prefixes", constLabels, namesWithPrefix),
+		PerPrefixOldestObject:              createDesc("objects_oldest_date", "Modification date of the oldest object across prefixes", constLabels, namesWithPrefix),
+		PerPrefixNewestObject:              createDesc("objects_newest_date", "Modification date of the newest object across prefixes", constLabels, namesWithPrefix),
+		PerPrefixMetadataSampledCount:      createDesc("objects_metadata_sampled_count", "Number of objects whose metadata was read across prefixes", constLabels, namesWithPrefix),
+		PerPrefixMetadataSampledSize:       createDesc("objects_metadata_sampled_size", "Volume of objects whose metadata was read across prefixes", constLabels, namesWithPrefix),
+		PerPrefixEstimatedContentTypeCount: createDesc("objects_estimated_content_type_count", "Estimated repartition of objects per ContentType, from sampled metadata", constLabels, namesWithPrefixAndContentType),
+		PerPrefixEstimatedContentTypeSize:  createDesc("objects_estimated_content_type_size", "Estimated size of objects per ContentType, from sampled metadata", constLabels, namesWithPrefixAndContentType),
+		PerPrefixEstimatedEncryptionCount:  createDesc("objects_estimated_encryption_count", "Estimated repartition of objects per server side encryption, from sampled metadata", constLabels, namesWithPrefixAndEncryption),
+		PerPrefixEstimatedEncryptionSize:   createDesc("objects_estimated_encryption_size", "Estimated size of objects per server side encryption, from sampled metadata", constLabels, namesWithPrefixAndEncryption),
+		PerPrefixEstimatedKMSKeyCount:      createDesc("objects_estimated_kms_key_count", "Estimated repartition of objects per KMS key, from sampled metadata", constLabels, namesWithPrefixAndKMSKey),
+		PerPrefixEstimatedKMSKeySize:       createDesc("objects_estimated_kms_key_size", "Estimated size of objects per KMS key, from sampled metadata", constLabels, namesWithPrefixAndKMSKey),
+		PerPrefixEstimatedReplicationCount: createDesc("objects_estimated_replication_count", "Estimated repartition of objects per replication status, from sampled metadata", constLabels, namesWithPrefixAndReplication),
+		PerPrefixEstimatedReplicationSize:  createDesc("objects_estimated_replication_size", "Estimated size of objects per replication status, from sampled metadata", constLabels, namesWithPrefixAndReplication),
+		PerPrefixEstimatedMetadataKeyCount: createDesc("objects_estimated_metadata_key_count", "Estimated number of objects having a user metadata, from sampled metadata", constLabels, namesWithPrefixAndMetadataKey),
+		PerPrefixEstimatedMetadataKeySize:  createDesc("objects_estimated_metadata_key_size", "Estimated size of objects having a user metadata, from sampled metadata", constLabels, namesWithPrefixAndMetadataKey),
+		PerPrefixTagsSampledCount:          createDesc("objects_tags_sampled_count", "Number of objects whose tags were read across prefixes", constLabels, namesWithPrefix),
+		PerPrefixTagsSampledSize:           createDesc("objects_tags_sampled_size", "Volume of objects whose tags were read across prefixes", constLabels, namesWithPrefix),
+		PerPrefixEstimatedTagCount:         createDesc("objects_estimated_tag_count", "Estimated repartition of objects per allowed tag key and value, from sampled tags; objects without the tag have an empty value", constLabels, namesWithPrefixAndTag),
+		PerPrefixEstimatedTagSize:          createDesc("objects_estimated_tag_size", "Estimated size of objects per allowed tag key and value, from sampled tags", constLabels, namesWithPrefixAndTag),
+		BucketInfo:                         createDesc("bucket_info", "Configuration of buckets; settings that could not be read are unknown", constLabels, []string{"bucket", "region", "versioning", "mfaDelete", "objectLock", "objectLockMode", "encryption"}),
+		BucketCreationDate:                 createDesc("bucket_creation_date", "Creation date of buckets", constLabels, []string{"bucket"}),
+		BucketLifecycleRules:               createDesc("bucket_lifecycle_rules", "Number of lifecycle rules of buckets", constLabels, []string{"bucket"}),
+		BucketReplicationRules:             createDesc("bucket_replication_rules", "Number of replication rules of buckets", constLabels, []string{"bucket"}),
+		BucketNotificationRules:            createDesc("bucket_notification_rules", "Number of event notification rules of buckets", constLabels, []string{"bucket"}),
+		BucketPolicy:                       createDesc("bucket_policy", "Set to 1 when buckets have a policy", constLabels, []string{"bucket"}),
+		PerPrefixUploadsCount:              createDesc("incomplete_uploads_count", "Number of incomplete multipart uploads across prefixes", constLabels, namesWithPrefix),
+		PerPrefixUploadsSize:               createDesc("incomplete_uploads_size", "Total size of the parts of incomplete multipart uploads across prefixes", constLabels, namesWithPrefix),
+		PerPrefixUploadsAgeHistogram:       createDesc("incomplete_uploads_age_seconds", "Histogram showing the time elapsed since incomplete multipart uploads were initiated", constLabels, namesWithPrefix),
+		PerPrefixVersionsCount:             createDesc("object_versions_count", "Number of object versions across prefixes, per state (current, noncurrent, delete_marker)", constLabels, namesWithPrefixAndState),
+		PerPrefixVersionsSize:              createDesc("object_versions_size", "Volume of object versions across prefixes, per state (current, noncurrent, delete_marker)", constLabels, namesWithPrefixAndState),
+		PerPrefixNoncurrentAgeHistogram:    createDesc("noncurrent_versions_age_seconds", "Histogram showing the time elapsed since noncurrent versions were replaced", constLabels, namesWithPrefix),
+		PerPrefixRetentionCount:            createDesc("objects_retention_count", "Number of objects under an active object lock retention across prefixes, per mode (GOVERNANCE, COMPLIANCE)", constLabels, namesWithPrefixAndMode),
+		PerPrefixRetentionSize:             createDesc("objects_retention_size", "Volume of objects under an active object lock retention across prefixes, per mode (GOVERNANCE, COMPLIANCE)", constLabels, namesWithPrefixAndMode),
+		PerPrefixRetainUntilHistogram:      createDesc("objects_retention_remaining_seconds", "Histogram showing the time remaining until object lock retentions expire", constLabels, namesWithPrefix),
+		PerPrefixLegalHoldCount:            createDesc("objects_legal_hold_count", "Number of objects under legal hold across prefixes", constLabels, namesWithPrefix),
+		PerPrefixLegalHoldSize:             createDesc("objects_legal_hold_size", "Volume of objects under legal hold across prefixes", constLabels, namesWithPrefix),
+
+		names: names,
+	}
 }
@@ -0,0 +1,332 @@
+package stats
+
+import (
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Outcome is the result of a walk
+type Outcome string
+
+const (
+	// OutcomeSuccess walks completed without errors
+	OutcomeSuccess Outcome = "success"
+	// OutcomePartial walks completed but some objects or buckets could not be read
+	OutcomePartial Outcome = "partial"
+	// OutcomeFailed walks could not complete
+	OutcomeFailed Outcome = "failed"
+	// OutcomeTimeout walks were aborted because they exceeded the maximum walk duration
+	OutcomeTimeout Outcome = "timeout"
+)
+
+// Outcomes lists all the walk outcomes
+var Outcomes = []Outcome{OutcomeSuccess, OutcomePartial, OutcomeFailed, OutcomeTimeout}
+
+const (
+	// ErrorPolicyKeep keeps serving the last complete snapshot when a walk has errors
+	ErrorPolicyKeep = "keep"
+	// ErrorPolicyPartial publishes the results of walks having errors, flagged as partial
+	ErrorPolicyPartial = "partial"
+)
+
+// Status describes the walks history of a Recorder
+type Status struct {
+	LastOutcome Outcome
+	// Outcomes counts the walks per outcome
+	Outcomes map[Outcome]uint64
+	// Errors counts the errors per class, across all walks
+	Errors map[string]uint64
+	// BucketErrors counts the errors per bucket, across all walks
+	BucketErrors map[string]uint64
+	// EnrichmentErrors counts the errors of optional enrichments per feature and class, across all walks; unlike
+	// walk errors, they do not make walks partial
+	EnrichmentErrors map[string]map[string]uint64
+	// Requests counts the API requests per operation and status, across all walks
+	Requests map[string]map[string]uint64
+	// Throttled counts the API requests rejected by throttling per operation, across all walks
+	Throttled map[string]uint64
+	// Latencies holds the latency (seconds) of the API requests per operation, across all walks
+	Latencies map[string]*Histogram
+}
+
+// RequestLatencyBuckets are the upper bounds (seconds) of the API requests latency histograms
+var RequestLatencyBuckets = ExponentialBounds(0.005, 2, 12)
+
+// Recorder builds a Snapshot during a walk and publishes it atomically once the walk completed.
+// Published snapshots are never modified, readers can use them without locking.
+type Recorder struct {
+	sizeBuckets []float64
+	ageBuckets  []float64
+	errorPolicy string
+
+	mutex      sync.Mutex
+	building   *Snapshot
+	generation uint64
+	status     Status
+
+	published atomic.Value // *Snapshot
+}
+
+func NewRecorder(sizeBuckets []float64, ageBuckets []float64, errorPolicy string) *Recorder {
+	return &Recorder{
+		sizeBuckets: sizeBuckets,
+		ageBuckets:  ageBuckets,
+		errorPolicy: errorPolicy,
+		building:    NewSnapshot(sizeBuckets, ageBuckets),
+		status: Status{
+			Outcomes:         map[Outcome]uint64{},
+			Errors:           map[string]uint64{},
+			BucketErrors:     map[string]uint64{},
+			EnrichmentErrors: map[string]map[string]uint64{},
+			Requests:         map[string]map[string]uint64{},
+			Throttled:        map[string]uint64{},
+			Latencies:        map[string]*Histogram{},
+		},
+	}
+}
+
+func (r *Recorder) ProcessFile(prefix string, size uint64, depth uint64, ext string, contentType string, modTime time.Time, labels map[string]string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.ProcessFile(prefix, size, depth, ext, contentType, modTime, labels)
+}
+
+func (r *Recorder) ProcessUsage(prefix string, objects uint64, size uint64, sizes *Histogram, labels map[string]string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.ProcessUsage(prefix, objects, size, sizes, labels)
+}
+
+func (r *Recorder) ProcessUpload(prefix string, size uint64, age time.Duration, labels map[string]string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.ProcessUpload(prefix, size, age, labels)
+}
+
+func (r *Recorder) ProcessVersion(prefix string, state string, size uint64, noncurrentAge time.Duration, labels map[string]string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.ProcessVersion(prefix, state, size, noncurrentAge, labels)
+}
+
+func (r *Recorder) ProcessMetadata(prefix string, size uint64, metadata ObjectMetadata, labels map[string]string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.ProcessMetadata(prefix, size, metadata, labels)
+}
+
+func (r *Recorder) ProcessTags(prefix string, size uint64, tags map[string]string, labels map[string]string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.ProcessTags(prefix, size, tags, labels)
+}
+
+func (r *Recorder) ProcessRetention(prefix string, size uint64, mode string, remaining time.Duration, legalHold bool, labels map[string]string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.ProcessRetention(prefix, size, mode, remaining, legalHold, labels)
+}
+
+// RecordBucket sets the configuration of bucket for the current walk
+func (r *Recorder) RecordBucket(bucket string, config BucketConfig) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.RecordBucket(bucket, config)
+}
+
+// RecordUsageDate sets the date of the usage reported by the storage for the current walk
+func (r *Recorder) RecordUsageDate(date time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.RecordUsageDate(date)
+}
+
+// RecordCrossCheck sets the objects of bucket counted by listing for the current walk
+func (r *Recorder) RecordCrossCheck(bucket string, check CrossCheck) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.RecordCrossCheck(bucket, check)
+}
+
+// RecordUser sets the usage and quota of user for the current walk
+func (r *Recorder) RecordUser(user string, usage UserUsage) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.RecordUser(user, usage)
+}
+
+// RecordQuota sets the quota of bucket for the current walk
+func (r *Recorder) RecordQuota(bucket string, quota Quota) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.RecordQuota(bucket, quota)
+}
+
+// Merge adds the aggregates of snapshot to the current walk
+func (r *Recorder) Merge(snapshot *Snapshot) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.Merge(snapshot)
+}
+
+// RecordError accounts an error of the given class for the current walk
+func (r *Recorder) RecordError(class string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.Errors[class]++
+	r.status.Errors[class]++
+}
+
+// RecordBucketError accounts an error of the given class met while walking bucket for the current walk
+func (r *Recorder) RecordBucketError(bucket string, class string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.Errors[class]++
+	r.status.Errors[class]++
+	r.status.BucketErrors[bucket]++
+}
+
+// RecordEnrichmentError accounts an error of the given class met while reading feature, an optional enrichment of the
+// objects or buckets (metadata, tags, ...). The walk stays complete.
+func (r *Recorder) RecordEnrichmentError(feature string, class string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	classes, ok := r.status.EnrichmentErrors[feature]
+	if !ok {
+		classes = map[string]uint64{}
+		r.status.EnrichmentErrors[feature] = classes
+	}
+	classes[class]++
+}
+
+// RecordRequest accounts an API request of operation answered with status after latency, and its estimated cost.
+// Throttled requests were rejected because of the request rate.
+func (r *Recorder) RecordRequest(operation string, status string, latency time.Duration, throttled bool, cost float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building.RecordRequest(operation, 1, cost)
+
+	statuses, ok := r.status.Requests[operation]
+	if !ok {
+		statuses = map[string]uint64{}
+		r.status.Requests[operation] = statuses
+	}
+	statuses[status]++
+	if throttled {
+		r.status.Throttled[operation]++
+	}
+	histogram, ok := r.status.Latencies[operation]
+	if !ok {
+		histogram = NewHistogram(RequestLatencyBuckets)
+		r.status.Latencies[operation] = histogram
+	}
+	histogram.Observe(latency.Seconds())
+}
+
+func (r *Recorder) StartProcessing() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building = r.newSnapshot()
+	r.building.StartTime = time.Now()
+}
+
+// EndProcessing completes the walk. Walks without errors are published under a new generation;
+// walks with errors are partial and only published, flagged as such, with the partial error policy.
+func (r *Recorder) EndProcessing() Outcome {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	outcome := OutcomeSuccess
+	if len(r.building.Errors) > 0 {
+		outcome = OutcomePartial
+	}
+	r.recordOutcome(outcome)
+
+	if outcome == OutcomePartial && r.errorPolicy != ErrorPolicyPartial {
+		log.Warnf("Walk completed with errors %v, keeping previous results", r.building.Errors)
+		r.building = r.newSnapshot()
+		return outcome
+	}
+
+	r.generation++
+	r.building.Generation = r.generation
+	r.building.EndTime = time.Now()
+	r.building.Partial = outcome == OutcomePartial
+	r.published.Store(r.building)
+	r.building = r.newSnapshot()
+	return outcome
+}
+
+// AbortProcessing drops the snapshot being built; the previous one stays available
+func (r *Recorder) AbortProcessing(outcome Outcome) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	log.Warnf("Walk %s after %s, keeping previous results", outcome, time.Since(r.building.StartTime))
+	r.recordOutcome(outcome)
+	r.building = r.newSnapshot()
+}
+
+// Reset drops the snapshot being built
+func (r *Recorder) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.building = r.newSnapshot()
+}
+
+// Snapshot returns the last published snapshot, nil if no walk completed yet
+func (r *Recorder) Snapshot() *Snapshot {
+	snapshot, _ := r.published.Load().(*Snapshot)
+	return snapshot
+}
+
+// Status returns a copy of the walks history
+func (r *Recorder) Status() Status {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	status := Status{
+		LastOutcome:      r.status.LastOutcome,
+		Outcomes:         make(map[Outcome]uint64, len(r.status.Outcomes)),
+		Errors:           copyCounts(r.status.Errors),
+		BucketErrors:     copyCounts(r.status.BucketErrors),
+		EnrichmentErrors: make(map[string]map[string]uint64, len(r.status.EnrichmentErrors)),
+		Requests:         make(map[string]map[string]uint64, len(r.status.Requests)),
+		Throttled:        copyCounts(r.status.Throttled),
+		Latencies:        make(map[string]*Histogram, len(r.status.Latencies)),
+	}
+	for outcome, count := range r.status.Outcomes {
+		status.Outcomes[outcome] = count
+	}
+	for feature, classes := range r.status.EnrichmentErrors {
+		status.EnrichmentErrors[feature] = copyCounts(classes)
+	}
+	for operation, statuses := range r.status.Requests {
+		status.Requests[operation] = copyCounts(statuses)
+	}
+	for operation, histogram := range r.status.Latencies {
+		status.Latencies[operation] = NewHistogram(histogram.Bounds)
+		status.Latencies[operation].Merge(histogram)
+	}
+	return status
+}
+
+// copyCounts returns a copy of counts
+func copyCounts(counts map[string]uint64) map[string]uint64 {
+	copied := make(map[string]uint64, len(counts))
+	for key, count := range counts {
+		copied[key] = count
+	}
+	return copied
+}
+
+func (r *Recorder) newSnapshot() *Snapshot {
+	return NewSnapshot(r.sizeBuckets, r.ageBuckets)
+}
+
+func (r *Recorder) recordOutcome(outcome Outcome) {
+	r.status.LastOutcome = outcome
+	r.status.Outcomes[outcome]++
+}
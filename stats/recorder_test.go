@@ -0,0 +1,112 @@
+package stats
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRecorderPublication walks concurrently with readers of the published snapshots, the status and the collector;
+// run with -race. Readers must only see complete walks, with increasing generations.
+func TestRecorderPublication(t *testing.T) {
+	const walks, writers, objects = 20, 4, 200
+	labels := map[string]string{"bucket": "bucket"}
+	holder := NewPrometheusStatsHolder(prometheus.Labels{"target": "test"}, []string{"bucket"}, ExponentialBounds(1, 2, 4), []float64{86400}, ErrorPolicyKeep)
+	recorder := holder.(*PrometheusStats).Recorder
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(holder)
+
+	done := make(chan struct{})
+	failures := make(chan string, 100)
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			var generation uint64
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if snapshot := recorder.Snapshot(); snapshot != nil {
+					if snapshot.Generation < generation {
+						failures <- fmt.Sprintf("generation went back from %d to %d", generation, snapshot.Generation)
+					}
+					generation = snapshot.Generation
+					if series := snapshot.Series[SeriesKey(labels)]; series == nil || series.Objects != writers*objects {
+						failures <- fmt.Sprintf("generation %d was published before its walk completed", snapshot.Generation)
+					}
+				}
+				_ = recorder.Status()
+				if _, err := registry.Gather(); err != nil {
+					failures <- err.Error()
+				}
+			}
+		}()
+	}
+
+	for walk := 0; walk < walks; walk++ {
+		recorder.StartProcessing()
+		var writing sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			writing.Add(1)
+			go func(writer int) {
+				defer writing.Done()
+				for j := 0; j < objects; j++ {
+					recorder.ProcessFile(fmt.Sprintf("p%d", writer), uint64(j), 1, ".txt", "text/plain", time.Now(), labels)
+					recorder.RecordRequest("ListObjectsV2", "200", time.Millisecond, false, 0)
+				}
+			}(i)
+		}
+		writing.Wait()
+		if outcome := recorder.EndProcessing(); outcome != OutcomeSuccess {
+			t.Fatalf("walk %d: expected a successful outcome, got %s", walk, outcome)
+		}
+	}
+	close(done)
+	readers.Wait()
+	close(failures)
+	for failure := range failures {
+		t.Error(failure)
+	}
+
+	snapshot := recorder.Snapshot()
+	if snapshot.Generation != walks {
+		t.Errorf("expected generation %d, got %d", walks, snapshot.Generation)
+	}
+	if requests := recorder.Status().Requests["ListObjectsV2"]["200"]; requests != walks*writers*objects {
+		t.Errorf("expected %d requests, got %d", walks*writers*objects, requests)
+	}
+}
+
+// TestRecorderErrorPolicy checks that walks with errors keep the previous snapshot unless partial results are published
+func TestRecorderErrorPolicy(t *testing.T) {
+	labels := map[string]string{"bucket": "bucket"}
+	for _, policy := range []string{ErrorPolicyKeep, ErrorPolicyPartial} {
+		recorder := NewRecorder(nil, nil, policy)
+		recorder.StartProcessing()
+		recorder.ProcessFile("ROOT", 1, 1, "", "", time.Time{}, labels)
+		recorder.EndProcessing()
+
+		recorder.StartProcessing()
+		recorder.ProcessFile("ROOT", 1, 1, "", "", time.Time{}, labels)
+		recorder.ProcessFile("ROOT", 1, 1, "", "", time.Time{}, labels)
+		recorder.RecordBucketError("bucket", "access_denied")
+		if outcome := recorder.EndProcessing(); outcome != OutcomePartial {
+			t.Fatalf("%s: expected a partial outcome, got %s", policy, outcome)
+		}
+
+		snapshot := recorder.Snapshot()
+		expected := uint64(1)
+		if policy == ErrorPolicyPartial {
+			expected = 2
+		}
+		if objects := snapshot.Series[SeriesKey(labels)].Objects; objects != expected || snapshot.Partial != (policy == ErrorPolicyPartial) {
+			t.Errorf("%s: expected %d objects, got %d (partial: %t)", policy, expected, objects, snapshot.Partial)
+		}
+	}
+}
@@ -0,0 +1,590 @@
+package stats
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot holds the results of one walk, independently of how they are exposed
+type Snapshot struct {
+	// Generation identifies the walk; it is incremented each time a walk is published
+	Generation uint64    `json:"generation"`
+	StartTime  time.Time `json:"startTime"`
+	// EndTime is the completion date of the walk
+	EndTime time.Time `json:"endTime"`
+	// Partial is set when the walk had errors and results may be incomplete
+	Partial bool `json:"partial"`
+	// Errors counts the errors of the walk per class
+	Errors map[string]uint64 `json:"errors"`
+	// SizeBuckets are the upper bounds (bytes) of the objects size histograms
+	SizeBuckets []float64 `json:"sizeBuckets"`
+	// AgeBuckets are the upper bounds (seconds) of the age histograms (objects, uploads, noncurrent versions)
+	AgeBuckets []float64 `json:"ageBuckets"`
+	// Series holds the aggregates per set of walker labels (bucket, storage class, ...), keyed by SeriesKey
+	Series map[string]*Series `json:"series"`
+	// Buckets holds the configuration of the walked buckets, when collected
+	Buckets map[string]*BucketConfig `json:"buckets,omitempty"`
+	// Requests counts the API requests of the walk per operation, when the walker accounts them
+	Requests map[string]uint64 `json:"requests,omitempty"`
+	// RequestsCost is the estimated cost of the API requests of the walk
+	RequestsCost float64 `json:"requestsCost,omitempty"`
+	// UsageDate is the date of the usage reported by the storage, for walkers reading it rather than listing objects
+	UsageDate time.Time `json:"usageDate"`
+	// CrossChecks holds the objects of buckets counted by listing, to cross-check the usage reported by the storage
+	CrossChecks map[string]*CrossCheck `json:"crossChecks,omitempty"`
+	// Users holds the usage and quota of the users of the storage, for walkers reading them from the storage
+	Users map[string]*UserUsage `json:"users,omitempty"`
+	// Quotas holds the quota of buckets, for walkers reading them from the storage
+	Quotas map[string]*Quota `json:"quotas,omitempty"`
+}
+
+// Series aggregates the objects sharing the same walker labels
+type Series struct {
+	Labels   map[string]string       `json:"labels"`
+	MaxDepth uint64                  `json:"maxDepth"`
+	Objects  uint64                  `json:"objects"`
+	Size     uint64                  `json:"size"`
+	Prefixes map[string]*PrefixStats `json:"prefixes"`
+	// Uploads aggregates the incomplete multipart uploads per prefix
+	Uploads map[string]*UploadStats `json:"uploads,omitempty"`
+	// Versions aggregates the object versions per prefix, when versions are listed
+	Versions map[string]*VersionStats `json:"versions,omitempty"`
+	// Metadata aggregates the metadata of the sampled objects per prefix
+	Metadata map[string]*MetadataStats `json:"metadata,omitempty"`
+	// Tags aggregates the tags of the sampled objects per prefix
+	Tags map[string]*TagStats `json:"tags,omitempty"`
+	// Retention aggregates the object lock protection of the objects of object lock enabled buckets per prefix
+	Retention map[string]*RetentionStats `json:"retention,omitempty"`
+}
+
+// PrefixStats aggregates the objects of a prefix; SizeHistogram is nil when the size of some of them is unknown
+type PrefixStats struct {
+	Objects       uint64            `json:"objects"`
+	Size          uint64            `json:"size"`
+	SizeHistogram *Histogram        `json:"sizeHistogram"`
+	Extensions    map[string]*Usage `json:"extensions"`
+	ContentTypes  map[string]*Usage `json:"contentTypes"`
+	// AgeHistogram is the repartition of the time elapsed since the objects were last modified
+	AgeHistogram *Histogram `json:"ageHistogram"`
+	// Oldest and Newest are the modification dates of the oldest and newest objects, zero when unknown
+	Oldest time.Time `json:"oldest"`
+	Newest time.Time `json:"newest"`
+}
+
+// UploadStats aggregates the incomplete multipart uploads of a prefix
+type UploadStats struct {
+	Count uint64 `json:"count"`
+	// Size is the total size of the parts uploaded so far
+	Size uint64 `json:"size"`
+	// AgeHistogram is the repartition of the time elapsed since the uploads were initiated
+	AgeHistogram *Histogram `json:"ageHistogram"`
+}
+
+// VersionStats aggregates the object versions of a prefix
+type VersionStats struct {
+	// States holds the versions per state: VersionCurrent, VersionNoncurrent or VersionDeleteMarker
+	States map[string]*Usage `json:"states"`
+	// NoncurrentAgeHistogram is the repartition of the time elapsed since versions became noncurrent
+	NoncurrentAgeHistogram *Histogram `json:"noncurrentAgeHistogram"`
+}
+
+// MetadataStats aggregates the metadata of the sampled objects of a prefix.
+// Breakdowns only cover the sampled objects; they are scaled to the prefix totals to be estimated.
+type MetadataStats struct {
+	Sampled             Usage             `json:"sampled"`
+	ContentTypes        map[string]*Usage `json:"contentTypes"`
+	Encryptions         map[string]*Usage `json:"encryptions"`
+	KMSKeys             map[string]*Usage `json:"kmsKeys"`
+	ReplicationStatuses map[string]*Usage `json:"replicationStatuses"`
+	MetadataKeys        map[string]*Usage `json:"metadataKeys"`
+}
+
+// TagStats aggregates the tags of the sampled objects of a prefix.
+// Like metadata, breakdowns only cover the sampled objects and are scaled to be estimated.
+type TagStats struct {
+	Sampled Usage `json:"sampled"`
+	// Values holds the objects per tag key and value; objects without the tag have an empty value
+	Values map[string]map[string]*Usage `json:"values"`
+}
+
+// RetentionStats aggregates the object lock protection of the objects of a prefix
+type RetentionStats struct {
+	// Modes holds the objects under an active retention per mode: GOVERNANCE or COMPLIANCE
+	Modes map[string]*Usage `json:"modes"`
+	// RetainUntilHistogram is the repartition of the time remaining until active retentions expire
+	RetainUntilHistogram *Histogram `json:"retainUntilHistogram"`
+	LegalHold            Usage      `json:"legalHold"`
+}
+
+const (
+	// VersionCurrent is the state of the latest version of objects
+	VersionCurrent = "current"
+	// VersionNoncurrent is the state of versions replaced by a newer version or a delete marker
+	VersionNoncurrent = "noncurrent"
+	// VersionDeleteMarker is the state of delete markers
+	VersionDeleteMarker = "delete_marker"
+)
+
+// Usage is a number of objects and their total size
+type Usage struct {
+	Objects uint64 `json:"objects"`
+	Size    uint64 `json:"size"`
+}
+
+// CrossCheck is the objects of a bucket counted by a listing, at Date. Mismatch tells whether they differ from the
+// usage reported by the storage at that time.
+type CrossCheck struct {
+	Usage
+	Date     time.Time `json:"date"`
+	Mismatch bool      `json:"mismatch"`
+}
+
+// Quota limits the objects of a bucket or of a user; zero limits are unlimited
+type Quota struct {
+	MaxObjects uint64 `json:"maxObjects,omitempty"`
+	MaxSize    uint64 `json:"maxSize,omitempty"`
+}
+
+// UserUsage is the usage of the buckets of a user of the storage, and its quota
+type UserUsage struct {
+	Usage
+	Quota Quota `json:"quota"`
+}
+
+func NewSnapshot(sizeBuckets []float64, ageBuckets []float64) *Snapshot {
+	return &Snapshot{
+		SizeBuckets: sizeBuckets,
+		AgeBuckets:  ageBuckets,
+		Series:      map[string]*Series{},
+		Errors:      map[string]uint64{},
+	}
+}
+
+// Duration is the time spent walking
+func (s *Snapshot) Duration() time.Duration {
+	return s.EndTime.Sub(s.StartTime)
+}
+
+// ProcessFile accounts one object of the given prefix in the series matching labels.
+// The age of objects is only accounted when modTime is known.
+func (s *Snapshot) ProcessFile(prefix string, size uint64, depth uint64, ext string, contentType string, modTime time.Time, labels map[string]string) {
+	series := s.series(labels)
+	if depth > series.MaxDepth {
+		series.MaxDepth = depth
+	}
+	series.Objects++
+	series.Size += size
+
+	prefixStats := series.prefix(prefix, s.SizeBuckets, s.AgeBuckets)
+	prefixStats.Objects++
+	prefixStats.Size += size
+	if prefixStats.SizeHistogram != nil {
+		prefixStats.SizeHistogram.Observe(float64(size))
+	}
+	addUsage(prefixStats.Extensions, ext, size)
+	addUsage(prefixStats.ContentTypes, contentType, size)
+	if !modTime.IsZero() {
+		prefixStats.AgeHistogram.Observe(time.Since(modTime).Seconds())
+		prefixStats.observeModTime(modTime, modTime)
+	}
+}
+
+// ProcessUsage accounts objects of the given prefix known from the usage aggregated by the storage rather than listed:
+// objects and size are their totals, and sizes their size histogram, with the size buckets of the snapshot, or nil
+// when the storage does not know the size of the objects
+func (s *Snapshot) ProcessUsage(prefix string, objects uint64, size uint64, sizes *Histogram, labels map[string]string) {
+	series := s.series(labels)
+	series.Objects += objects
+	series.Size += size
+
+	prefixStats := series.prefix(prefix, s.SizeBuckets, s.AgeBuckets)
+	prefixStats.Objects += objects
+	prefixStats.Size += size
+	prefixStats.mergeSizes(sizes)
+}
+
+// ProcessUpload accounts one incomplete multipart upload of the given prefix in the series matching labels
+func (s *Snapshot) ProcessUpload(prefix string, size uint64, age time.Duration, labels map[string]string) {
+	uploadStats := s.series(labels).upload(prefix, s.AgeBuckets)
+	uploadStats.Count++
+	uploadStats.Size += size
+	uploadStats.AgeHistogram.Observe(age.Seconds())
+}
+
+// ProcessVersion accounts one object version of the given prefix in the series matching labels.
+// noncurrentAge is the time elapsed since noncurrent versions were replaced.
+func (s *Snapshot) ProcessVersion(prefix string, state string, size uint64, noncurrentAge time.Duration, labels map[string]string) {
+	versionStats := s.series(labels).version(prefix, s.AgeBuckets)
+	addUsage(versionStats.States, state, size)
+	if state == VersionNoncurrent {
+		versionStats.NoncurrentAgeHistogram.Observe(noncurrentAge.Seconds())
+	}
+}
+
+// ProcessMetadata accounts the metadata of one sampled object of the given prefix in the series matching labels
+func (s *Snapshot) ProcessMetadata(prefix string, size uint64, metadata ObjectMetadata, labels map[string]string) {
+	metadataStats := s.series(labels).metadata(prefix)
+	metadataStats.Sampled.Objects++
+	metadataStats.Sampled.Size += size
+	addUsage(metadataStats.ContentTypes, metadata.ContentType, size)
+	addUsage(metadataStats.Encryptions, metadata.Encryption, size)
+	if metadata.KMSKeyID != "" {
+		addUsage(metadataStats.KMSKeys, metadata.KMSKeyID, size)
+	}
+	addUsage(metadataStats.ReplicationStatuses, metadata.ReplicationStatus, size)
+	for _, key := range metadata.MetadataKeys {
+		addUsage(metadataStats.MetadataKeys, key, size)
+	}
+}
+
+// ProcessTags accounts the tags of one sampled object of the given prefix in the series matching labels
+func (s *Snapshot) ProcessTags(prefix string, size uint64, tags map[string]string, labels map[string]string) {
+	tagStats := s.series(labels).tags(prefix)
+	tagStats.Sampled.Objects++
+	tagStats.Sampled.Size += size
+	for key, value := range tags {
+		addUsage(tagStats.values(key), value, size)
+	}
+}
+
+// ProcessRetention accounts the object lock protection of one object of the given prefix in the series matching labels.
+// mode is empty when the object has no active retention, remaining is the time left until its retention expires.
+func (s *Snapshot) ProcessRetention(prefix string, size uint64, mode string, remaining time.Duration, legalHold bool, labels map[string]string) {
+	retentionStats := s.series(labels).retention(prefix, s.AgeBuckets)
+	if mode != "" {
+		addUsage(retentionStats.Modes, mode, size)
+		retentionStats.RetainUntilHistogram.Observe(remaining.Seconds())
+	}
+	if legalHold {
+		retentionStats.LegalHold.Objects++
+		retentionStats.LegalHold.Size += size
+	}
+}
+
+// RecordBucket sets the configuration of bucket
+func (s *Snapshot) RecordBucket(bucket string, config BucketConfig) {
+	if s.Buckets == nil {
+		s.Buckets = map[string]*BucketConfig{}
+	}
+	s.Buckets[bucket] = &config
+}
+
+// Merge adds the aggregates of other to the snapshot; both must use the same histogram buckets
+func (s *Snapshot) Merge(other *Snapshot) {
+	for bucket, config := range other.Buckets {
+		s.RecordBucket(bucket, *config)
+	}
+	for _, series := range other.Series {
+		s.series(series.Labels).merge(series, s.SizeBuckets, s.AgeBuckets)
+	}
+	for class, count := range other.Errors {
+		s.Errors[class] += count
+	}
+	for operation, count := range other.Requests {
+		s.RecordRequest(operation, count, 0)
+	}
+	s.RequestsCost += other.RequestsCost
+	if other.UsageDate.After(s.UsageDate) {
+		s.UsageDate = other.UsageDate
+	}
+	for bucket, check := range other.CrossChecks {
+		s.RecordCrossCheck(bucket, *check)
+	}
+	for user, usage := range other.Users {
+		s.RecordUser(user, *usage)
+	}
+	for bucket, quota := range other.Quotas {
+		s.RecordQuota(bucket, *quota)
+	}
+}
+
+// RecordRequest accounts count API requests of operation costing cost
+func (s *Snapshot) RecordRequest(operation string, count uint64, cost float64) {
+	if s.Requests == nil {
+		s.Requests = map[string]uint64{}
+	}
+	s.Requests[operation] += count
+	s.RequestsCost += cost
+}
+
+// RecordUsageDate sets the date of the usage reported by the storage
+func (s *Snapshot) RecordUsageDate(date time.Time) {
+	s.UsageDate = date
+}
+
+// RecordCrossCheck sets the objects of bucket counted by listing
+func (s *Snapshot) RecordCrossCheck(bucket string, check CrossCheck) {
+	if s.CrossChecks == nil {
+		s.CrossChecks = map[string]*CrossCheck{}
+	}
+	s.CrossChecks[bucket] = &check
+}
+
+// RecordUser sets the usage and quota of user
+func (s *Snapshot) RecordUser(user string, usage UserUsage) {
+	if s.Users == nil {
+		s.Users = map[string]*UserUsage{}
+	}
+	s.Users[user] = &usage
+}
+
+// RecordQuota sets the quota of bucket
+func (s *Snapshot) RecordQuota(bucket string, quota Quota) {
+	if s.Quotas == nil {
+		s.Quotas = map[string]*Quota{}
+	}
+	s.Quotas[bucket] = &quota
+}
+
+// SortedSeries returns the series ordered by key, for stable rendering
+func (s *Snapshot) SortedSeries() []*Series {
+	keys := make([]string, 0, len(s.Series))
+	for key := range s.Series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	series := make([]*Series, 0, len(keys))
+	for _, key := range keys {
+		series = append(series, s.Series[key])
+	}
+	return series
+}
+
+func (s *Snapshot) series(labels map[string]string) *Series {
+	key := SeriesKey(labels)
+	series, ok := s.Series[key]
+	if !ok {
+		series = &Series{
+			Labels:   copyLabels(labels),
+			Prefixes: map[string]*PrefixStats{},
+		}
+		s.Series[key] = series
+	}
+	return series
+}
+
+func (s *Series) prefix(prefix string, sizeBuckets []float64, ageBuckets []float64) *PrefixStats {
+	prefixStats, ok := s.Prefixes[prefix]
+	if !ok {
+		prefixStats = &PrefixStats{
+			SizeHistogram: NewHistogram(sizeBuckets),
+			AgeHistogram:  NewHistogram(ageBuckets),
+			Extensions:    map[string]*Usage{},
+			ContentTypes:  map[string]*Usage{},
+		}
+		s.Prefixes[prefix] = prefixStats
+	}
+	return prefixStats
+}
+
+func (s *Series) upload(prefix string, ageBuckets []float64) *UploadStats {
+	if s.Uploads == nil {
+		s.Uploads = map[string]*UploadStats{}
+	}
+	uploadStats, ok := s.Uploads[prefix]
+	if !ok {
+		uploadStats = &UploadStats{AgeHistogram: NewHistogram(ageBuckets)}
+		s.Uploads[prefix] = uploadStats
+	}
+	return uploadStats
+}
+
+func (s *Series) version(prefix string, ageBuckets []float64) *VersionStats {
+	if s.Versions == nil {
+		s.Versions = map[string]*VersionStats{}
+	}
+	versionStats, ok := s.Versions[prefix]
+	if !ok {
+		versionStats = &VersionStats{
+			States:                 map[string]*Usage{},
+			NoncurrentAgeHistogram: NewHistogram(ageBuckets),
+		}
+		s.Versions[prefix] = versionStats
+	}
+	return versionStats
+}
+
+func (s *Series) metadata(prefix string) *MetadataStats {
+	if s.Metadata == nil {
+		s.Metadata = map[string]*MetadataStats{}
+	}
+	metadataStats, ok := s.Metadata[prefix]
+	if !ok {
+		metadataStats = &MetadataStats{
+			ContentTypes:        map[string]*Usage{},
+			Encryptions:         map[string]*Usage{},
+			KMSKeys:             map[string]*Usage{},
+			ReplicationStatuses: map[string]*Usage{},
+			MetadataKeys:        map[string]*Usage{},
+		}
+		s.Metadata[prefix] = metadataStats
+	}
+	return metadataStats
+}
+
+func (s *Series) tags(prefix string) *TagStats {
+	if s.Tags == nil {
+		s.Tags = map[string]*TagStats{}
+	}
+	tagStats, ok := s.Tags[prefix]
+	if !ok {
+		tagStats = &TagStats{Values: map[string]map[string]*Usage{}}
+		s.Tags[prefix] = tagStats
+	}
+	return tagStats
+}
+
+func (s *Series) retention(prefix string, ageBuckets []float64) *RetentionStats {
+	if s.Retention == nil {
+		s.Retention = map[string]*RetentionStats{}
+	}
+	retentionStats, ok := s.Retention[prefix]
+	if !ok {
+		retentionStats = &RetentionStats{
+			Modes:                map[string]*Usage{},
+			RetainUntilHistogram: NewHistogram(ageBuckets),
+		}
+		s.Retention[prefix] = retentionStats
+	}
+	return retentionStats
+}
+
+func (t *TagStats) values(key string) map[string]*Usage {
+	values, ok := t.Values[key]
+	if !ok {
+		values = map[string]*Usage{}
+		t.Values[key] = values
+	}
+	return values
+}
+
+func (s *Series) merge(other *Series, sizeBuckets []float64, ageBuckets []float64) {
+	if other.MaxDepth > s.MaxDepth {
+		s.MaxDepth = other.MaxDepth
+	}
+	s.Objects += other.Objects
+	s.Size += other.Size
+
+	for prefix, otherPrefix := range other.Prefixes {
+		prefixStats := s.prefix(prefix, sizeBuckets, ageBuckets)
+		prefixStats.Objects += otherPrefix.Objects
+		prefixStats.Size += otherPrefix.Size
+		prefixStats.mergeSizes(otherPrefix.SizeHistogram)
+		mergeUsages(prefixStats.Extensions, otherPrefix.Extensions)
+		mergeUsages(prefixStats.ContentTypes, otherPrefix.ContentTypes)
+		prefixStats.AgeHistogram.Merge(otherPrefix.AgeHistogram)
+		if !otherPrefix.Oldest.IsZero() {
+			prefixStats.observeModTime(otherPrefix.Oldest, otherPrefix.Newest)
+		}
+	}
+
+	for prefix, otherUploads := range other.Uploads {
+		uploadStats := s.upload(prefix, ageBuckets)
+		uploadStats.Count += otherUploads.Count
+		uploadStats.Size += otherUploads.Size
+		uploadStats.AgeHistogram.Merge(otherUploads.AgeHistogram)
+	}
+
+	for prefix, otherVersions := range other.Versions {
+		versionStats := s.version(prefix, ageBuckets)
+		mergeUsages(versionStats.States, otherVersions.States)
+		versionStats.NoncurrentAgeHistogram.Merge(otherVersions.NoncurrentAgeHistogram)
+	}
+
+	for prefix, otherMetadata := range other.Metadata {
+		metadataStats := s.metadata(prefix)
+		metadataStats.Sampled.Objects += otherMetadata.Sampled.Objects
+		metadataStats.Sampled.Size += otherMetadata.Sampled.Size
+		mergeUsages(metadataStats.ContentTypes, otherMetadata.ContentTypes)
+		mergeUsages(metadataStats.Encryptions, otherMetadata.Encryptions)
+		mergeUsages(metadataStats.KMSKeys, otherMetadata.KMSKeys)
+		mergeUsages(metadataStats.ReplicationStatuses, otherMetadata.ReplicationStatuses)
+		mergeUsages(metadataStats.MetadataKeys, otherMetadata.MetadataKeys)
+	}
+
+	for prefix, otherTags := range other.Tags {
+		tagStats := s.tags(prefix)
+		tagStats.Sampled.Objects += otherTags.Sampled.Objects
+		tagStats.Sampled.Size += otherTags.Sampled.Size
+		for key, values := range otherTags.Values {
+			mergeUsages(tagStats.values(key), values)
+		}
+	}
+
+	for prefix, otherRetention := range other.Retention {
+		retentionStats := s.retention(prefix, ageBuckets)
+		mergeUsages(retentionStats.Modes, otherRetention.Modes)
+		retentionStats.RetainUntilHistogram.Merge(otherRetention.RetainUntilHistogram)
+		retentionStats.LegalHold.Objects += otherRetention.LegalHold.Objects
+		retentionStats.LegalHold.Size += otherRetention.LegalHold.Size
+	}
+}
+
+// mergeSizes adds the size histogram sizes, dropping the size histogram of the prefix when sizes is nil
+func (p *PrefixStats) mergeSizes(sizes *Histogram) {
+	if sizes == nil {
+		p.SizeHistogram = nil
+	} else if p.SizeHistogram != nil {
+		p.SizeHistogram.Merge(sizes)
+	}
+}
+
+// observeModTime extends the range of modification dates of the prefix to oldest and newest
+func (p *PrefixStats) observeModTime(oldest time.Time, newest time.Time) {
+	if p.Oldest.IsZero() || oldest.Before(p.Oldest) {
+		p.Oldest = oldest
+	}
+	if newest.After(p.Newest) {
+		p.Newest = newest
+	}
+}
+
+func mergeUsages(usages map[string]*Usage, others map[string]*Usage) {
+	for key, other := range others {
+		usage, ok := usages[key]
+		if !ok {
+			usage = &Usage{}
+			usages[key] = usage
+		}
+		usage.Objects += other.Objects
+		usage.Size += other.Size
+	}
+}
+
+func addUsage(usages map[string]*Usage, key string, size uint64) {
+	usage, ok := usages[key]
+	if !ok {
+		usage = &Usage{}
+		usages[key] = usage
+	}
+	usage.Objects++
+	usage.Size += size
+}
+
+// SeriesKey identifies a set of labels, whatever the order they were built in
+func SeriesKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var key strings.Builder
+	for _, name := range names {
+		key.WriteString(name)
+		key.WriteByte('=')
+		key.WriteString(labels[name])
+		key.WriteByte(0)
+	}
+	return key.String()
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	copied := make(map[string]string, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
+}
@@ -1,12 +1,41 @@
 package stats
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// FileProcessor accounts files, aggregated usage, incomplete uploads, object versions, objects metadata, tags and
+// retention, see Snapshot.ProcessFile, Snapshot.ProcessUsage, Snapshot.ProcessUpload, Snapshot.ProcessVersion,
+// Snapshot.ProcessMetadata, Snapshot.ProcessTags and Snapshot.ProcessRetention
+type FileProcessor interface {
+	ProcessFile(prefix string, size uint64, depth uint64, ext string, contentType string, modTime time.Time, labels map[string]string)
+	ProcessUsage(prefix string, objects uint64, size uint64, sizes *Histogram, labels map[string]string)
+	ProcessUpload(prefix string, size uint64, age time.Duration, labels map[string]string)
+	ProcessVersion(prefix string, state string, size uint64, noncurrentAge time.Duration, labels map[string]string)
+	ProcessMetadata(prefix string, size uint64, metadata ObjectMetadata, labels map[string]string)
+	ProcessTags(prefix string, size uint64, tags map[string]string, labels map[string]string)
+	ProcessRetention(prefix string, size uint64, mode string, remaining time.Duration, legalHold bool, labels map[string]string)
+}
 
 type StatsInterface interface {
-	ProcessFile(prefix string, size uint64, depth uint64, ext string, contentType string, labels map[string]string)
-	EndProcessing()
+	FileProcessor
+	Merge(snapshot *Snapshot)
+	RecordBucket(bucket string, config BucketConfig)
+	RecordError(class string)
+	RecordBucketError(bucket string, class string)
+	RecordEnrichmentError(feature string, class string)
+	RecordRequest(operation string, status string, latency time.Duration, throttled bool, cost float64)
+	RecordUsageDate(date time.Time)
+	RecordCrossCheck(bucket string, check CrossCheck)
+	RecordUser(user string, usage UserUsage)
+	RecordQuota(bucket string, quota Quota)
+	EndProcessing() Outcome
+	AbortProcessing(outcome Outcome)
 	StartProcessing()
 	Reset()
+	Snapshot() *Snapshot
+	Status() Status
 	Describe(descs chan<- *prometheus.Desc)
 	Collect(metrics chan<- prometheus.Metric)
 }
@@ -0,0 +1,126 @@
+package targets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/willena/s3-exporter/config"
+	"github.com/willena/s3-exporter/utils"
+	"github.com/willena/s3-exporter/walker"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Manager schedules the walks of the targets and exposes their results
+type Manager struct {
+	applyMutex sync.Mutex
+
+	mutex   sync.RWMutex
+	running map[string]*runningTarget
+}
+
+type runningTarget struct {
+	config config.Target
+	walker walker.Walker
+	cancel context.CancelFunc
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		running: map[string]*runningTarget{},
+	}
+}
+
+// Apply makes targets the running targets. Targets whose configuration did not change keep running
+// along with their results; new and modified targets are started and removed ones are stopped.
+// If any new or modified target is invalid, an error is returned and the running targets are left untouched.
+func (m *Manager) Apply(ctx context.Context, targets []config.Target) error {
+	m.applyMutex.Lock()
+	defer m.applyMutex.Unlock()
+
+	m.mutex.RLock()
+	previous := m.running
+	m.mutex.RUnlock()
+
+	next := map[string]*runningTarget{}
+	var started []*runningTarget
+	for _, target := range targets {
+		if running, ok := previous[target.Name]; ok && reflect.DeepEqual(running.config, target) {
+			next[target.Name] = running
+			continue
+		}
+
+		walkerInst, err := walker.FromConfig(target.Name, target.Walker, target.WalkerType)
+		if err != nil {
+			return fmt.Errorf("could not create walker of target %s: %w", target.Name, err)
+		}
+		next[target.Name] = &runningTarget{config: target, walker: walkerInst}
+		started = append(started, next[target.Name])
+	}
+
+	m.mutex.Lock()
+	m.running = next
+	m.mutex.Unlock()
+
+	for name, running := range previous {
+		if next[name] != running {
+			log.Infof("Stopping target %s", name)
+			running.cancel()
+		}
+	}
+	for _, running := range started {
+		running.schedule(ctx)
+	}
+	return nil
+}
+
+func (t *runningTarget) schedule(ctx context.Context) {
+	ctx, t.cancel = context.WithCancel(ctx)
+
+	log.Infof("Scheduling walks of target %s (%s) every %s", t.config.Name, t.config.WalkerType, t.config.ScrapeInterval.String())
+	utils.Schedule(ctx, func(ctx context.Context) {
+		logWalk(t.config.Name, t.walker.Walk(ctx))
+	}, t.config.ScrapeInterval)
+}
+
+func logWalk(target string, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, walker.ErrPartialWalk):
+		log.Warnf("walk of target %s completed with errors: %s", target, err.Error())
+	case errors.Is(err, walker.ErrWalkTimeout):
+		log.Warnf("walk of target %s timed out, previous results are kept: %s", target, err.Error())
+	case errors.Is(err, context.Canceled):
+		log.Infof("walk of target %s cancelled", target)
+	default:
+		log.Errorf("could not walk target %s: %s", target, err.Error())
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+// No description is sent: targets of different types or custom labels expose the same metrics
+// with different label names, which is only allowed for unchecked collectors.
+func (m *Manager) Describe(chan<- *prometheus.Desc) {
+}
+
+// Collect implements the prometheus.Collector interface
+func (m *Manager) Collect(ch chan<- prometheus.Metric) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, name := range m.names() {
+		m.running[name].walker.Collector().Collect(ch)
+	}
+}
+
+func (m *Manager) names() []string {
+	names := make([]string, 0, len(m.running))
+	for name := range m.running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,149 @@
+package targets
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/willena/s3-exporter/config"
+	"github.com/willena/s3-exporter/walker"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testWalkerConfig are the options of the test walker; targets with different values are different targets
+type testWalkerConfig struct {
+	Value string `long:"test-value"`
+}
+
+// testWalker records the context of its walks, which last until they are cancelled
+type testWalker struct {
+	target string
+}
+
+var (
+	walksMutex sync.Mutex
+	walks      = map[string][]context.Context{}
+)
+
+func init() {
+	walker.Register(walker.Registration{
+		Name:        "test",
+		Description: "Test walker",
+		NewConfig:   func() interface{} { return &testWalkerConfig{} },
+		New:         func() walker.Walker { return &testWalker{} },
+	})
+}
+
+func (w *testWalker) Init(_ walker.Config, labels map[string]string, _ []string) error {
+	w.target = labels["target"]
+	return nil
+}
+
+func (w *testWalker) Walk(ctx context.Context) error {
+	walksMutex.Lock()
+	walks[w.target] = append(walks[w.target], ctx)
+	walksMutex.Unlock()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (w *testWalker) ValidateConfig(walker.Config) error {
+	return nil
+}
+
+func (w *testWalker) Collector() prometheus.Collector {
+	return prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_walker"})
+}
+
+func testTarget(name string, walkerType string, value string) config.Target {
+	return config.Target{
+		Name:           name,
+		WalkerType:     walkerType,
+		ScrapeInterval: time.Hour,
+		Walker: walker.Config{
+			BaseWalkerConfig: &walker.BaseWalkerConfig{Depth: 1},
+			Options:          &testWalkerConfig{Value: value},
+		},
+	}
+}
+
+// walkContexts waits for count walks of target and returns their contexts
+func walkContexts(t *testing.T, target string, count int) []context.Context {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		walksMutex.Lock()
+		contexts := append([]context.Context(nil), walks[target]...)
+		walksMutex.Unlock()
+		if len(contexts) >= count {
+			return contexts
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("target %s walked %d times, expected %d", target, len(contexts), count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func stopped(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+func TestManagerApply(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	walksMutex.Lock()
+	walks = map[string][]context.Context{}
+	walksMutex.Unlock()
+	manager := NewManager()
+
+	if err := manager.Apply(ctx, []config.Target{
+		testTarget("kept", "test", "a"),
+		testTarget("modified", "test", "a"),
+		testTarget("removed", "test", "a"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	kept := manager.running["kept"]
+	modified := manager.running["modified"]
+	keptWalk := walkContexts(t, "kept", 1)[0]
+	modifiedWalk := walkContexts(t, "modified", 1)[0]
+	removedWalk := walkContexts(t, "removed", 1)[0]
+
+	// An invalid target leaves the running targets untouched
+	if err := manager.Apply(ctx, []config.Target{testTarget("kept", "test", "a"), testTarget("invalid", "unknown", "a")}); err == nil {
+		t.Fatal("expected an error for the unknown walker type")
+	}
+	if len(manager.running) != 3 || manager.running["kept"] != kept || manager.running["modified"] != modified {
+		t.Fatalf("running targets changed after an invalid configuration: %v", manager.names())
+	}
+
+	if err := manager.Apply(ctx, []config.Target{
+		testTarget("kept", "test", "a"),
+		testTarget("modified", "test", "b"),
+		testTarget("added", "test", "a"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if names := manager.names(); len(names) != 3 || names[0] != "added" || names[1] != "kept" || names[2] != "modified" {
+		t.Fatalf("unexpected running targets %v", names)
+	}
+	if manager.running["kept"] != kept {
+		t.Error("unchanged target was restarted")
+	}
+	if manager.running["modified"] == modified || manager.running["modified"].config.Walker.Options.(*testWalkerConfig).Value != "b" {
+		t.Error("modified target was not replaced")
+	}
+	if !stopped(modifiedWalk) || !stopped(removedWalk) {
+		t.Error("walks of modified and removed targets were not cancelled")
+	}
+	if keptWalk.Err() != nil {
+		t.Error("walk of unchanged target was cancelled")
+	}
+	walkContexts(t, "modified", 2)
+	walkContexts(t, "added", 1)
+}
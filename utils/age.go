@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ageUnits = map[string]time.Duration{
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+	"M": 30 * 24 * time.Hour,
+	"y": 365 * 24 * time.Hour,
+}
+
+// ParseAge parses a duration written either with days, weeks, months of 30 days or years (e.g. 90d, 2w, 6M, 1y)
+// or as a Go duration (e.g. 12h)
+func ParseAge(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty age")
+	}
+
+	if unit, ok := ageUnits[value[len(value)-1:]]; ok {
+		count, err := strconv.ParseFloat(value[:len(value)-1], 64)
+		if err != nil || count < 0 {
+			return 0, fmt.Errorf("invalid age %q", value)
+		}
+		return time.Duration(count * float64(unit)), nil
+	}
+
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid age %q", value)
+	}
+	return age, nil
+}
+
+// ParseAgeBounds parses ages into sorted histogram bounds, in seconds
+func ParseAgeBounds(values []string) ([]float64, error) {
+	bounds := make([]float64, 0, len(values))
+	for _, value := range values {
+		age, err := ParseAge(value)
+		if err != nil {
+			return nil, err
+		}
+		bounds = append(bounds, age.Seconds())
+	}
+	sort.Float64s(bounds)
+	return bounds, nil
+}
@@ -1,20 +1,20 @@
 package utils
 
 import (
-	log "github.com/sirupsen/logrus"
+	"fmt"
 	"regexp"
 )
 
-func BuildPatternsFromStrings(names []string) []*regexp.Regexp {
+func BuildPatternsFromStrings(names []string) ([]*regexp.Regexp, error) {
 	var lists []*regexp.Regexp
 	for _, v := range names {
 		re, err := regexp.Compile(v)
 		if err != nil {
-			log.Fatal("Invalid patthern found in bucker filter param")
+			return nil, fmt.Errorf("invalid filter pattern %q: %w", v, err)
 		}
 		lists = append(lists, re)
 	}
-	return lists
+	return lists, nil
 }
 
 func MatchExclude(filters []*regexp.Regexp, name string) bool {
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"context"
+	"sync"
+)
+
+// TaskPool runs tasks with at most a fixed number of workers. Submitted tasks wait in a queue rather than in
+// goroutines of their own; workers are started when tasks are queued and stop once the queue is empty.
+// Tasks can submit other tasks to the pool; Wait returns once all of them completed.
+type TaskPool struct {
+	ctx     context.Context
+	workers int
+
+	mutex sync.Mutex
+	// changed is signaled when tasks complete
+	changed *sync.Cond
+	queue   []poolTask
+	// started counts the running workers, pending the tasks queued or running
+	started int
+	pending int
+}
+
+// poolTask is a queued task; skippable tasks are not run once the pool context is done
+type poolTask struct {
+	run       func(ctx context.Context)
+	skippable bool
+}
+
+func NewTaskPool(ctx context.Context, workers int) *TaskPool {
+	if workers < 1 {
+		workers = 1
+	}
+	pool := &TaskPool{
+		ctx:     ctx,
+		workers: workers,
+	}
+	pool.changed = sync.NewCond(&pool.mutex)
+	return pool
+}
+
+// Submit queues task without blocking. Tasks submitted, or still queued, once the pool context is done are skipped.
+func (p *TaskPool) Submit(task func(ctx context.Context)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.ctx.Err() != nil {
+		return
+	}
+	p.push(poolTask{run: task, skippable: true})
+}
+
+// SubmitWait queues task once a worker is available, bounding the number of pending tasks, and returns whether it
+// was queued. Tasks submitted once the pool context is done are skipped; queued tasks always run.
+func (p *TaskPool) SubmitWait(task func(ctx context.Context)) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Queued tasks are skipped or complete soon once the context is done, so this does not wait for long
+	for p.pending >= p.workers && p.ctx.Err() == nil {
+		p.changed.Wait()
+	}
+	if p.ctx.Err() != nil {
+		return false
+	}
+	p.push(poolTask{run: task})
+	return true
+}
+
+// Wait blocks until all the submitted tasks completed
+func (p *TaskPool) Wait() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for p.pending > 0 {
+		p.changed.Wait()
+	}
+}
+
+// push queues task, starting a worker if they are not all running; the mutex must be held
+func (p *TaskPool) push(task poolTask) {
+	p.queue = append(p.queue, task)
+	p.pending++
+	if p.started < p.workers {
+		p.started++
+		go p.work()
+	}
+}
+
+// work runs the queued tasks until the queue is empty
+func (p *TaskPool) work() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for len(p.queue) > 0 {
+		task := p.queue[0]
+		p.queue[0] = poolTask{}
+		p.queue = p.queue[1:]
+
+		p.mutex.Unlock()
+		if !task.skippable || p.ctx.Err() == nil {
+			task.run(p.ctx)
+		}
+		p.mutex.Lock()
+
+		p.pending--
+		p.changed.Broadcast()
+	}
+	p.started--
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter spaces events so that they do not exceed a rate. A nil RateLimiter does not limit anything.
+type RateLimiter struct {
+	interval time.Duration
+
+	mutex sync.Mutex
+	next  time.Time
+}
+
+// NewRateLimiter returns a limiter allowing perSecond events per second, nil when perSecond is not positive
+func NewRateLimiter(perSecond float64) *RateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// Wait blocks until the next event is allowed, or until ctx is done
+func (r *RateLimiter) Wait(ctx context.Context) error {
+	if r == nil {
+		return ctx.Err()
+	}
+
+	r.mutex.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	delay := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.mutex.Unlock()
+
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,22 +1,21 @@
 package utils
 
 import (
+	"context"
 	"time"
 )
 
-func Schedule(what func(), delay time.Duration) chan bool {
-	stop := make(chan bool)
-
+// Schedule runs what immediately and then every delay until ctx is cancelled.
+// The context is handed over to each run so that long running jobs can be aborted.
+func Schedule(ctx context.Context, what func(ctx context.Context), delay time.Duration) {
 	go func() {
 		for {
-			what()
+			what(ctx)
 			select {
 			case <-time.After(delay):
-			case <-stop:
+			case <-ctx.Done():
 				return
 			}
 		}
 	}()
-
-	return stop
 }
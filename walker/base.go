@@ -1,6 +1,8 @@
 package walker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
@@ -11,6 +13,15 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrWalkTimeout is returned by Walk when the walk was aborted because it exceeded the maximum walk duration
+	ErrWalkTimeout = errors.New("walk exceeded the maximum walk duration")
+	// ErrPartialWalk is returned by Walk when the walk completed but some entries could not be read
+	ErrPartialWalk = errors.New("walk completed with errors")
 )
 
 type BaseWalkerConfig struct {
@@ -20,13 +31,20 @@ type BaseWalkerConfig struct {
 	BinIncrementFactor float64           `long:"histogram-factor" required:"true" default:"1.5" env:"HISTOGRAM_FACTOR" description:"How much do we increase the size of bins (exponentially)"`
 	PrefixFilters      []string          `long:"prefix-filter" required:"false" env:"PREFIX_FILTER" description:"Prefixes or part of prefix to be ignored"`
 	CustomLabels       map[string]string `long:"custom-labels" env:"CUSTOM_LABELS" description:"Labels to add for prometheus exporters"`
+	MaxWalkDuration    time.Duration     `long:"max-walk-duration" required:"false" default:"0" env:"MAX_WALK_DURATION" description:"Abort walks taking longer than this duration; previous results are kept. 0 disables the limit"`
+	OnError            string            `long:"on-error" required:"false" default:"keep" choice:"keep" choice:"partial" env:"ON_ERROR" description:"When a walk has errors, keep serving the last complete results or publish the partial results flagged as such"`
+	AgeBuckets         []string          `long:"age-buckets" required:"false" default:"1d" default:"7d" default:"1M" default:"3M" default:"6M" default:"1y" default:"2y" env:"AGE_BUCKETS" env-delim:"," description:"Upper bounds of age histograms, as Go durations or with d, w, M (30 days) and y units (e.g. 12h, 30d, 6M, 1y)"`
 }
 
 type baseWalker struct {
 	config        *BaseWalkerConfig
 	Stats         stats.StatsInterface
-	blockFlag     bool
+	walking       int32
 	prefixPattern []*regexp.Regexp
+	// sizeBuckets are the bounds of the objects size histograms; walkers may set them before Init to replace the
+	// histogram options
+	sizeBuckets []float64
+	ageBuckets  []float64
 }
 
 func (b *baseWalker) Init(config Config, labels map[string]string, labelsNames []string) error {
@@ -36,26 +54,225 @@ func (b *baseWalker) Init(config Config, labels map[string]string, labelsNames [
 	}
 	b.config = config.BaseWalkerConfig
 
-	b.prefixPattern = utils.BuildPatternsFromStrings(b.config.PrefixFilters)
-	b.Stats = stats.NewPrometheusStatsHolder(labels, labelsNames, b.config.BinStart, b.config.BinIncrementFactor, b.config.BinNumber)
-	prometheus.MustRegister(b.Stats)
-	b.blockFlag = false
+	b.prefixPattern, err = utils.BuildPatternsFromStrings(b.config.PrefixFilters)
+	if err != nil {
+		return err
+	}
+	b.ageBuckets, err = utils.ParseAgeBounds(b.config.AgeBuckets)
+	if err != nil {
+		return fmt.Errorf("invalid age buckets: %w", err)
+	}
+	if b.sizeBuckets == nil {
+		b.sizeBuckets = stats.ExponentialBounds(b.config.BinStart, b.config.BinIncrementFactor, b.config.BinNumber)
+	}
+	b.Stats = stats.NewPrometheusStatsHolder(labels, labelsNames, b.sizeBuckets, b.ageBuckets, b.config.OnError)
 	return nil
 }
 
+func (b *baseWalker) Collector() prometheus.Collector {
+	return b.Stats
+}
+
 func (b *baseWalker) ValidateConfig(Config) error {
 	return nil
 }
 
-func (b *baseWalker) Walk() error {
+func (b *baseWalker) Walk(context.Context) error {
 	err := fmt.Errorf("impossible to call the abstract base method directly")
 	log.Panicln(err)
 	return err
 }
 
-func (b *baseWalker) ProcessFile(base string, path string, size int64, depth uint, contentType string, labels map[string]string) {
+// runWalk executes walk in its own goroutine, bounded by ctx and the configured maximum walk duration.
+// It returns as soon as the walk ends or is aborted, even if walk itself is stuck (e.g. in a blocking syscall);
+// in that case no other walk can start until the stuck one returns.
+// Results are only published when the walk completed, according to the error policy; failed and aborted
+// walks keep the previous results.
+func (b *baseWalker) runWalk(ctx context.Context, walk func(ctx context.Context) error) error {
+	if !atomic.CompareAndSwapInt32(&b.walking, 0, 1) {
+		log.Warning("Previous walk is still running, skipping this one")
+		return nil
+	}
+
+	var walkCtx context.Context
+	var cancel context.CancelFunc
+	if b.config.MaxWalkDuration > 0 {
+		walkCtx, cancel = context.WithTimeout(ctx, b.config.MaxWalkDuration)
+	} else {
+		walkCtx, cancel = context.WithCancel(ctx)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer atomic.StoreInt32(&b.walking, 0)
+		defer cancel()
+
+		b.startProcessing()
+		err := walk(walkCtx)
+		switch {
+		case walkCtx.Err() != nil:
+			err = b.abortReason(ctx, walkCtx)
+			b.recordError(err)
+			if errors.Is(err, ErrWalkTimeout) {
+				b.abortProcessing(stats.OutcomeTimeout)
+			} else {
+				b.abortProcessing(stats.OutcomeFailed)
+			}
+		case err != nil:
+			b.recordError(err)
+			b.abortProcessing(stats.OutcomeFailed)
+		default:
+			if b.endProcessing() == stats.OutcomePartial {
+				err = ErrPartialWalk
+			}
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-walkCtx.Done():
+		// A walk completing as it is canceled reports its own outcome
+		select {
+		case err := <-done:
+			return err
+		default:
+			return b.abortReason(ctx, walkCtx)
+		}
+	}
+}
+
+func (b *baseWalker) abortReason(parent context.Context, walkCtx context.Context) error {
+	if parent.Err() == nil && errors.Is(walkCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w (%s)", ErrWalkTimeout, b.config.MaxWalkDuration)
+	}
+	return walkCtx.Err()
+}
+
+// recordError accounts err in the walk errors; walks with errors are partial
+func (b *baseWalker) recordError(err error) {
+	b.Stats.RecordError(classifyError(err))
+}
+
+// recordEnrichmentError accounts err in the errors of feature, an optional enrichment; the walk stays complete
+func (b *baseWalker) recordEnrichmentError(feature string, err error) {
+	b.Stats.RecordEnrichmentError(feature, classifyError(err))
+}
+
+// ProcessFile computes the prefix of path and accounts the file in the stats.
+// It returns the context error once ctx is done so that walkers can stop early.
+func (b *baseWalker) ProcessFile(ctx context.Context, base string, path string, size int64, depth uint, contentType string, modTime time.Time, labels map[string]string) error {
+	return b.processFileTo(ctx, b.Stats, base, path, size, depth, contentType, modTime, labels)
+}
+
+// processFileTo is ProcessFile accounting the file in processor rather than in the stats
+func (b *baseWalker) processFileTo(ctx context.Context, processor stats.FileProcessor, base string, path string, size int64, depth uint, contentType string, modTime time.Time, labels map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	log.Tracef("Current file %s", path)
+	prefix, pathDepth, excluded := b.prefixOf(base, path, depth)
+	log.Debug("Path: ", path, " Size :", size, " Prefix :", prefix)
+	if excluded {
+		log.Debug("Excluded ", path)
+		return nil
+	}
+
+	processor.ProcessFile(prefix, uint64(size), pathDepth, filepath.Ext(path), contentType, modTime, labels)
+	return nil
+}
+
+// processUploadTo computes the prefix of the key of an incomplete multipart upload and accounts it in processor
+func (b *baseWalker) processUploadTo(ctx context.Context, processor stats.FileProcessor, base string, key string, size int64, initiated time.Time, depth uint, labels map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	prefix, _, excluded := b.prefixOf(base, key, depth)
+	log.Debug("Upload: ", key, " Size :", size, " Prefix :", prefix)
+	if excluded {
+		return nil
+	}
+
+	processor.ProcessUpload(prefix, uint64(size), time.Since(initiated), labels)
+	return nil
+}
+
+// processVersionTo computes the prefix of the key of an object version and accounts it in processor.
+// Noncurrent versions became noncurrent at replaced, when the next version was written.
+func (b *baseWalker) processVersionTo(ctx context.Context, processor stats.FileProcessor, base string, key string, state string, size int64, replaced time.Time, depth uint, labels map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	prefix, _, excluded := b.prefixOf(base, key, depth)
+	if excluded {
+		return nil
+	}
+
+	var noncurrentAge time.Duration
+	if state == stats.VersionNoncurrent {
+		noncurrentAge = time.Since(replaced)
+	}
+	processor.ProcessVersion(prefix, state, uint64(size), noncurrentAge, labels)
+	return nil
+}
+
+// processMetadataTo computes the prefix of the key of a sampled object and accounts its metadata in processor
+func (b *baseWalker) processMetadataTo(ctx context.Context, processor stats.FileProcessor, base string, key string, size int64, metadata stats.ObjectMetadata, depth uint, labels map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	prefix, _, excluded := b.prefixOf(base, key, depth)
+	if excluded {
+		return nil
+	}
+
+	processor.ProcessMetadata(prefix, uint64(size), metadata, labels)
+	return nil
+}
+
+// processTagsTo computes the prefix of the key of a sampled object and accounts its tags in processor
+func (b *baseWalker) processTagsTo(ctx context.Context, processor stats.FileProcessor, base string, key string, size int64, tags map[string]string, depth uint, labels map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	prefix, _, excluded := b.prefixOf(base, key, depth)
+	if excluded {
+		return nil
+	}
+
+	processor.ProcessTags(prefix, uint64(size), tags, labels)
+	return nil
+}
+
+// processRetentionTo computes the prefix of the key of an object and accounts its object lock protection in processor.
+// Retentions that expired are ignored.
+func (b *baseWalker) processRetentionTo(ctx context.Context, processor stats.FileProcessor, base string, key string, size int64, mode string, retainUntil time.Time, legalHold bool, depth uint, labels map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	prefix, _, excluded := b.prefixOf(base, key, depth)
+	if excluded {
+		return nil
+	}
+
+	remaining := time.Until(retainUntil)
+	if remaining <= 0 {
+		mode = ""
+	}
+	processor.ProcessRetention(prefix, uint64(size), mode, remaining, legalHold, labels)
+	return nil
+}
+
+// prefixOf returns the prefix grouping path, made of at most depth + 1 levels below base, and the depth of path.
+// Excluded is set when the prefix matches a prefix filter.
+func (b *baseWalker) prefixOf(base string, path string, depth uint) (prefix string, pathDepth uint64, excluded bool) {
 	nobase := strings.TrimPrefix(path, base)
 	fp := strings.TrimPrefix(filepath.ToSlash(nobase), filepath.VolumeName(path))
 	currentDepth := strings.Split(fp, "/")
@@ -65,20 +282,12 @@ func (b *baseWalker) ProcessFile(base string, path string, size int64, depth uin
 		usableDepth = depth + 1
 	}
 
-	var prefix string
 	if len(currentDepth) == 1 {
 		prefix = "ROOT"
 	} else {
 		prefix = strings.Join(currentDepth[0:usableDepth], "/")
 	}
-	log.Debug("Path: ", fp, " Size :", size, " Prefix :", prefix)
-
-	if utils.MatchExclude(b.prefixPattern, prefix) {
-		log.Debug("Excluded ", fp)
-		return
-	}
-
-	b.Stats.ProcessFile(prefix, uint64(size), uint64(len(currentDepth)), filepath.Ext(path), contentType, labels)
+	return prefix, uint64(len(currentDepth)), utils.MatchExclude(b.prefixPattern, prefix)
 }
 
 /*
@@ -106,10 +315,19 @@ func (b *baseWalker) getMimeType(path string) string {
 	return http.DetectContentType(buffer)
 }
 
+// newSnapshot returns an empty snapshot using the histogram options of the walker
+func (b *baseWalker) newSnapshot() *stats.Snapshot {
+	return stats.NewSnapshot(b.sizeBuckets, b.ageBuckets)
+}
+
 func (b *baseWalker) startProcessing() {
 	b.Stats.StartProcessing()
 }
 
-func (b *baseWalker) endProcessing() {
-	b.Stats.EndProcessing()
+func (b *baseWalker) endProcessing() stats.Outcome {
+	return b.Stats.EndProcessing()
+}
+
+func (b *baseWalker) abortProcessing(outcome stats.Outcome) {
+	b.Stats.AbortProcessing(outcome)
 }
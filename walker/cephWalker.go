@@ -0,0 +1,212 @@
+package walker
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/willena/s3-exporter/stats"
+	"github.com/willena/s3-exporter/utils"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type CephWalkerConfig struct {
+	CephConfiguration `group:"Ceph RGW Configuration" namespace:"ceph" env-namespace:"CEPH"`
+}
+
+type CephConfiguration struct {
+	S3Connection
+
+	AdminEntry    string   `long:"admin-entry" description:"Path of the RGW admin operations API (rgw_admin_entry)" required:"false" env:"ADMIN_ENTRY" default:"admin"`
+	BucketFilters []string `long:"bucket-filter" description:"Exclude buckets based on name" required:"false" env:"BUCKET_FILTER"`
+	NoUsers       bool     `long:"no-users" description:"Do not read the usage and quota of the owners of the buckets" required:"false" env:"NO_USERS"`
+}
+
+func init() {
+	Register(Registration{
+		Name:        "ceph",
+		Description: "Ceph RGW walker configuration",
+		NewConfig:   func() interface{} { return &CephWalkerConfig{} },
+		New:         func() Walker { return &CephWalker{} },
+	})
+}
+
+// cephBucketStats is a bucket of the RGW admin bucket stats response
+type cephBucketStats struct {
+	Bucket        string               `json:"bucket"`
+	Tenant        string               `json:"tenant"`
+	Owner         string               `json:"owner"`
+	PlacementRule string               `json:"placement_rule"`
+	Usage         map[string]cephUsage `json:"usage"`
+	BucketQuota   cephQuota            `json:"bucket_quota"`
+}
+
+// cephUsage is the usage of a category of RGW objects. Old releases only report sizes in KiB.
+type cephUsage struct {
+	Size       uint64 `json:"size"`
+	SizeKB     uint64 `json:"size_kb"`
+	NumObjects uint64 `json:"num_objects"`
+}
+
+type cephQuota struct {
+	Enabled    bool  `json:"enabled"`
+	MaxSize    int64 `json:"max_size"`
+	MaxSizeKB  int64 `json:"max_size_kb"`
+	MaxObjects int64 `json:"max_objects"`
+}
+
+// cephUserInfo is the part of the RGW admin user info response used by the walker
+type cephUserInfo struct {
+	Stats     cephUsage `json:"stats"`
+	UserQuota cephQuota `json:"user_quota"`
+}
+
+// cephMainCategory holds the usage of the objects of a bucket, other categories holding RGW internal objects
+const cephMainCategory = "rgw.main"
+
+func (u cephUsage) usage() stats.Usage {
+	size := u.Size
+	if size == 0 {
+		size = u.SizeKB << 10
+	}
+	return stats.Usage{Objects: u.NumObjects, Size: size}
+}
+
+// quota returns the limits of q, negative limits being unlimited
+func (q cephQuota) quota() stats.Quota {
+	var quota stats.Quota
+	if !q.Enabled {
+		return quota
+	}
+	if q.MaxObjects > 0 {
+		quota.MaxObjects = uint64(q.MaxObjects)
+	}
+	if q.MaxSize > 0 {
+		quota.MaxSize = uint64(q.MaxSize)
+	} else if q.MaxSize == 0 && q.MaxSizeKB > 0 {
+		quota.MaxSize = uint64(q.MaxSizeKB) << 10
+	}
+	return quota
+}
+
+// CephWalker exports the usage and quotas of buckets and users that Ceph RGW keeps in its bucket indexes, read with
+// the admin operations API, instead of listing buckets
+type CephWalker struct {
+	baseWalker
+	*s3Connection
+	config         *CephWalkerConfig
+	bucketPatterns []*regexp.Regexp
+}
+
+func (c *CephWalker) Init(config Config, labels map[string]string, _ []string) error {
+	err := c.ValidateConfig(config)
+	if err != nil {
+		return err
+	}
+	c.config = config.Options.(*CephWalkerConfig)
+	c.bucketPatterns, err = utils.BuildPatternsFromStrings(c.config.BucketFilters)
+	if err != nil {
+		return err
+	}
+
+	err = c.baseWalker.Init(config,
+		utils.MergeMapsRight(map[string]string{
+			"type":       "cephWalker",
+			"s3Endpoint": c.config.Endpoint,
+		}, labels), []string{"bucket", "owner", "placement"})
+	if err != nil {
+		return err
+	}
+	c.s3Connection, err = newS3Connection(&c.config.S3Connection, c.Stats)
+	return err
+}
+
+func (c *CephWalker) ValidateConfig(config Config) error {
+	cephConfig, ok := config.Options.(*CephWalkerConfig)
+	if !ok {
+		return fmt.Errorf("Ceph walker options are missing")
+	}
+	if cephConfig.Endpoint == "" {
+		return fmt.Errorf("the RGW endpoint is needed when using Ceph mode")
+	}
+	if strings.Trim(cephConfig.AdminEntry, "/") == "" {
+		return fmt.Errorf("the RGW admin entry can not be empty")
+	}
+	if cephConfig.Signature != signatureV4 {
+		return fmt.Errorf("admin requests can only be signed with signature V4")
+	}
+	return validateConnection(&cephConfig.S3Connection)
+}
+
+func (c *CephWalker) Walk(ctx context.Context) error {
+	return c.runWalk(ctx, c.walkBuckets)
+}
+
+// walkBuckets accounts the usage of every bucket not excluded as a single prefix, then reads the usage and quota of
+// their owners. A user that can not be read does not stop the walk, which is then partial.
+func (c *CephWalker) walkBuckets(ctx context.Context) error {
+	var buckets []cephBucketStats
+	if err := c.adminRequest(ctx, "GetBucketStats", c.adminPath("bucket"), url.Values{"stats": {"true"}, "format": {"json"}}, &buckets); err != nil {
+		log.Errorf("Could not read RGW bucket stats: %s", err)
+		return err
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].name() < buckets[j].name() })
+
+	owners := map[string]bool{}
+	for _, bucket := range buckets {
+		name := bucket.name()
+		if utils.MatchExclude(c.bucketPatterns, name) {
+			log.Infof("Bucket %s excluded !", name)
+			continue
+		}
+		// RGW does not know the sizes of objects: buckets have no size histogram
+		usage := bucket.Usage[cephMainCategory].usage()
+		c.Stats.ProcessUsage("ROOT", usage.Objects, usage.Size, nil,
+			map[string]string{"bucket": name, "owner": bucket.Owner, "placement": bucket.PlacementRule})
+		if quota := bucket.BucketQuota.quota(); quota != (stats.Quota{}) {
+			c.Stats.RecordQuota(name, quota)
+		}
+		owners[bucket.Owner] = true
+	}
+
+	if c.config.NoUsers {
+		return nil
+	}
+	for owner := range owners {
+		if err := c.walkUser(ctx, owner); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warningf("Could not read RGW user %s: %s", owner, err.Error())
+			c.recordError(err)
+		}
+	}
+	return nil
+}
+
+// walkUser accounts the usage and quota of user, the sum of its buckets
+func (c *CephWalker) walkUser(ctx context.Context, user string) error {
+	var info cephUserInfo
+	err := c.adminRequest(ctx, "GetUserInfo", c.adminPath("user"), url.Values{"uid": {user}, "stats": {"true"}, "format": {"json"}}, &info)
+	if err != nil {
+		return err
+	}
+	c.Stats.RecordUser(user, stats.UserUsage{Usage: info.Stats.usage(), Quota: info.UserQuota.quota()})
+	return nil
+}
+
+// adminPath returns the path of resource of the admin operations API
+func (c *CephWalker) adminPath(resource string) string {
+	return path.Join("/", c.config.AdminEntry, resource)
+}
+
+// name returns the name of the bucket, prefixed with its tenant as radosgw-admin does
+func (b cephBucketStats) name() string {
+	if b.Tenant == "" {
+		return b.Bucket
+	}
+	return b.Tenant + "/" + b.Bucket
+}
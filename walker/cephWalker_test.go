@@ -0,0 +1,222 @@
+package walker
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/willena/s3-exporter/stats"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// cephBuckets is the bucket stats response of the fake RGW
+const cephBuckets = `[
+	{"bucket": "photos", "tenant": "", "owner": "alice", "placement_rule": "default-placement",
+	 "usage": {"rgw.main": {"size": 3072, "num_objects": 3}, "rgw.multimeta": {"size": 0, "num_objects": 2}},
+	 "bucket_quota": {"enabled": true, "max_size": 1048576, "max_objects": -1}},
+	{"bucket": "tmp-cache", "tenant": "", "owner": "carol", "placement_rule": "default-placement",
+	 "usage": {"rgw.main": {"size": 10, "num_objects": 1}}, "bucket_quota": {"enabled": false}},
+	{"bucket": "archive", "tenant": "acme", "owner": "acme$bob", "placement_rule": "cold",
+	 "usage": {"rgw.main": {"size_kb": 4, "num_objects": 1}}, "bucket_quota": {"enabled": false, "max_size": 1024}}
+]`
+
+// cephUsers are the user info responses of the fake RGW
+var cephUsers = map[string]string{
+	"alice":    `{"stats": {"size": 3072, "num_objects": 3}, "user_quota": {"enabled": true, "max_size": -1, "max_objects": 100}}`,
+	"acme$bob": `{"stats": {"size_kb": 4, "num_objects": 1}, "user_quota": {"enabled": false}}`,
+}
+
+// newFakeRGW serves the bucket stats and the info of users of the admin operations API to requests signed with
+// signature V4 by the access and secret keys
+func newFakeRGW(t *testing.T, users map[string]string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := verifySignatureV4(r, "access", "secret", "us-east-1"); err != nil {
+			t.Errorf("invalid signature of %s: %s", r.URL, err)
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"Code": "SignatureDoesNotMatch"}`))
+			return
+		}
+		query := r.URL.Query()
+		switch {
+		case r.URL.Path == "/admin/bucket" && query.Get("stats") == "true":
+			_, _ = w.Write([]byte(cephBuckets))
+		case r.URL.Path == "/admin/user" && users[query.Get("uid")] != "":
+			_, _ = w.Write([]byte(users[query.Get("uid")]))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"Code": "NoSuchKey"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// verifySignatureV4 checks the signature V4 of request for region and the S3 service
+func verifySignatureV4(request *http.Request, accessKey string, secretKey string, region string) error {
+	authorization := request.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 ") {
+		return fmt.Errorf("unexpected authorization %q", authorization)
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimPrefix(authorization, "AWS4-HMAC-SHA256 "), ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) == 2 {
+			fields[parts[0]] = parts[1]
+		}
+	}
+	date := request.Header.Get("X-Amz-Date")
+	if len(date) < 8 {
+		return fmt.Errorf("unexpected date %q", date)
+	}
+	scope := strings.Join([]string{date[:8], region, "s3", "aws4_request"}, "/")
+	if fields["Credential"] != accessKey+"/"+scope {
+		return fmt.Errorf("unexpected credential %q", fields["Credential"])
+	}
+
+	var headers []string
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	for _, name := range signedHeaders {
+		value := request.Header.Get(name)
+		if name == "host" {
+			value = request.Host
+		}
+		headers = append(headers, name+":"+strings.TrimSpace(value)+"\n")
+	}
+	if !reflect.DeepEqual(signedHeaders, []string{"host", "x-amz-content-sha256", "x-amz-date"}) {
+		return fmt.Errorf("unexpected signed headers %v", signedHeaders)
+	}
+	canonicalRequest := strings.Join([]string{request.Method, request.URL.EscapedPath(), strings.ReplaceAll(request.URL.Query().Encode(), "+", "%20"),
+		strings.Join(headers, ""), fields["SignedHeaders"], request.Header.Get("X-Amz-Content-Sha256")}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range strings.Split(scope, "/") {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + hex.EncodeToString(hash[:])))
+	if signature := hex.EncodeToString(mac.Sum(nil)); fields["Signature"] != signature {
+		return fmt.Errorf("expected signature %s, got %s", signature, fields["Signature"])
+	}
+	return nil
+}
+
+func newCephWalker(t *testing.T, endpoint string, args ...string) *CephWalker {
+	config := testConfig(t, "ceph", append([]string{
+		"--ceph.endpoint", endpoint, "--ceph.access-key", "access", "--ceph.secret-key", "secret",
+		"--ceph.region", "us-east-1", "--ceph.max-attempts", "1", "--ceph.bucket-filter", "^tmp-",
+	}, args...)...)
+	walker := &CephWalker{}
+	if err := walker.Init(config, map[string]string{"target": "test"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	return walker
+}
+
+// gatherValues returns the values of the metrics of collector named name, by the value of their label
+func gatherValues(t *testing.T, collector prometheus.Collector, name string, label string) map[string]float64 {
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != stats.METRICS_GROUP+"_"+name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, pair := range metric.GetLabel() {
+				if pair.GetName() == label {
+					values[pair.GetValue()] = metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	return values
+}
+
+func TestCephUsage(t *testing.T) {
+	walker := newCephWalker(t, newFakeRGW(t, cephUsers))
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := walker.Stats.(*stats.PrometheusStats).Snapshot()
+
+	if len(snapshot.Series) != 2 {
+		t.Errorf("expected 2 buckets, the excluded one apart, got %d series", len(snapshot.Series))
+	}
+	for _, test := range []struct {
+		bucket    string
+		owner     string
+		placement string
+		objects   uint64
+		size      uint64
+	}{
+		// Only the usage of the main category counts
+		{"photos", "alice", "default-placement", 3, 3072},
+		// Tenant buckets are named like radosgw-admin does, and old releases report sizes in KiB
+		{"acme/archive", "acme$bob", "cold", 1, 4096},
+	} {
+		series := snapshot.Series[stats.SeriesKey(map[string]string{"bucket": test.bucket, "owner": test.owner, "placement": test.placement})]
+		if series == nil || series.Objects != test.objects || series.Size != test.size {
+			t.Errorf("%s: expected %d objects of %d bytes, got %+v", test.bucket, test.objects, test.size, series)
+			continue
+		}
+		// RGW does not know the sizes of objects
+		if prefix := series.Prefixes["ROOT"]; prefix == nil || prefix.Objects != test.objects || prefix.SizeHistogram != nil {
+			t.Errorf("%s: expected the usage under the ROOT prefix without size histogram, got %+v", test.bucket, prefix)
+		}
+	}
+
+	if !reflect.DeepEqual(snapshot.Quotas, map[string]*stats.Quota{"photos": {MaxSize: 1048576}}) {
+		t.Errorf("unexpected bucket quotas %v", snapshot.Quotas)
+	}
+	if !reflect.DeepEqual(snapshot.Users, map[string]*stats.UserUsage{
+		"alice":    {Usage: stats.Usage{Objects: 3, Size: 3072}, Quota: stats.Quota{MaxObjects: 100}},
+		"acme$bob": {Usage: stats.Usage{Objects: 1, Size: 4096}},
+	}) {
+		t.Errorf("unexpected users %v", snapshot.Users)
+	}
+
+	collector := walker.Collector()
+	if values := gatherValues(t, collector, "objects_count", "bucket"); !reflect.DeepEqual(values, map[string]float64{"photos": 3, "acme/archive": 1}) {
+		t.Errorf("unexpected exported object counts %v", values)
+	}
+	if values := gatherValues(t, collector, "objects_sizes_count", "bucket"); len(values) != 0 {
+		t.Errorf("unexpected exported size histograms %v", values)
+	}
+	if values := gatherValues(t, collector, "user_quota_max_objects", "user"); !reflect.DeepEqual(values, map[string]float64{"alice": 100}) {
+		t.Errorf("unexpected exported user quotas %v", values)
+	}
+}
+
+// TestCephUserErrors checks that users that can not be read make the walk partial, and are not read with --no-users
+func TestCephUserErrors(t *testing.T) {
+	endpoint := newFakeRGW(t, map[string]string{"alice": cephUsers["alice"]})
+
+	walker := newCephWalker(t, endpoint)
+	if err := walker.Walk(context.Background()); err != ErrPartialWalk {
+		t.Errorf("expected a partial walk, got %v", err)
+	}
+	if errors := walker.Stats.(*stats.PrometheusStats).Status().Errors; errors[ErrorClassNotFound] != 1 || len(errors) != 1 {
+		t.Errorf("expected 1 not found error, got %v", errors)
+	}
+
+	walker = newCephWalker(t, endpoint, "--ceph.no-users")
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if users := walker.Stats.(*stats.PrometheusStats).Snapshot().Users; len(users) != 0 {
+		t.Errorf("users were read with --no-users: %v", users)
+	}
+}
@@ -0,0 +1,75 @@
+package walker
+
+import (
+	"context"
+	"errors"
+	"github.com/minio/minio-go/v7"
+	"net"
+	"os"
+)
+
+// Error classes used to account walk errors
+const (
+	ErrorClassTimeout      = "timeout"
+	ErrorClassCanceled     = "canceled"
+	ErrorClassAccessDenied = "access_denied"
+	ErrorClassNotFound     = "not_found"
+	ErrorClassThrottled    = "throttled"
+	ErrorClassNetwork      = "network"
+	ErrorClassOther        = "other"
+)
+
+// Optional enrichments of the objects and buckets, whose errors are accounted apart from walk errors
+const (
+	FeatureBucketConfig = "bucket_config"
+	FeatureMetadata     = "metadata"
+	FeatureTags         = "tags"
+	FeatureRetention    = "retention"
+	FeatureCrossCheck   = "cross_check"
+	FeatureRegion       = "region"
+)
+
+// ThrottlingCodes are the error codes of requests rejected because of the request rate
+var ThrottlingCodes = []string{"SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "RequestThrottled", "TooManyRequests"}
+
+// IsThrottlingCode reports whether code is one of ThrottlingCodes
+func IsThrottlingCode(code string) bool {
+	for _, throttlingCode := range ThrottlingCodes {
+		if code == throttlingCode {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyError returns the class of err, used to label walk errors
+func classifyError(err error) string {
+	switch {
+	case errors.Is(err, ErrWalkTimeout), errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case os.IsPermission(err):
+		return ErrorClassAccessDenied
+	case os.IsNotExist(err):
+		return ErrorClassNotFound
+	}
+
+	switch code := minio.ToErrorResponse(err).Code; {
+	case code == "":
+	case code == "AccessDenied", code == "InvalidAccessKeyId", code == "SignatureDoesNotMatch":
+		return ErrorClassAccessDenied
+	case code == "NoSuchBucket", code == "NoSuchKey", code == "NoSuchUser":
+		return ErrorClassNotFound
+	case IsThrottlingCode(code):
+		return ErrorClassThrottled
+	default:
+		return ErrorClassOther
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrorClassNetwork
+	}
+	return ErrorClassOther
+}
@@ -1,6 +1,7 @@
 package walker
 
 import (
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/willena/s3-exporter/utils"
@@ -10,7 +11,17 @@ import (
 )
 
 type FsWalkerConfig struct {
-	Folder string `long:"folder" env:"FOLDER" default:"/" description:"Folder to be used for FS walker"`
+	Folder  string `long:"folder" env:"FOLDER" default:"/" description:"Folder to be used for FS walker"`
+	Workers int    `long:"fs-workers" env:"FS_WORKERS" default:"1" description:"Number of folders read in parallel; above 1 the folder tree is walked concurrently"`
+}
+
+func init() {
+	Register(Registration{
+		Name:        "fs",
+		Description: "FS walker configuration",
+		NewConfig:   func() interface{} { return &FsWalkerConfig{} },
+		New:         func() Walker { return &FsWalker{} },
+	})
 }
 
 type FsWalker struct {
@@ -24,20 +35,25 @@ func (f *FsWalker) Init(config Config, labels map[string]string, labelsNames []s
 		return err
 	}
 
-	f.config = config.FsWalkerConfig
+	f.config = config.Options.(*FsWalkerConfig)
 	return f.baseWalker.Init(config, utils.MergeMapsRight(map[string]string{"type": "fsWalker", "baseDir": f.config.Folder}, labels), labelsNames)
 }
 
 func (f *FsWalker) ValidateConfig(config Config) error {
-	if config.Folder == "" {
+	fsConfig, ok := config.Options.(*FsWalkerConfig)
+	if !ok {
+		return fmt.Errorf("FS walker options are missing")
+	}
+
+	if fsConfig.Folder == "" {
 		return fmt.Errorf("folder is needed when using FS Mode")
 	}
 
 	var folder os.FileInfo
 	var err error
-	if folder, err = os.Stat(config.Folder); err != nil {
+	if folder, err = os.Stat(fsConfig.Folder); err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("the specifed folder (%s) does not exist", config.Folder)
+			return fmt.Errorf("the specifed folder (%s) does not exist", fsConfig.Folder)
 		}
 		return fmt.Errorf("error while checking source folder: %s", err.Error())
 	}
@@ -45,28 +61,78 @@ func (f *FsWalker) ValidateConfig(config Config) error {
 	if !folder.IsDir() {
 		return fmt.Errorf("specified path should be a valid folder")
 	}
+
+	if fsConfig.Workers < 1 {
+		return fmt.Errorf("the number of FS workers must be at least 1")
+	}
 	return nil
 }
 
-func (f *FsWalker) Walk() error {
-	if f.blockFlag {
-		return nil
+func (f *FsWalker) Walk(ctx context.Context) error {
+	log.Info("Walk start...")
+	return f.runWalk(ctx, f.walkFolder)
+}
+
+func (f *FsWalker) walkFolder(ctx context.Context) error {
+	if f.config.Workers > 1 {
+		return f.walkFolderConcurrently(ctx)
 	}
-	f.blockFlag = true
 
-	log.Info("Walk start...")
-	f.Stats.Reset()
-	f.startProcessing()
-	err := filepath.WalkDir(f.config.Folder, f.onDirEntry)
-	f.endProcessing()
+	return filepath.WalkDir(f.config.Folder, func(path string, d fs.DirEntry, err error) error {
+		return f.onDirEntry(ctx, path, d, err)
+	})
+}
+
+// walkFolderConcurrently reads the folders of the tree in parallel, up to the configured number of workers.
+// Entries are handled as with filepath.WalkDir, in no particular order.
+func (f *FsWalker) walkFolderConcurrently(ctx context.Context) error {
+	entries, err := os.ReadDir(f.config.Folder)
+	if err != nil {
+		return err
+	}
 
-	f.blockFlag = false
-	return err
+	pool := utils.NewTaskPool(ctx, f.config.Workers)
+	f.processEntries(ctx, pool, f.config.Folder, entries)
+	pool.Wait()
+	return ctx.Err()
 }
 
-func (f *FsWalker) onDirEntry(path string, d fs.DirEntry, err error) error {
+func (f *FsWalker) readFolder(ctx context.Context, pool *utils.TaskPool, folder string) {
+	entries, err := os.ReadDir(folder)
 	if err != nil {
+		log.Warning("Could not read ", folder, err)
+		f.recordError(err)
+	}
+	f.processEntries(ctx, pool, folder, entries)
+}
+
+func (f *FsWalker) processEntries(ctx context.Context, pool *utils.TaskPool, folder string, entries []fs.DirEntry) {
+	for _, entry := range entries {
+		path := filepath.Join(folder, entry.Name())
+		if entry.IsDir() {
+			pool.Submit(func(ctx context.Context) {
+				f.readFolder(ctx, pool, path)
+			})
+			continue
+		}
+
+		if err := f.onDirEntry(ctx, path, entry, nil); err != nil {
+			return
+		}
+	}
+}
+
+func (f *FsWalker) onDirEntry(ctx context.Context, path string, d fs.DirEntry, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	if err != nil {
+		if path == f.config.Folder {
+			return err
+		}
 		log.Warning("Could not read ", path, err)
+		f.recordError(err)
 		return nil
 	}
 
@@ -77,9 +143,9 @@ func (f *FsWalker) onDirEntry(path string, d fs.DirEntry, err error) error {
 	fInfo, err := d.Info()
 	if err != nil {
 		log.Errorf("Could not get file info: %s", err.Error())
+		f.recordError(err)
+		return nil
 	}
 	size := fInfo.Size()
-	f.ProcessFile(f.config.Folder, path, size, f.baseWalker.config.Depth, "", map[string]string{})
-
-	return nil
+	return f.ProcessFile(ctx, f.config.Folder, path, size, f.baseWalker.config.Depth, "", fInfo.ModTime(), map[string]string{})
 }
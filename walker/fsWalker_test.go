@@ -0,0 +1,110 @@
+package walker
+
+import (
+	"context"
+	"github.com/willena/s3-exporter/stats"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// walkFs walks folder with a new FS walker configured with args and returns the published snapshot
+func walkFs(t *testing.T, folder string, args ...string) *stats.Snapshot {
+	walker := &FsWalker{}
+	if err := walker.Init(testConfig(t, "fs", append([]string{"--folder", folder}, args...)...), map[string]string{"target": "test"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return walker.Stats.(*stats.PrometheusStats).Snapshot()
+}
+
+// TestConcurrentFsWalk checks that walking the folder tree concurrently gives the same aggregates as a serial walk
+func TestConcurrentFsWalk(t *testing.T) {
+	folder := t.TempDir()
+	modified := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for key, size := range fakeBucket(200) {
+		path := filepath.Join(folder, filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(strings.Repeat("x", int(size/100))), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	serial := walkedSeries(walkFs(t, folder, "--maxDepth", "2"))
+	var objects uint64
+	for _, series := range serial {
+		objects += series.Objects
+	}
+	if objects != 200 {
+		t.Fatalf("expected 200 files in the serial walk, got %d", objects)
+	}
+	for _, workers := range []string{"2", "4", "16"} {
+		if concurrent := walkedSeries(walkFs(t, folder, "--maxDepth", "2", "--fs-workers", workers)); !reflect.DeepEqual(concurrent, serial) {
+			t.Errorf("%s workers: concurrent walk aggregates differ from the serial walk ones", workers)
+		}
+	}
+}
+
+// TestFsAges checks that the ages of files are accounted from their modification time, under prefixes relative to
+// the folder
+func TestFsAges(t *testing.T) {
+	folder := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+	const day = 24 * time.Hour
+	modified := map[string]time.Time{
+		"new.txt":      now.Add(-time.Hour),
+		"logs/old.txt": now.Add(-40 * day),
+		"logs/a/b.txt": now.Add(-3 * day),
+	}
+	for name, modTime := range modified {
+		path := filepath.Join(folder, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	series := walkedSeries(walkFs(t, folder, "--maxDepth", "1", "--age-buckets", "1d", "--age-buckets", "7d", "--age-buckets", "1M"))
+	if len(series) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(series))
+	}
+	for _, series := range series {
+		for prefix, expected := range map[string]struct {
+			counts []uint64
+			count  uint64
+			oldest time.Time
+			newest time.Time
+		}{
+			"":      {[]uint64{1, 0, 0}, 1, modified["new.txt"], modified["new.txt"]},
+			"/logs": {[]uint64{0, 1, 0}, 2, modified["logs/old.txt"], modified["logs/a/b.txt"]},
+		} {
+			prefixStats := series.Prefixes[prefix]
+			if prefixStats == nil {
+				t.Errorf("%s: the prefix was not accounted", prefix)
+				continue
+			}
+			if !reflect.DeepEqual(prefixStats.AgeHistogram.Counts, expected.counts) || prefixStats.AgeHistogram.Count != expected.count {
+				t.Errorf("%s: expected ages %v out of %d, got %v out of %d", prefix, expected.counts, expected.count, prefixStats.AgeHistogram.Counts, prefixStats.AgeHistogram.Count)
+			}
+			if !prefixStats.Oldest.Equal(expected.oldest) || !prefixStats.Newest.Equal(expected.newest) {
+				t.Errorf("%s: expected files modified from %s to %s, got %s to %s", prefix, expected.oldest, expected.newest, prefixStats.Oldest, prefixStats.Newest)
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package walker
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// errInventoryCorrupted is returned for ORC and Parquet data files that can not be decoded
+var errInventoryCorrupted = errors.New("corrupted inventory data file")
+
+// maxInventoryBlock bounds the size of the blocks (pages, compression chunks, metadata) of ORC and Parquet data
+// files, so that corrupted sizes are detected before being allocated
+const maxInventoryBlock = 256 << 20
+
+// inventoryRecords reads the records of a data file, laid out as the columns of its manifest; Read returns io.EOF
+// after the last record
+type inventoryRecords interface {
+	Read() ([]string, error)
+}
+
+// inventoryColumn reads the values of a column of an ORC or Parquet data file as they are written in CSV data files,
+// null values being empty
+type inventoryColumn interface {
+	next() (string, error)
+}
+
+// columnarRecords reads the records of ORC and Parquet data files, whose rows are stored in groups (stripes or row
+// groups) of columns. The values of the columns are decoded as the rows are read, so that only the current page or
+// compression chunk of each column is held in memory.
+type columnarRecords struct {
+	// group returns the columns of the next group of rows laid out as the columns of the manifest, nil for the
+	// columns the data file does not hold, and its number of rows; it returns io.EOF after the last group
+	group   func() ([]inventoryColumn, int64, error)
+	columns []inventoryColumn
+	// rows counts the rows of the group not read yet
+	rows   int64
+	record []string
+}
+
+func newColumnarRecords(manifest *inventoryManifest, group func() ([]inventoryColumn, int64, error)) *columnarRecords {
+	return &columnarRecords{group: group, record: make([]string, manifest.width())}
+}
+
+// width returns the number of columns of the records of the data files
+func (m *inventoryManifest) width() int {
+	width := 0
+	for _, i := range m.columns {
+		if i >= width {
+			width = i + 1
+		}
+	}
+	return width
+}
+
+func (r *columnarRecords) Read() ([]string, error) {
+	for r.rows <= 0 {
+		columns, rows, err := r.group()
+		if err != nil {
+			return nil, err
+		}
+		r.columns, r.rows = columns, rows
+	}
+	for i, column := range r.columns {
+		r.record[i] = ""
+		if column == nil {
+			continue
+		}
+		value, err := column.next()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.record[i] = value
+	}
+	r.rows--
+	return r.record, nil
+}
+
+// inventoryColumnName returns the name of a column of ORC and Parquet data files, like last_modified_date, as named
+// in CSV data files: LastModifiedDate
+func inventoryColumnName(name string) string {
+	words := strings.Split(strings.TrimSpace(name), "_")
+	for i, word := range words {
+		switch {
+		case word == "tag":
+			// e_tag is ETag
+			words[i] = "Tag"
+		case word != "":
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return strings.Join(words, "")
+}
+
+// formatInventoryTime formats the dates of ORC and Parquet data files as in CSV data files
+func formatInventoryTime(date time.Time) string {
+	return date.UTC().Format(time.RFC3339Nano)
+}
+
+// temporaryFile is a file removed once closed
+type temporaryFile struct {
+	*os.File
+}
+
+func (f temporaryFile) Close() error {
+	err := f.File.Close()
+	if removeErr := os.Remove(f.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// download copies content to a temporary file, removed once closed
+func download(content io.Reader) (temporaryFile, error) {
+	file, err := ioutil.TempFile("", "inventory-")
+	if err != nil {
+		return temporaryFile{}, err
+	}
+	temporary := temporaryFile{file}
+	if _, err := io.Copy(file, content); err != nil {
+		_ = temporary.Close()
+		return temporaryFile{}, err
+	}
+	return temporary, nil
+}
+
+// decodeSnappy decompresses a block of the snappy format, as written in ORC and Parquet data files
+func decodeSnappy(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 || length > maxInventoryBlock {
+		return nil, errInventoryCorrupted
+	}
+	dst := make([]byte, 0, length)
+	for s := n; s < len(src); {
+		tag := src[s]
+		var size, offset int
+		switch tag & 3 {
+		case 0:
+			// Literal, whose length is in the tag or in the 1 to 4 bytes after it
+			size = int(tag >> 2)
+			s++
+			if size >= 60 {
+				extra := size - 59
+				if s+extra > len(src) {
+					return nil, errInventoryCorrupted
+				}
+				size = 0
+				for i := extra - 1; i >= 0; i-- {
+					size = size<<8 | int(src[s+i])
+				}
+				s += extra
+			}
+			size++
+			if size <= 0 || s+size > len(src) {
+				return nil, errInventoryCorrupted
+			}
+			dst = append(dst, src[s:s+size]...)
+			s += size
+			continue
+		case 1:
+			if s+2 > len(src) {
+				return nil, errInventoryCorrupted
+			}
+			size = 4 + int(tag>>2&7)
+			offset = int(tag>>5)<<8 | int(src[s+1])
+			s += 2
+		case 2:
+			if s+3 > len(src) {
+				return nil, errInventoryCorrupted
+			}
+			size = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		default:
+			if s+5 > len(src) {
+				return nil, errInventoryCorrupted
+			}
+			size = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+		// Copies may overlap what they append
+		if offset <= 0 || offset > len(dst) || len(dst)+size > int(length) {
+			return nil, errInventoryCorrupted
+		}
+		for i := 0; i < size; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != int(length) {
+		return nil, errInventoryCorrupted
+	}
+	return dst, nil
+}
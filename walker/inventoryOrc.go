@@ -0,0 +1,900 @@
+package walker
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ORC data files are decoded as described by https://orc.apache.org/specification/ORCv1, their metadata being
+// encoded with Protocol Buffers
+
+const orcMagic = "ORC"
+
+// Compressions of ORC files
+const (
+	orcNone = iota
+	orcZlib
+	orcSnappy
+)
+
+// Kinds of ORC types
+const (
+	orcBoolean = iota
+	orcByte
+	orcShort
+	orcInt
+	orcLong
+	orcFloat
+	orcDouble
+	orcString
+	orcBinary
+	orcTimestamp
+	orcStruct     = 12
+	orcDate       = 15
+	orcVarchar    = 16
+	orcChar       = 17
+	orcTimestampZ = 18
+)
+
+// Kinds of ORC streams
+const (
+	orcPresent = iota
+	orcData
+	orcLength
+	orcDictionaryData
+	orcDictionaryCount
+	orcSecondary
+)
+
+// Encodings of ORC columns
+const (
+	orcDirect = iota
+	orcDictionary
+	orcDirectV2
+	orcDictionaryV2
+)
+
+// orcTimestampBase is the date timestamps are relative to: in UTC for timestamps with local time zone, and in the
+// timezone of the writer of stripes for the others
+var orcTimestampBase = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// protoMessage holds the fields of a Protocol Buffers message by number, as uint64 for varints and fixed sized values
+// and as []byte for the others, repeated fields holding several values
+type protoMessage map[uint64][]interface{}
+
+func readProtoMessage(content []byte) (protoMessage, error) {
+	message := protoMessage{}
+	for len(content) > 0 {
+		key, n := binary.Uvarint(content)
+		if n <= 0 {
+			return nil, errInventoryCorrupted
+		}
+		content = content[n:]
+		var value interface{}
+		switch key & 7 {
+		case 0:
+			varint, n := binary.Uvarint(content)
+			if n <= 0 {
+				return nil, errInventoryCorrupted
+			}
+			value, content = varint, content[n:]
+		case 1:
+			if len(content) < 8 {
+				return nil, errInventoryCorrupted
+			}
+			value, content = binary.LittleEndian.Uint64(content), content[8:]
+		case 2:
+			length, n := binary.Uvarint(content)
+			if n <= 0 || length > uint64(len(content)-n) {
+				return nil, errInventoryCorrupted
+			}
+			value, content = content[n:n+int(length)], content[n+int(length):]
+		case 5:
+			if len(content) < 4 {
+				return nil, errInventoryCorrupted
+			}
+			value, content = uint64(binary.LittleEndian.Uint32(content)), content[4:]
+		default:
+			return nil, errInventoryCorrupted
+		}
+		message[key>>3] = append(message[key>>3], value)
+	}
+	return message, nil
+}
+
+func (m protoMessage) uint(field uint64) uint64 {
+	values := m[field]
+	if len(values) == 0 {
+		return 0
+	}
+	value, _ := values[len(values)-1].(uint64)
+	return value
+}
+
+func (m protoMessage) string(field uint64) string {
+	values := m[field]
+	if len(values) == 0 {
+		return ""
+	}
+	value, _ := values[len(values)-1].([]byte)
+	return string(value)
+}
+
+func (m protoMessage) strings(field uint64) []string {
+	var values []string
+	for _, value := range m[field] {
+		value, _ := value.([]byte)
+		values = append(values, string(value))
+	}
+	return values
+}
+
+// uints returns the values of a repeated integer field, packed or not
+func (m protoMessage) uints(field uint64) ([]uint64, error) {
+	var values []uint64
+	for _, value := range m[field] {
+		switch value := value.(type) {
+		case uint64:
+			values = append(values, value)
+		case []byte:
+			for len(value) > 0 {
+				varint, n := binary.Uvarint(value)
+				if n <= 0 {
+					return nil, errInventoryCorrupted
+				}
+				values, value = append(values, varint), value[n:]
+			}
+		}
+	}
+	return values, nil
+}
+
+func (m protoMessage) messages(field uint64) ([]protoMessage, error) {
+	var messages []protoMessage
+	for _, value := range m[field] {
+		content, _ := value.([]byte)
+		message, err := readProtoMessage(content)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// orcFile is an ORC data file, with its compression
+type orcFile struct {
+	file        io.ReaderAt
+	compression uint64
+	blockSize   uint64
+}
+
+// stream returns the content of the stream of length bytes at offset, decompressed as it is read
+func (f *orcFile) stream(offset int64, length int64) *orcStream {
+	return &orcStream{file: f, content: io.NewSectionReader(f.file, offset, length)}
+}
+
+// message reads the Protocol Buffers message of length bytes at offset
+func (f *orcFile) message(offset int64, length int64) (protoMessage, error) {
+	if length > maxInventoryBlock {
+		return nil, errInventoryCorrupted
+	}
+	content, err := readBlock(f.stream(offset, length), maxInventoryBlock)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return readProtoMessage(content)
+}
+
+// emptyOrcStream returns the stream of columns whose stream is not stored, like the data of columns of nulls
+func emptyOrcStream() *orcStream {
+	return &orcStream{file: &orcFile{}, content: bytes.NewReader(nil)}
+}
+
+// orcStream reads a stream of an ORC file, one compression chunk at a time
+type orcStream struct {
+	file    *orcFile
+	content io.Reader
+	chunk   []byte
+}
+
+func (s *orcStream) Read(p []byte) (int, error) {
+	for len(s.chunk) == 0 {
+		if err := s.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.chunk)
+	s.chunk = s.chunk[n:]
+	return n, nil
+}
+
+func (s *orcStream) ReadByte() (byte, error) {
+	for len(s.chunk) == 0 {
+		if err := s.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	value := s.chunk[0]
+	s.chunk = s.chunk[1:]
+	return value, nil
+}
+
+// readChunk reads the next compression chunk, whose header of 3 bytes holds its length and whether it is compressed
+func (s *orcStream) readChunk() error {
+	if s.file.compression == orcNone {
+		chunk, err := readBlock(s.content, 64<<10)
+		if len(chunk) == 0 {
+			return io.EOF
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		s.chunk = chunk
+		return err
+	}
+
+	var header [3]byte
+	if _, err := io.ReadFull(s.content, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errInventoryCorrupted
+		}
+		return err
+	}
+	value := uint64(header[0]) | uint64(header[1])<<8 | uint64(header[2])<<16
+	chunk, err := readBlock(s.content, value>>1)
+	if err != nil {
+		return errInventoryCorrupted
+	}
+	if value&1 == 1 {
+		// Chunks that compression would not make smaller are stored as is
+		s.chunk = chunk
+		return nil
+	}
+	switch s.file.compression {
+	case orcZlib:
+		// Chunks are deflated without zlib header
+		reader := flate.NewReader(bytes.NewReader(chunk))
+		if s.chunk, err = readBlock(reader, s.file.blockSize); err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		return err
+	case orcSnappy:
+		if s.chunk, err = decodeSnappy(chunk); err == nil && uint64(len(s.chunk)) > s.file.blockSize {
+			err = errInventoryCorrupted
+		}
+		return err
+	}
+	return fmt.Errorf("unsupported ORC compression %d", s.file.compression)
+}
+
+// readOrc returns the records of an ORC data file
+func readOrc(manifest *inventoryManifest, file io.ReaderAt, size int64) (inventoryRecords, error) {
+	var tail [1]byte
+	if size < int64(len(orcMagic)+1) {
+		return nil, errInventoryCorrupted
+	}
+	if _, err := file.ReadAt(tail[:], size-1); err != nil {
+		return nil, err
+	}
+	postscriptLength := int64(tail[0])
+	if postscriptLength+1 > size {
+		return nil, errInventoryCorrupted
+	}
+	content := make([]byte, postscriptLength)
+	if _, err := file.ReadAt(content, size-1-postscriptLength); err != nil {
+		return nil, err
+	}
+	postscript, err := readProtoMessage(content)
+	if err != nil || postscript.string(8000) != orcMagic {
+		return nil, errInventoryCorrupted
+	}
+
+	orc := &orcFile{file: file, compression: postscript.uint(2), blockSize: postscript.uint(3)}
+	if orc.compression != orcNone && orc.blockSize == 0 {
+		orc.blockSize = 256 << 10
+	}
+	if orc.blockSize > maxInventoryBlock {
+		return nil, errInventoryCorrupted
+	}
+	footerLength := int64(postscript.uint(1))
+	footerOffset := size - 1 - postscriptLength - footerLength
+	if footerLength < 0 || footerOffset < 0 {
+		return nil, errInventoryCorrupted
+	}
+	footer, err := orc.message(footerOffset, footerLength)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ORC footer: %w", err)
+	}
+
+	types, err := footer.messages(4)
+	if err != nil || len(types) == 0 || types[0].uint(1) != orcStruct {
+		return nil, errInventoryCorrupted
+	}
+	// Columns are numbered as types, the fields of the root struct being its subtypes
+	fields, err := types[0].uints(2)
+	if err != nil {
+		return nil, err
+	}
+	needed := map[uint64]int{}
+	for i, name := range types[0].strings(3) {
+		if index, ok := manifest.columns[inventoryColumnName(name)]; ok && i < len(fields) && fields[i] < uint64(len(types)) {
+			needed[fields[i]] = index
+		}
+	}
+
+	stripes, err := footer.messages(3)
+	if err != nil {
+		return nil, err
+	}
+	return newColumnarRecords(manifest, func() ([]inventoryColumn, int64, error) {
+		if len(stripes) == 0 {
+			return nil, 0, io.EOF
+		}
+		stripe := stripes[0]
+		stripes = stripes[1:]
+		columns, err := orc.readStripe(stripe, types, needed, manifest.width())
+		return columns, int64(stripe.uint(5)), err
+	}), nil
+}
+
+// readStripe returns the columns of a stripe laid out as the columns of the records, needed mapping the ORC columns
+// read to their index in the records
+func (f *orcFile) readStripe(stripe protoMessage, types []protoMessage, needed map[uint64]int, width int) ([]inventoryColumn, error) {
+	offset := int64(stripe.uint(1))
+	length := int64(stripe.uint(2) + stripe.uint(3))
+	footer, err := f.message(offset+length, int64(stripe.uint(4)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ORC stripe footer: %w", err)
+	}
+	encodings, err := footer.messages(2)
+	if err != nil {
+		return nil, err
+	}
+	base := orcTimestampBase.Unix()
+	if timezone := footer.string(3); timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone of ORC stripe: %w", err)
+		}
+		base = time.Date(2015, 1, 1, 0, 0, 0, 0, location).Unix()
+	}
+
+	// Streams are stored one after the other, from the offset of the stripe
+	streams := map[uint64]map[uint64]*orcStream{}
+	descriptions, err := footer.messages(1)
+	if err != nil {
+		return nil, err
+	}
+	for _, stream := range descriptions {
+		column, kind, length := stream.uint(2), stream.uint(1), int64(stream.uint(3))
+		if _, ok := needed[column]; ok {
+			if streams[column] == nil {
+				streams[column] = map[uint64]*orcStream{}
+			}
+			streams[column][kind] = f.stream(offset, length)
+		}
+		offset += length
+	}
+
+	columns := make([]inventoryColumn, width)
+	for column, index := range needed {
+		if column >= uint64(len(encodings)) {
+			return nil, errInventoryCorrupted
+		}
+		kind := types[column].uint(1)
+		if kind == orcTimestampZ {
+			columns[index], err = newOrcColumn(kind, encodings[column], streams[column], orcTimestampBase.Unix())
+		} else {
+			columns[index], err = newOrcColumn(kind, encodings[column], streams[column], base)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return columns, nil
+}
+
+// orcColumn reads the values of a column of a stripe
+type orcColumn struct {
+	// present tells which values are not null, nil when none are
+	present *orcBooleans
+	value   func() (string, error)
+}
+
+func newOrcColumn(kind uint64, encoding protoMessage, streams map[uint64]*orcStream, base int64) (*orcColumn, error) {
+	column := &orcColumn{}
+	if stream := streams[orcPresent]; stream != nil {
+		column.present = &orcBooleans{bytes: &orcBytes{stream: stream}}
+	}
+	data := streams[orcData]
+	if data == nil {
+		data = emptyOrcStream()
+	}
+	v2 := encoding.uint(1) == orcDirectV2 || encoding.uint(1) == orcDictionaryV2
+	integers := func(stream *orcStream, signed bool) orcIntegers {
+		if stream == nil {
+			stream = emptyOrcStream()
+		}
+		if v2 {
+			return &orcRLEv2{stream: stream, signed: signed}
+		}
+		return &orcRLEv1{stream: stream, signed: signed}
+	}
+
+	switch kind {
+	case orcBoolean:
+		booleans := &orcBooleans{bytes: &orcBytes{stream: data}}
+		column.value = func() (string, error) {
+			value, err := booleans.next()
+			return strconv.FormatBool(value), err
+		}
+	case orcByte:
+		values := &orcBytes{stream: data}
+		column.value = func() (string, error) {
+			value, err := values.next()
+			return strconv.Itoa(int(int8(value))), err
+		}
+	case orcShort, orcInt, orcLong:
+		values := integers(data, true)
+		column.value = func() (string, error) {
+			value, err := values.next()
+			return strconv.FormatInt(value, 10), err
+		}
+	case orcFloat:
+		column.value = func() (string, error) {
+			var value [4]byte
+			_, err := io.ReadFull(data, value[:])
+			return strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(value[:]))), 'g', -1, 32), err
+		}
+	case orcDouble:
+		column.value = func() (string, error) {
+			var value [8]byte
+			_, err := io.ReadFull(data, value[:])
+			return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(value[:])), 'g', -1, 64), err
+		}
+	case orcString, orcBinary, orcVarchar, orcChar:
+		lengths := integers(streams[orcLength], false)
+		if encoding.uint(1) == orcDirect || encoding.uint(1) == orcDirectV2 {
+			column.value = func() (string, error) {
+				return readOrcString(data, lengths)
+			}
+			break
+		}
+		// The dictionary is read with the first value of the stripe
+		var dictionary []string
+		indexes := integers(data, false)
+		column.value = func() (string, error) {
+			if dictionary == nil {
+				dictionary = []string{}
+				content := streams[orcDictionaryData]
+				if content == nil {
+					content = emptyOrcStream()
+				}
+				for i := uint64(0); i < encoding.uint(2); i++ {
+					value, err := readOrcString(content, lengths)
+					if err != nil {
+						return "", err
+					}
+					dictionary = append(dictionary, value)
+				}
+			}
+			index, err := indexes.next()
+			if err != nil {
+				return "", err
+			}
+			if index < 0 || index >= int64(len(dictionary)) {
+				return "", errInventoryCorrupted
+			}
+			return dictionary[index], nil
+		}
+	case orcTimestamp, orcTimestampZ:
+		seconds := integers(data, true)
+		nanoseconds := integers(streams[orcSecondary], false)
+		column.value = func() (string, error) {
+			second, err := seconds.next()
+			if err != nil {
+				return "", err
+			}
+			encoded, err := nanoseconds.next()
+			if err != nil {
+				return "", err
+			}
+			// The 3 lowest bits count the trailing zeros removed from nanoseconds, minus one
+			nanosecond := encoded >> 3
+			if zeros := encoded & 7; zeros != 0 {
+				for i := int64(0); i <= zeros; i++ {
+					nanosecond *= 10
+				}
+			}
+			second += base
+			// Writers truncate the seconds of dates before the epoch toward zero
+			if second < 0 && nanosecond > 999999 {
+				second--
+			}
+			return formatInventoryTime(time.Unix(second, nanosecond)), nil
+		}
+	case orcDate:
+		days := integers(data, true)
+		column.value = func() (string, error) {
+			day, err := days.next()
+			return formatInventoryTime(time.Unix(day*86400, 0)), err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported ORC column type %d", kind)
+	}
+	return column, nil
+}
+
+func (c *orcColumn) next() (string, error) {
+	if c.present != nil {
+		present, err := c.present.next()
+		if err != nil || !present {
+			return "", err
+		}
+	}
+	return c.value()
+}
+
+// readOrcString reads a string of the length read from lengths
+func readOrcString(content io.Reader, lengths orcIntegers) (string, error) {
+	length, err := lengths.next()
+	if err != nil {
+		return "", err
+	}
+	if length < 0 {
+		return "", errInventoryCorrupted
+	}
+	value, err := readBlock(content, uint64(length))
+	if err == io.ErrUnexpectedEOF {
+		err = errInventoryCorrupted
+	}
+	return string(value), err
+}
+
+// orcBytes decodes the run length encoding of bytes
+type orcBytes struct {
+	stream *orcStream
+	// run holds what is left of the current run or literals
+	run    []byte
+	repeat int
+}
+
+func (b *orcBytes) next() (byte, error) {
+	if b.repeat == 0 && len(b.run) == 0 {
+		header, err := b.stream.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if header < 0x80 {
+			// Run of 3 to 130 bytes
+			value, err := b.stream.ReadByte()
+			if err != nil {
+				return 0, errInventoryCorrupted
+			}
+			b.run, b.repeat = []byte{value}, int(header)+3
+		} else {
+			// 1 to 128 literals
+			b.run = make([]byte, 0x100-int(header))
+			if _, err := io.ReadFull(b.stream, b.run); err != nil {
+				return 0, errInventoryCorrupted
+			}
+		}
+	}
+	value := b.run[0]
+	if b.repeat > 0 {
+		if b.repeat--; b.repeat == 0 {
+			b.run = nil
+		}
+	} else {
+		b.run = b.run[1:]
+	}
+	return value, nil
+}
+
+// orcBooleans decodes booleans, stored from the most significant bit of run length encoded bytes
+type orcBooleans struct {
+	bytes *orcBytes
+	value byte
+	bits  int
+}
+
+func (b *orcBooleans) next() (bool, error) {
+	if b.bits == 0 {
+		value, err := b.bytes.next()
+		if err != nil {
+			return false, err
+		}
+		b.value, b.bits = value, 8
+	}
+	b.bits--
+	return b.value>>b.bits&1 == 1, nil
+}
+
+// orcIntegers decodes the run length encoding of integers
+type orcIntegers interface {
+	next() (int64, error)
+}
+
+// orcRLEv1 decodes the first version of the run length encoding of integers
+type orcRLEv1 struct {
+	stream *orcStream
+	signed bool
+	values []int64
+}
+
+func (r *orcRLEv1) next() (int64, error) {
+	if len(r.values) == 0 {
+		header, err := r.stream.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if header < 0x80 {
+			// Run of 3 to 130 values, each one differing from the previous one by a delta
+			delta, err := r.stream.ReadByte()
+			if err != nil {
+				return 0, errInventoryCorrupted
+			}
+			value, err := r.varint()
+			if err != nil {
+				return 0, err
+			}
+			for i := 0; i < int(header)+3; i++ {
+				r.values = append(r.values, value+int64(i)*int64(int8(delta)))
+			}
+		} else {
+			for i := 0; i < 0x100-int(header); i++ {
+				value, err := r.varint()
+				if err != nil {
+					return 0, err
+				}
+				r.values = append(r.values, value)
+			}
+		}
+	}
+	value := r.values[0]
+	r.values = r.values[1:]
+	return value, nil
+}
+
+func (r *orcRLEv1) varint() (int64, error) {
+	return readOrcVarint(r.stream, r.signed)
+}
+
+// readOrcVarint reads a base 128 varint, zigzag encoded when signed
+func readOrcVarint(stream *orcStream, signed bool) (int64, error) {
+	if signed {
+		value, err := binary.ReadVarint(stream)
+		if err != nil {
+			return 0, errInventoryCorrupted
+		}
+		return value, nil
+	}
+	value, err := binary.ReadUvarint(stream)
+	if err != nil {
+		return 0, errInventoryCorrupted
+	}
+	return int64(value), nil
+}
+
+// orcRLEv2 decodes the second version of the run length encoding of integers, whose runs are encoded in one of 4
+// sub-encodings named after the 2 highest bits of their first byte
+type orcRLEv2 struct {
+	stream *orcStream
+	signed bool
+	values []int64
+}
+
+// orcWidths maps the codes of widths to widths in bits
+var orcWidths = [32]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24,
+	26, 28, 30, 32, 40, 48, 56, 64}
+
+// orcClosestWidth returns the smallest width of orcWidths holding bits
+func orcClosestWidth(bits int) int {
+	for _, width := range orcWidths {
+		if width >= bits {
+			return width
+		}
+	}
+	return 64
+}
+
+func (r *orcRLEv2) next() (int64, error) {
+	if len(r.values) == 0 {
+		header, err := r.stream.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch header >> 6 {
+		case 0:
+			err = r.shortRepeat(header)
+		case 1:
+			err = r.direct(header)
+		case 2:
+			err = r.patchedBase(header)
+		default:
+			err = r.delta(header)
+		}
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = errInventoryCorrupted
+			}
+			return 0, err
+		}
+	}
+	value := r.values[0]
+	r.values = r.values[1:]
+	return value, nil
+}
+
+// decode decodes zigzag encoded values when signed
+func (r *orcRLEv2) decode(value uint64) int64 {
+	if r.signed {
+		return int64(value>>1) ^ -int64(value&1)
+	}
+	return int64(value)
+}
+
+// length reads the 9 bits length of runs from header and the next byte
+func (r *orcRLEv2) length(header byte) (int, error) {
+	low, err := r.stream.ReadByte()
+	return (int(header&1)<<8 | int(low)) + 1, err
+}
+
+// shortRepeat reads 3 to 10 repetitions of a value of 1 to 8 bytes
+func (r *orcRLEv2) shortRepeat(header byte) error {
+	value, err := r.bigEndian(int(header>>3&7) + 1)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(header&7)+3; i++ {
+		r.values = append(r.values, r.decode(value))
+	}
+	return nil
+}
+
+// direct reads values packed with a fixed width
+func (r *orcRLEv2) direct(header byte) error {
+	length, err := r.length(header)
+	if err != nil {
+		return err
+	}
+	values, err := r.unpack(length, orcWidths[header>>1&0x1f])
+	for _, value := range values {
+		r.values = append(r.values, r.decode(value))
+	}
+	return err
+}
+
+// patchedBase reads values relative to a base, packed with a fixed width, the high bits of the few values wider than
+// it being patched after them
+func (r *orcRLEv2) patchedBase(header byte) error {
+	length, err := r.length(header)
+	if err != nil {
+		return err
+	}
+	var headers [2]byte
+	if _, err := io.ReadFull(r.stream, headers[:]); err != nil {
+		return err
+	}
+	width := orcWidths[header>>1&0x1f]
+	baseWidth := int(headers[0]>>5) + 1
+	patchWidth := orcWidths[headers[0]&0x1f]
+	gapWidth := int(headers[1]>>5) + 1
+	patches := int(headers[1] & 0x1f)
+
+	// The base is stored as sign and magnitude
+	encoded, err := r.bigEndian(baseWidth)
+	if err != nil {
+		return err
+	}
+	sign := uint64(1) << (8*baseWidth - 1)
+	base := int64(encoded &^ sign)
+	if encoded&sign != 0 {
+		base = -base
+	}
+	values, err := r.unpack(length, width)
+	if err != nil {
+		return err
+	}
+	entries, err := r.unpack(patches, orcClosestWidth(gapWidth+patchWidth))
+	if err != nil {
+		return err
+	}
+	position := 0
+	for _, entry := range entries {
+		// A gap of 255 with an empty patch only moves to the next patch
+		position += int(entry >> patchWidth)
+		patch := entry & (1<<patchWidth - 1)
+		if patch == 0 {
+			continue
+		}
+		if position >= len(values) || width >= 64 {
+			return errInventoryCorrupted
+		}
+		values[position] |= patch << width
+	}
+	for _, value := range values {
+		r.values = append(r.values, base+int64(value))
+	}
+	return nil
+}
+
+// delta reads a base value and a first delta, followed by the other deltas packed with a fixed width, all of them
+// with the sign of the first one; without width, the delta is fixed
+func (r *orcRLEv2) delta(header byte) error {
+	width := 0
+	if code := header >> 1 & 0x1f; code != 0 {
+		width = orcWidths[code]
+	}
+	length, err := r.length(header)
+	if err != nil {
+		return err
+	}
+	base, err := readOrcVarint(r.stream, r.signed)
+	if err != nil {
+		return err
+	}
+	delta, err := readOrcVarint(r.stream, true)
+	if err != nil {
+		return err
+	}
+	r.values = append(r.values, base)
+	if width == 0 {
+		for i := 1; i < length; i++ {
+			r.values = append(r.values, base+int64(i)*delta)
+		}
+		return nil
+	}
+	if length > 1 {
+		base += delta
+		r.values = append(r.values, base)
+	}
+	deltas, err := r.unpack(length-2, width)
+	for _, value := range deltas {
+		if delta < 0 {
+			base -= int64(value)
+		} else {
+			base += int64(value)
+		}
+		r.values = append(r.values, base)
+	}
+	return err
+}
+
+// bigEndian reads an unsigned value of size bytes
+func (r *orcRLEv2) bigEndian(size int) (uint64, error) {
+	var value uint64
+	for i := 0; i < size; i++ {
+		b, err := r.stream.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value = value<<8 | uint64(b)
+	}
+	return value, nil
+}
+
+// unpack reads count values of width bits, packed from the most significant bit, the last byte being padded
+func (r *orcRLEv2) unpack(count int, width int) ([]uint64, error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	packed := make([]byte, (count*width+7)/8)
+	if _, err := io.ReadFull(r.stream, packed); err != nil {
+		return nil, err
+	}
+	values := make([]uint64, count)
+	for i := range values {
+		for bit := i * width; bit < (i+1)*width; bit++ {
+			values[i] = values[i]<<1 | uint64(packed[bit/8]>>(7-bit%8)&1)
+		}
+	}
+	return values, nil
+}
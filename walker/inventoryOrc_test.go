@@ -0,0 +1,441 @@
+package walker
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// orcSchema is the schema of the ORC data files of the tests
+const orcSchema = "struct<bucket:string,key:string,version_id:string,is_latest:boolean,is_delete_marker:boolean,size:bigint,last_modified_date:timestamp,storage_class:string>"
+
+func appendUvarint(content []byte, value uint64) []byte {
+	var varint [binary.MaxVarintLen64]byte
+	return append(content, varint[:binary.PutUvarint(varint[:], value)]...)
+}
+
+func protoVarint(field uint64, value uint64) []byte {
+	return appendUvarint(appendUvarint(nil, field<<3), value)
+}
+
+func protoBytes(field uint64, content []byte) []byte {
+	return append(appendUvarint(appendUvarint(nil, field<<3|2), uint64(len(content))), content...)
+}
+
+// orcWriter writes the ORC data files of the tests, holding the records of columnarTestRecord in stripes of 150 rows
+type orcWriter struct {
+	compression uint64
+	content     bytes.Buffer
+}
+
+// compress splits content in compression chunks of at most 1 KiB; snappy chunks are written as literals
+func (w *orcWriter) compress(content []byte) []byte {
+	if w.compression == orcNone {
+		return content
+	}
+	var compressed []byte
+	for len(content) > 0 {
+		chunk := content
+		if len(chunk) > 1024 {
+			chunk = chunk[:1024]
+		}
+		content = content[len(chunk):]
+
+		var buffer bytes.Buffer
+		if w.compression == orcZlib {
+			writer, _ := flate.NewWriter(&buffer, flate.BestCompression)
+			_, _ = writer.Write(chunk)
+			_ = writer.Close()
+		} else {
+			buffer.Write(appendUvarint(nil, uint64(len(chunk))))
+			for literal := chunk; len(literal) > 0; {
+				size := len(literal)
+				if size > 60 {
+					size = 60
+				}
+				buffer.WriteByte(byte(size-1) << 2)
+				buffer.Write(literal[:size])
+				literal = literal[size:]
+			}
+		}
+		header := uint32(buffer.Len()) << 1
+		if buffer.Len() >= len(chunk) {
+			// Stored as is
+			header = uint32(len(chunk))<<1 | 1
+			buffer.Reset()
+			buffer.Write(chunk)
+		}
+		compressed = append(compressed, byte(header), byte(header>>8), byte(header>>16))
+		compressed = append(compressed, buffer.Bytes()...)
+	}
+	return compressed
+}
+
+// orcBytesRLE encodes values with the run length encoding of bytes
+func orcBytesRLE(values []byte) []byte {
+	var encoded, literals []byte
+	flush := func() {
+		if len(literals) > 0 {
+			encoded = append(append(encoded, byte(0x100-len(literals))), literals...)
+			literals = nil
+		}
+	}
+	for i := 0; i < len(values); {
+		run := 1
+		for i+run < len(values) && values[i+run] == values[i] && run < 130 {
+			run++
+		}
+		if run >= 3 {
+			flush()
+			encoded = append(encoded, byte(run-3), values[i])
+			i += run
+			continue
+		}
+		literals = append(literals, values[i])
+		if len(literals) == 128 {
+			flush()
+		}
+		i++
+	}
+	flush()
+	return encoded
+}
+
+// orcBooleansRLE packs values from the most significant bit and encodes them with the run length encoding of bytes
+func orcBooleansRLE(values []bool) []byte {
+	packed := make([]byte, (len(values)+7)/8)
+	for i, value := range values {
+		if value {
+			packed[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return orcBytesRLE(packed)
+}
+
+// orcRLEv1Encode encodes unsigned values with the first version of the run length encoding of integers, runs
+// having a fixed delta
+func orcRLEv1Encode(values []int64) []byte {
+	var encoded []byte
+	var literals []int64
+	flush := func() {
+		if len(literals) > 0 {
+			encoded = append(encoded, byte(0x100-len(literals)))
+			for _, literal := range literals {
+				encoded = appendUvarint(encoded, uint64(literal))
+			}
+			literals = nil
+		}
+	}
+	for i := 0; i < len(values); {
+		run := 1
+		if i+1 < len(values) {
+			delta := values[i+1] - values[i]
+			for delta >= -128 && delta <= 127 && i+run < len(values) && values[i+run]-values[i+run-1] == delta && run < 130 {
+				run++
+			}
+			if run >= 3 {
+				flush()
+				encoded = append(encoded, byte(run-3), byte(int8(delta)))
+				encoded = appendUvarint(encoded, uint64(values[i]))
+				i += run
+				continue
+			}
+		}
+		literals = append(literals, values[i])
+		if len(literals) == 128 {
+			flush()
+		}
+		i++
+	}
+	flush()
+	return encoded
+}
+
+// orcRLEv2Encode encodes values with the DIRECT sub-encoding of the second version of the run length encoding of
+// integers, with a width of 64 bits
+func orcRLEv2Encode(values []int64, signed bool) []byte {
+	var encoded []byte
+	for len(values) > 0 {
+		run := values
+		if len(run) > 512 {
+			run = run[:512]
+		}
+		values = values[len(run):]
+		encoded = append(encoded, 0x40|31<<1|byte((len(run)-1)>>8), byte(len(run)-1))
+		for _, value := range run {
+			if signed {
+				value = value<<1 ^ value>>63
+			}
+			var word [8]byte
+			binary.BigEndian.PutUint64(word[:], uint64(value))
+			encoded = append(encoded, word[:]...)
+		}
+	}
+	return encoded
+}
+
+// orcNanoseconds encodes nanoseconds as ORC timestamps do, without their trailing zeros
+func orcNanoseconds(nanoseconds int64) int64 {
+	zeros := int64(0)
+	for nanoseconds != 0 && nanoseconds%10 == 0 && zeros < 8 {
+		nanoseconds /= 10
+		zeros++
+	}
+	if zeros < 2 {
+		for ; zeros > 0; zeros-- {
+			nanoseconds *= 10
+		}
+		return nanoseconds << 3
+	}
+	return nanoseconds<<3 | (zeros - 1)
+}
+
+// orcTestStream is a stream of a stripe
+type orcTestStream struct {
+	kind    uint64
+	column  uint64
+	content []byte
+}
+
+// writeStripe writes the records from first to last, the timestamps being relative to the timezone
+func (w *orcWriter) writeStripe(first int, last int, timezone string) []byte {
+	var records [][]string
+	for i := first; i < last; i++ {
+		records = append(records, columnarTestRecord(i))
+	}
+	column := func(i int) []string {
+		var values []string
+		for _, record := range records {
+			values = append(values, record[i])
+		}
+		return values
+	}
+	present := func(values []string) []bool {
+		var present []bool
+		for _, value := range values {
+			present = append(present, value != "")
+		}
+		return present
+	}
+	booleans := func(values []string) []bool {
+		var booleans []bool
+		for _, value := range values {
+			booleans = append(booleans, value == "true")
+		}
+		return booleans
+	}
+	// dictionary returns the distinct values in their order and the index of each value
+	dictionary := func(values []string) ([]string, []int64) {
+		var distinct []string
+		var indexes []int64
+		for _, value := range values {
+			if value == "" {
+				continue
+			}
+			index := -1
+			for i, known := range distinct {
+				if known == value {
+					index = i
+				}
+			}
+			if index < 0 {
+				index = len(distinct)
+				distinct = append(distinct, value)
+			}
+			indexes = append(indexes, int64(index))
+		}
+		return distinct, indexes
+	}
+	lengths := func(values []string) []int64 {
+		var lengths []int64
+		for _, value := range values {
+			if value != "" {
+				lengths = append(lengths, int64(len(value)))
+			}
+		}
+		return lengths
+	}
+
+	streams := []orcTestStream{{kind: 6, column: 0, content: []byte("index")}}
+	encodings := [][]byte{protoVarint(1, orcDirect)}
+	// bucket and storage_class are dictionaries of the second version
+	for _, i := range []int{0, 7} {
+		values, indexes := dictionary(column(i))
+		streams = append(streams,
+			orcTestStream{orcData, uint64(i + 1), orcRLEv2Encode(indexes, false)},
+			orcTestStream{orcDictionaryData, uint64(i + 1), []byte(strings.Join(values, ""))},
+			orcTestStream{orcLength, uint64(i + 1), orcRLEv2Encode(lengths(values), false)})
+	}
+	// key is direct
+	streams = append(streams,
+		orcTestStream{orcData, 2, []byte(strings.Join(column(1), ""))},
+		orcTestStream{orcLength, 2, orcRLEv2Encode(lengths(column(1)), false)})
+	// version_id is a dictionary of the first version, with nulls
+	versions, indexes := dictionary(column(2))
+	streams = append(streams,
+		orcTestStream{orcPresent, 3, orcBooleansRLE(present(column(2)))},
+		orcTestStream{orcData, 3, orcRLEv1Encode(indexes)},
+		orcTestStream{orcDictionaryData, 3, []byte(strings.Join(versions, ""))},
+		orcTestStream{orcLength, 3, orcRLEv1Encode(lengths(versions))})
+	// Booleans
+	for _, i := range []int{3, 4} {
+		streams = append(streams, orcTestStream{orcData, uint64(i + 1), orcBooleansRLE(booleans(column(i)))})
+	}
+	// size is a long with nulls
+	var sizes []int64
+	for _, value := range column(5) {
+		if size, err := strconv.ParseInt(value, 10, 64); err == nil {
+			sizes = append(sizes, size)
+		}
+	}
+	streams = append(streams,
+		orcTestStream{orcPresent, 6, orcBooleansRLE(present(column(5)))},
+		orcTestStream{orcData, 6, orcRLEv2Encode(sizes, true)})
+	// last_modified_date counts seconds and nanoseconds since 2015 in the timezone
+	location, _ := time.LoadLocation(timezone)
+	base := time.Date(2015, 1, 1, 0, 0, 0, 0, location)
+	var seconds, nanoseconds []int64
+	for _, value := range column(6) {
+		date, _ := time.Parse(time.RFC3339Nano, value)
+		seconds = append(seconds, date.Unix()-base.Unix())
+		nanoseconds = append(nanoseconds, orcNanoseconds(int64(date.Nanosecond())))
+	}
+	streams = append(streams,
+		orcTestStream{orcData, 7, orcRLEv2Encode(seconds, true)},
+		orcTestStream{orcSecondary, 7, orcRLEv2Encode(nanoseconds, false)})
+	encodings = append(encodings,
+		protoVarint(1, orcDictionaryV2), protoVarint(1, orcDirectV2), protoVarint(1, orcDictionary), protoVarint(1, orcDirect),
+		protoVarint(1, orcDirect), protoVarint(1, orcDirectV2), protoVarint(1, orcDirectV2), protoVarint(1, orcDictionaryV2))
+	encodings[1] = append(encodings[1], protoVarint(2, 1)...)
+	encodings[3] = append(encodings[3], protoVarint(2, uint64(len(versions)))...)
+	classes, _ := dictionary(column(7))
+	encodings[8] = append(encodings[8], protoVarint(2, uint64(len(classes)))...)
+
+	offset := uint64(w.content.Len())
+	var footer []byte
+	var indexLength uint64
+	for _, stream := range streams {
+		content := w.compress(stream.content)
+		w.content.Write(content)
+		if stream.kind == 6 {
+			indexLength += uint64(len(content))
+		}
+		footer = append(footer, protoBytes(1, append(append(protoVarint(1, stream.kind), protoVarint(2, stream.column)...),
+			protoVarint(3, uint64(len(content)))...))...)
+	}
+	for _, encoding := range encodings {
+		footer = append(footer, protoBytes(2, encoding)...)
+	}
+	footer = w.compress(append(footer, protoBytes(3, []byte(timezone))...))
+	dataLength := uint64(w.content.Len()) - offset - indexLength
+	w.content.Write(footer)
+
+	stripe := append(protoVarint(1, offset), protoVarint(2, indexLength)...)
+	stripe = append(stripe, protoVarint(3, dataLength)...)
+	stripe = append(stripe, protoVarint(4, uint64(len(footer)))...)
+	return append(stripe, protoVarint(5, uint64(last-first))...)
+}
+
+// write returns the ORC data file of the tests
+func (w *orcWriter) write() []byte {
+	w.content.WriteString(orcMagic)
+	var footer []byte
+	footer = append(footer, protoBytes(3, w.writeStripe(0, 150, "UTC"))...)
+	footer = append(footer, protoBytes(3, w.writeStripe(150, columnarTestRows, "Europe/Paris"))...)
+
+	var fields, names []byte
+	for i, name := range []string{"bucket", "key", "version_id", "is_latest", "is_delete_marker", "size", "last_modified_date", "storage_class"} {
+		fields = appendUvarint(fields, uint64(i+1))
+		names = append(names, protoBytes(3, []byte(name))...)
+	}
+	footer = append(footer, protoBytes(4, append(append(protoVarint(1, orcStruct), protoBytes(2, fields)...), names...))...)
+	for _, kind := range []uint64{orcString, orcString, orcString, orcBoolean, orcBoolean, orcLong, orcTimestamp, orcString} {
+		footer = append(footer, protoBytes(4, protoVarint(1, kind))...)
+	}
+	footer = w.compress(append(footer, protoVarint(6, columnarTestRows)...))
+	w.content.Write(footer)
+
+	postscript := append(protoVarint(1, uint64(len(footer))), protoVarint(2, w.compression)...)
+	postscript = append(postscript, protoVarint(3, 1024)...)
+	postscript = append(postscript, protoBytes(8000, []byte(orcMagic))...)
+	w.content.Write(postscript)
+	w.content.WriteByte(byte(len(postscript)))
+	return w.content.Bytes()
+}
+
+func TestReadOrc(t *testing.T) {
+	for name, compression := range map[string]uint64{"none": orcNone, "zlib": orcZlib, "snappy": orcSnappy} {
+		t.Run(name, func(t *testing.T) {
+			content := (&orcWriter{compression: compression}).write()
+			manifest := &inventoryManifest{FileFormat: inventoryORC, FileSchema: orcSchema, columns: map[string]int{}}
+			for i, match := range orcSchemaField.FindAllStringSubmatch(orcSchema, -1) {
+				manifest.columns[inventoryColumnName(match[1])] = i
+			}
+			records, err := readOrc(manifest, bytes.NewReader(content), int64(len(content)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkColumnarRecords(t, records)
+			checkColumnarWalk(t, "ORC", orcSchema, "inventory.orc", content)
+		})
+	}
+}
+
+// TestOrcRLEv2 checks the sub-encodings of the second version of the run length encoding of integers with the
+// examples of the specification
+func TestOrcRLEv2(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		encoded  []byte
+		expected []int64
+	}{
+		{"short repeat", []byte{0x0a, 0x27, 0x10}, []int64{10000, 10000, 10000, 10000, 10000}},
+		{"direct", []byte{0x5e, 0x03, 0x5c, 0xa1, 0xab, 0x1e, 0xde, 0xad, 0xbe, 0xef}, []int64{23713, 43806, 57005, 48879}},
+		{"patched base", []byte{0x8e, 0x13, 0x2b, 0x21, 0x07, 0xd0, 0x1e, 0x00, 0x14, 0x70, 0x28, 0x32, 0x3c, 0x46, 0x50, 0x5a, 0x64, 0x6e,
+			0x78, 0x82, 0x8c, 0x96, 0xa0, 0xaa, 0xb4, 0xbe, 0xfc, 0xe8},
+			[]int64{2030, 2000, 2020, 1000000, 2040, 2050, 2060, 2070, 2080, 2090, 2100, 2110, 2120, 2130, 2140, 2150, 2160, 2170, 2180, 2190}},
+		{"delta", []byte{0xc6, 0x09, 0x02, 0x02, 0x22, 0x42, 0x42, 0x46}, []int64{2, 3, 5, 7, 11, 13, 17, 19, 23, 29}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			decoder := &orcRLEv2{stream: &orcStream{file: &orcFile{}, content: bytes.NewReader(test.encoded)}}
+			var values []int64
+			for {
+				value, err := decoder.next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				values = append(values, value)
+			}
+			if !reflect.DeepEqual(values, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, values)
+			}
+		})
+	}
+}
+
+// TestReadOrcErrors checks that truncated and corrupted ORC data files are reported as errors
+func TestReadOrcErrors(t *testing.T) {
+	content := (&orcWriter{compression: orcZlib}).write()
+	manifest := &inventoryManifest{FileFormat: inventoryORC, columns: map[string]int{"Key": 0, "Size": 1}}
+	for name, corrupted := range map[string][]byte{
+		"truncated": content[len(content)/2:],
+		"streams":   append(append(append([]byte{}, content[:3]...), bytes.Repeat([]byte{0xff}, len(content)/2)...), content[3+len(content)/2:]...),
+	} {
+		records, err := readOrc(manifest, bytes.NewReader(corrupted), int64(len(corrupted)))
+		for err == nil {
+			_, err = records.Read()
+		}
+		if err == io.EOF {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,766 @@
+package walker
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parquet data files are decoded as described by https://github.com/apache/parquet-format, their metadata being
+// encoded with the compact protocol of Thrift
+
+const parquetMagic = "PAR1"
+
+// Physical types of Parquet columns
+const (
+	parquetBoolean = iota
+	parquetInt32
+	parquetInt64
+	parquetInt96
+	parquetFloat
+	parquetDouble
+	parquetByteArray
+	parquetFixedLenByteArray
+)
+
+// Encodings of Parquet pages
+const (
+	parquetPlain                = 0
+	parquetPlainDictionary      = 2
+	parquetRLE                  = 3
+	parquetDeltaBinaryPacked    = 5
+	parquetDeltaLengthByteArray = 6
+	parquetDeltaByteArray       = 7
+	parquetRLEDictionary        = 8
+)
+
+// Types of Parquet pages
+const (
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+	parquetDataPageV2     = 3
+)
+
+// julianUnixEpoch is the julian day of the Unix epoch, as INT96 timestamps count days
+const julianUnixEpoch = 2440588
+
+// thriftStruct holds the fields of a Thrift struct by id: int64 for integers, bool, float64, []byte for binaries and
+// strings, []interface{} for lists and sets, map[interface{}]interface{} for maps and thriftStruct
+type thriftStruct map[int16]interface{}
+
+func (s thriftStruct) int(id int16) int64 {
+	value, _ := s[id].(int64)
+	return value
+}
+
+func (s thriftStruct) string(id int16) string {
+	value, _ := s[id].([]byte)
+	return string(value)
+}
+
+func (s thriftStruct) has(id int16) bool {
+	_, ok := s[id]
+	return ok
+}
+
+func (s thriftStruct) bool(id int16, fallback bool) bool {
+	if value, ok := s[id].(bool); ok {
+		return value
+	}
+	return fallback
+}
+
+func (s thriftStruct) struct_(id int16) thriftStruct {
+	value, _ := s[id].(thriftStruct)
+	return value
+}
+
+func (s thriftStruct) structs(id int16) []thriftStruct {
+	list, _ := s[id].([]interface{})
+	structs := make([]thriftStruct, 0, len(list))
+	for _, item := range list {
+		if value, ok := item.(thriftStruct); ok {
+			structs = append(structs, value)
+		}
+	}
+	return structs
+}
+
+// thriftReader decodes the compact protocol of Thrift
+type thriftReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// maxThriftDepth bounds the nesting of Thrift structs and containers
+const maxThriftDepth = 32
+
+func readThriftStruct(reader thriftReader, depth int) (thriftStruct, error) {
+	if depth > maxThriftDepth {
+		return nil, errInventoryCorrupted
+	}
+	fields := thriftStruct{}
+	var id int16
+	for {
+		header, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		kind := header & 0x0f
+		if kind == 0 {
+			return fields, nil
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			value, err := binary.ReadVarint(reader)
+			if err != nil {
+				return nil, err
+			}
+			id = int16(value)
+		}
+		switch kind {
+		case 1, 2:
+			// Booleans are held by the type of their field
+			fields[id] = kind == 1
+		default:
+			if fields[id], err = readThriftValue(reader, kind, depth); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func readThriftValue(reader thriftReader, kind byte, depth int) (interface{}, error) {
+	switch kind {
+	case 1, 2:
+		// Booleans of containers are bytes
+		value, err := reader.ReadByte()
+		return value == 1, err
+	case 3:
+		value, err := reader.ReadByte()
+		return int64(int8(value)), err
+	case 4, 5, 6:
+		return binary.ReadVarint(reader)
+	case 7:
+		var value [8]byte
+		if _, err := io.ReadFull(reader, value[:]); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(value[:])), nil
+	case 8:
+		size, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, err
+		}
+		return readBlock(reader, size)
+	case 9, 10:
+		header, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(header >> 4)
+		if size == 15 {
+			if size, err = binary.ReadUvarint(reader); err != nil {
+				return nil, err
+			}
+		}
+		// Lists are not allocated ahead, corrupted sizes running out of data first
+		var list []interface{}
+		for i := uint64(0); i < size; i++ {
+			item, err := readThriftValue(reader, header&0x0f, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case 11:
+		size, err := binary.ReadUvarint(reader)
+		if err != nil || size == 0 {
+			return map[interface{}]interface{}{}, err
+		}
+		kinds, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		entries := map[interface{}]interface{}{}
+		for i := uint64(0); i < size; i++ {
+			key, err := readThriftValue(reader, kinds>>4, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			value, err := readThriftValue(reader, kinds&0x0f, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if binary, ok := key.([]byte); ok {
+				key = string(binary)
+			}
+			entries[key] = value
+		}
+		return entries, nil
+	case 12:
+		return readThriftStruct(reader, depth+1)
+	}
+	return nil, errInventoryCorrupted
+}
+
+// readBlock reads size bytes of reader, bounded by maxInventoryBlock. The block grows as it is read, so that corrupted
+// sizes run out of data before being allocated.
+func readBlock(reader io.Reader, size uint64) ([]byte, error) {
+	if size > maxInventoryBlock {
+		return nil, errInventoryCorrupted
+	}
+	block, err := ioutil.ReadAll(io.LimitReader(reader, int64(size)))
+	if err == nil && uint64(len(block)) < size {
+		err = io.ErrUnexpectedEOF
+	}
+	return block, err
+}
+
+// parquetLeaf is a primitive column at the top level of the schema of a Parquet file
+type parquetLeaf struct {
+	name       string
+	physical   int64
+	typeLength int
+	// maxDefinition is 1 for optional columns, whose values are preceded by definition levels
+	maxDefinition int
+	// unit is the duration of the unit of timestamps and dates, 0 for other values
+	unit time.Duration
+}
+
+// parquetLeaves returns the top level primitive columns of the schema of a Parquet file; nested columns, unlikely in
+// inventories, are not read
+func parquetLeaves(schema []thriftStruct) map[string]parquetLeaf {
+	leaves := map[string]parquetLeaf{}
+	if len(schema) == 0 {
+		return leaves
+	}
+	// The root is the first element, its children following in depth first order
+	for i, children := 1, schema[0].int(5); i < len(schema) && children > 0; children-- {
+		element := schema[i]
+		i += parquetSubtree(schema, i)
+		// Repeated columns are lists
+		if element.int(5) > 0 || element.int(3) == 2 {
+			continue
+		}
+		leaf := parquetLeaf{name: element.string(4), physical: element.int(1), typeLength: int(element.int(2))}
+		if element.int(3) == 1 {
+			leaf.maxDefinition = 1
+		}
+		logical := element.struct_(10)
+		switch {
+		case element.int(6) == 6 || logical.has(6):
+			leaf.unit = 24 * time.Hour
+		case element.int(6) == 9:
+			leaf.unit = time.Millisecond
+		case element.int(6) == 10:
+			leaf.unit = time.Microsecond
+		case logical.has(8):
+			unit := logical.struct_(8).struct_(2)
+			leaf.unit = time.Millisecond
+			if unit.has(2) {
+				leaf.unit = time.Microsecond
+			} else if unit.has(3) {
+				leaf.unit = time.Nanosecond
+			}
+		}
+		leaves[leaf.name] = leaf
+	}
+	return leaves
+}
+
+// parquetSubtree returns the number of elements of the schema of the element at i, itself included
+func parquetSubtree(schema []thriftStruct, i int) int {
+	size := 1
+	for children := schema[i].int(5); children > 0 && i+size < len(schema); children-- {
+		size += parquetSubtree(schema, i+size)
+	}
+	return size
+}
+
+// format formats an integer value of the column
+func (l parquetLeaf) format(value int64) string {
+	if l.physical == parquetInt32 {
+		value = int64(int32(value))
+	}
+	if l.unit == 0 {
+		return strconv.FormatInt(value, 10)
+	}
+	if l.unit >= time.Second {
+		return formatInventoryTime(time.Unix(value*int64(l.unit/time.Second), 0))
+	}
+	// time.Unix normalizes negative nanoseconds
+	perSecond := int64(time.Second / l.unit)
+	return formatInventoryTime(time.Unix(value/perSecond, value%perSecond*int64(l.unit)))
+}
+
+// readParquet returns the records of a Parquet data file
+func readParquet(manifest *inventoryManifest, file io.ReaderAt, size int64) (inventoryRecords, error) {
+	var tail [8]byte
+	if size < int64(2*len(parquetMagic)+len(tail)) {
+		return nil, errInventoryCorrupted
+	}
+	if _, err := file.ReadAt(tail[:], size-int64(len(tail))); err != nil {
+		return nil, err
+	}
+	length := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if string(tail[4:]) != parquetMagic || length > size-int64(len(parquetMagic)+len(tail)) || length > maxInventoryBlock {
+		return nil, errInventoryCorrupted
+	}
+	footer := make([]byte, length)
+	if _, err := file.ReadAt(footer, size-int64(len(tail))-length); err != nil {
+		return nil, err
+	}
+	metadata, err := readThriftStruct(bytes.NewReader(footer), 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Parquet metadata: %w", err)
+	}
+
+	leaves := parquetLeaves(metadata.structs(2))
+	rowGroups := metadata.structs(4)
+	return newColumnarRecords(manifest, func() ([]inventoryColumn, int64, error) {
+		if len(rowGroups) == 0 {
+			return nil, 0, io.EOF
+		}
+		rowGroup := rowGroups[0]
+		rowGroups = rowGroups[1:]
+		columns := make([]inventoryColumn, manifest.width())
+		for _, chunk := range rowGroup.structs(1) {
+			if chunk.string(1) != "" {
+				return nil, 0, fmt.Errorf("Parquet columns in other files are not supported")
+			}
+			metadata := chunk.struct_(3)
+			var path []string
+			names, _ := metadata[3].([]interface{})
+			for _, name := range names {
+				name, _ := name.([]byte)
+				path = append(path, string(name))
+			}
+			leaf, ok := leaves[strings.Join(path, ".")]
+			index, needed := manifest.columns[inventoryColumnName(leaf.name)]
+			if !ok || !needed {
+				continue
+			}
+			offset := metadata.int(9)
+			if dictionary := metadata.int(11); dictionary > 0 && dictionary < offset {
+				offset = dictionary
+			}
+			length := metadata.int(7)
+			if offset < 0 || length < 0 || offset+length > size {
+				return nil, 0, errInventoryCorrupted
+			}
+			columns[index] = &parquetColumn{
+				leaf:   leaf,
+				codec:  metadata.int(4),
+				values: metadata.int(5),
+				chunk:  bufio.NewReader(io.NewSectionReader(file, offset, length)),
+			}
+		}
+		return columns, rowGroup.int(3), nil
+	}), nil
+}
+
+// parquetColumn reads the values of a column chunk page by page
+type parquetColumn struct {
+	leaf  parquetLeaf
+	codec int64
+	// values counts the values of the chunk, nulls included, whose pages were not read yet
+	values     int64
+	chunk      *bufio.Reader
+	dictionary []string
+	page       []string
+}
+
+func (c *parquetColumn) next() (string, error) {
+	for len(c.page) == 0 {
+		if c.values <= 0 {
+			return "", io.EOF
+		}
+		if err := c.readPage(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+	}
+	value := c.page[0]
+	c.page = c.page[1:]
+	return value, nil
+}
+
+// readPage reads the next data page of the chunk, and the dictionary page before it
+func (c *parquetColumn) readPage() error {
+	header, err := readThriftStruct(c.chunk, 0)
+	if err != nil {
+		return err
+	}
+	if header.int(3) < 0 {
+		return errInventoryCorrupted
+	}
+	content, err := readBlock(c.chunk, uint64(header.int(3)))
+	if err != nil {
+		return err
+	}
+
+	switch header.int(1) {
+	case parquetDictionaryPage:
+		content, err := c.decompress(content, header.int(2))
+		if err != nil {
+			return err
+		}
+		dictionary := header.struct_(7)
+		c.dictionary, _, err = c.plain(content, int(dictionary.int(1)))
+		return err
+	case parquetDataPage:
+		if content, err = c.decompress(content, header.int(2)); err != nil {
+			return err
+		}
+		page := header.struct_(5)
+		var levels []int64
+		if c.leaf.maxDefinition > 0 {
+			if len(content) < 4 {
+				return errInventoryCorrupted
+			}
+			length := int(binary.LittleEndian.Uint32(content))
+			if length < 0 || 4+length > len(content) {
+				return errInventoryCorrupted
+			}
+			if levels, err = decodeHybrid(content[4:4+length], 1, int(page.int(1))); err != nil {
+				return err
+			}
+			content = content[4+length:]
+		}
+		return c.decodePage(content, page.int(2), int(page.int(1)), levels)
+	case parquetDataPageV2:
+		page := header.struct_(8)
+		repetitions, definitions := page.int(6), page.int(5)
+		if repetitions != 0 || definitions < 0 || definitions > int64(len(content)) {
+			return errInventoryCorrupted
+		}
+		var levels []int64
+		if c.leaf.maxDefinition > 0 {
+			if levels, err = decodeHybrid(content[:definitions], 1, int(page.int(1))); err != nil {
+				return err
+			}
+		}
+		content = content[definitions:]
+		if page.bool(7, true) {
+			if content, err = c.decompress(content, header.int(2)-definitions); err != nil {
+				return err
+			}
+		}
+		return c.decodePage(content, page.int(4), int(page.int(1)), levels)
+	}
+	// Index pages are skipped
+	return nil
+}
+
+// decodePage decodes the count values of a data page in encoding, the definition levels of optional columns telling
+// which values are null
+func (c *parquetColumn) decodePage(content []byte, encoding int64, count int, levels []int64) error {
+	if count < 0 || int64(count) > c.values {
+		return errInventoryCorrupted
+	}
+	c.values -= int64(count)
+	present := count
+	if levels != nil {
+		present = 0
+		for _, level := range levels {
+			present += int(level)
+		}
+	}
+
+	var values []string
+	var err error
+	switch encoding {
+	case parquetPlain:
+		values, _, err = c.plain(content, present)
+	case parquetPlainDictionary, parquetRLEDictionary:
+		if len(content) == 0 && present > 0 {
+			return errInventoryCorrupted
+		}
+		var indexes []int64
+		if present > 0 {
+			indexes, err = decodeHybrid(content[1:], int(content[0]), present)
+		}
+		for _, index := range indexes {
+			if index < 0 || index >= int64(len(c.dictionary)) {
+				return errInventoryCorrupted
+			}
+			values = append(values, c.dictionary[index])
+		}
+	case parquetRLE:
+		if c.leaf.physical != parquetBoolean || len(content) < 4 {
+			return fmt.Errorf("unsupported Parquet encoding %d", encoding)
+		}
+		var booleans []int64
+		booleans, err = decodeHybrid(content[4:], 1, present)
+		for _, value := range booleans {
+			values = append(values, strconv.FormatBool(value == 1))
+		}
+	case parquetDeltaBinaryPacked:
+		var integers []int64
+		integers, _, err = decodeDeltaBinaryPacked(content, present)
+		for _, value := range integers {
+			values = append(values, c.leaf.format(value))
+		}
+	case parquetDeltaLengthByteArray:
+		values, err = decodeDeltaLengthByteArray(content, present)
+	case parquetDeltaByteArray:
+		var prefixes []int64
+		var read int
+		if prefixes, read, err = decodeDeltaBinaryPacked(content, present); err == nil {
+			values, err = decodeDeltaLengthByteArray(content[read:], present)
+		}
+		for i := range values {
+			if prefixes[i] < 0 || (i > 0 && prefixes[i] > int64(len(values[i-1]))) || (i == 0 && prefixes[i] != 0) {
+				return errInventoryCorrupted
+			}
+			if i > 0 {
+				values[i] = values[i-1][:prefixes[i]] + values[i]
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported Parquet encoding %d", encoding)
+	}
+	if err != nil {
+		return err
+	}
+	if len(values) < present {
+		return errInventoryCorrupted
+	}
+
+	if levels == nil {
+		c.page = values[:present]
+		return nil
+	}
+	c.page = make([]string, len(levels))
+	for i, level := range levels {
+		if level == 1 {
+			c.page[i] = values[0]
+			values = values[1:]
+		}
+	}
+	return nil
+}
+
+// plain decodes count values of the PLAIN encoding, returning the number of bytes read
+func (c *parquetColumn) plain(content []byte, count int) ([]string, int, error) {
+	var values []string
+	read := 0
+	for i := 0; i < count; i++ {
+		var value string
+		switch c.leaf.physical {
+		case parquetBoolean:
+			if i/8 >= len(content) {
+				return nil, 0, errInventoryCorrupted
+			}
+			value = strconv.FormatBool(content[i/8]>>(i%8)&1 == 1)
+			read = i/8 + 1
+			values = append(values, value)
+			continue
+		case parquetInt32, parquetFloat:
+			if read+4 > len(content) {
+				return nil, 0, errInventoryCorrupted
+			}
+			bits := binary.LittleEndian.Uint32(content[read:])
+			value = c.leaf.format(int64(int32(bits)))
+			if c.leaf.physical == parquetFloat {
+				value = strconv.FormatFloat(float64(math.Float32frombits(bits)), 'g', -1, 32)
+			}
+			read += 4
+		case parquetInt64, parquetDouble:
+			if read+8 > len(content) {
+				return nil, 0, errInventoryCorrupted
+			}
+			bits := binary.LittleEndian.Uint64(content[read:])
+			value = c.leaf.format(int64(bits))
+			if c.leaf.physical == parquetDouble {
+				value = strconv.FormatFloat(math.Float64frombits(bits), 'g', -1, 64)
+			}
+			read += 8
+		case parquetInt96:
+			if read+12 > len(content) {
+				return nil, 0, errInventoryCorrupted
+			}
+			nanoseconds := int64(binary.LittleEndian.Uint64(content[read:]))
+			days := int64(int32(binary.LittleEndian.Uint32(content[read+8:])))
+			value = formatInventoryTime(time.Unix((days-julianUnixEpoch)*86400, nanoseconds))
+			read += 12
+		case parquetByteArray:
+			if read+4 > len(content) {
+				return nil, 0, errInventoryCorrupted
+			}
+			length := int(binary.LittleEndian.Uint32(content[read:]))
+			read += 4
+			if length < 0 || read+length > len(content) {
+				return nil, 0, errInventoryCorrupted
+			}
+			value = string(content[read : read+length])
+			read += length
+		case parquetFixedLenByteArray:
+			if c.leaf.typeLength < 0 || read+c.leaf.typeLength > len(content) {
+				return nil, 0, errInventoryCorrupted
+			}
+			value = string(content[read : read+c.leaf.typeLength])
+			read += c.leaf.typeLength
+		default:
+			return nil, 0, fmt.Errorf("unsupported Parquet type %d", c.leaf.physical)
+		}
+		values = append(values, value)
+	}
+	return values, read, nil
+}
+
+// decompress decompresses a page compressed by the codec of the chunk into size bytes
+func (c *parquetColumn) decompress(content []byte, size int64) ([]byte, error) {
+	switch c.codec {
+	case 0:
+		return content, nil
+	case 1:
+		return decodeSnappy(content)
+	case 2:
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, errInventoryCorrupted
+		}
+		return readBlock(reader, uint64(size))
+	}
+	return nil, fmt.Errorf("unsupported Parquet compression codec %d", c.codec)
+}
+
+// decodeHybrid decodes count values of width bits of the RLE and bit-packing hybrid encoding, used by levels,
+// dictionary indexes and booleans
+func decodeHybrid(content []byte, width int, count int) ([]int64, error) {
+	if width < 0 || width > 32 {
+		return nil, errInventoryCorrupted
+	}
+	values := make([]int64, 0, count)
+	reader := bytes.NewReader(content)
+	for len(values) < count {
+		header, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return nil, errInventoryCorrupted
+		}
+		if header&1 == 0 {
+			// Run of a value stored in the bytes holding width bits
+			var value [4]byte
+			if _, err := io.ReadFull(reader, value[:(width+7)/8]); err != nil {
+				return nil, errInventoryCorrupted
+			}
+			for run := header >> 1; run > 0 && len(values) < count; run-- {
+				values = append(values, int64(binary.LittleEndian.Uint32(value[:])))
+			}
+			continue
+		}
+		// Groups of 8 values packed from the least significant bit
+		groups := header >> 1
+		if groups*uint64(width) > uint64(reader.Len()) {
+			return nil, errInventoryCorrupted
+		}
+		packed := make([]byte, groups*uint64(width))
+		_, _ = reader.Read(packed)
+		for i := 0; i < int(groups)*8 && len(values) < count; i++ {
+			values = append(values, int64(unpackLittleEndian(packed, i*width, width)))
+		}
+	}
+	return values, nil
+}
+
+// unpackLittleEndian returns the width bits of packed from the bit at offset, bits being packed from the least
+// significant one
+func unpackLittleEndian(packed []byte, offset int, width int) uint64 {
+	var value uint64
+	for i := 0; i < width; i++ {
+		bit := offset + i
+		value |= uint64(packed[bit/8]>>(bit%8)&1) << i
+	}
+	return value
+}
+
+// decodeDeltaBinaryPacked decodes count values of the DELTA_BINARY_PACKED encoding, returning the number of bytes
+// read
+func decodeDeltaBinaryPacked(content []byte, count int) ([]int64, int, error) {
+	reader := bytes.NewReader(content)
+	blockSize, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, 0, errInventoryCorrupted
+	}
+	miniBlocks, err := binary.ReadUvarint(reader)
+	if err != nil || miniBlocks == 0 || blockSize%miniBlocks != 0 || blockSize/miniBlocks%8 != 0 || blockSize > 1<<20 {
+		return nil, 0, errInventoryCorrupted
+	}
+	total, err := binary.ReadUvarint(reader)
+	if err != nil || total < uint64(count) {
+		return nil, 0, errInventoryCorrupted
+	}
+	value, err := binary.ReadVarint(reader)
+	if err != nil {
+		return nil, 0, errInventoryCorrupted
+	}
+
+	values := make([]int64, 0, count)
+	if total > 0 {
+		values = append(values, value)
+	}
+	miniBlockSize := int(blockSize / miniBlocks)
+	for uint64(len(values)) < total {
+		minDelta, err := binary.ReadVarint(reader)
+		if err != nil {
+			return nil, 0, errInventoryCorrupted
+		}
+		widths := make([]byte, miniBlocks)
+		if _, err := io.ReadFull(reader, widths); err != nil {
+			return nil, 0, errInventoryCorrupted
+		}
+		// Mini blocks are not written past the last value
+		for _, width := range widths {
+			if uint64(len(values)) >= total {
+				break
+			}
+			if width > 64 {
+				return nil, 0, errInventoryCorrupted
+			}
+			packed := make([]byte, miniBlockSize*int(width)/8)
+			if _, err := io.ReadFull(reader, packed); err != nil {
+				return nil, 0, errInventoryCorrupted
+			}
+			for i := 0; i < miniBlockSize && uint64(len(values)) < total; i++ {
+				value += minDelta + int64(unpackLittleEndian(packed, i*int(width), int(width)))
+				values = append(values, value)
+			}
+		}
+	}
+	return values[:count], len(content) - reader.Len(), nil
+}
+
+// decodeDeltaLengthByteArray decodes count values of the DELTA_LENGTH_BYTE_ARRAY encoding
+func decodeDeltaLengthByteArray(content []byte, count int) ([]string, error) {
+	lengths, read, err := decodeDeltaBinaryPacked(content, count)
+	if err != nil {
+		return nil, err
+	}
+	content = content[read:]
+	values := make([]string, 0, count)
+	for _, length := range lengths {
+		if length < 0 || length > int64(len(content)) {
+			return nil, errInventoryCorrupted
+		}
+		values = append(values, string(content[:length]))
+		content = content[length:]
+	}
+	return values, nil
+}
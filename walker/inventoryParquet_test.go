@@ -0,0 +1,135 @@
+package walker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/willena/s3-exporter/stats"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// parquetSchema is the schema of the Parquet data files of testdata, written with github.com/fraugster/parquet-go:
+// inventory.snappy.parquet is written with dictionaries in 2 row groups, and inventory.gzip.parquet with delta
+// encodings, INT96 dates and pages of the second version
+const parquetSchema = `message s3.inventory {
+  required binary bucket (STRING);
+  required binary key (STRING);
+  optional binary version_id (STRING);
+  optional boolean is_latest;
+  optional boolean is_delete_marker;
+  optional int64 size;
+  optional int64 last_modified_date (TIMESTAMP(MILLIS,true));
+  optional binary storage_class (STRING);
+}`
+
+// columnarTestRows is the number of rows of the ORC and Parquet data files of the tests
+const columnarTestRows = 300
+
+// columnarTestRecord returns the i-th record of the ORC and Parquet data files of the tests: the 2 versions of each
+// key, the current one being a delete marker every 7 keys. Keys are not URL encoded.
+func columnarTestRecord(i int) []string {
+	record := []string{"src", fmt.Sprintf("data/%04d+%%20.bin", i/2), "", strconv.FormatBool(i%2 == 0), strconv.FormatBool(i%14 == 0), "", "",
+		[]string{"STANDARD", "GLACIER", "STANDARD_IA"}[i%3]}
+	if i%5 != 0 {
+		record[2] = fmt.Sprintf("v%d", i)
+	}
+	if i%14 != 0 {
+		record[5] = strconv.Itoa(i * 1000)
+	}
+	modified := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC).Add(-time.Duration(i) * time.Hour).Add(time.Duration(i) * time.Millisecond)
+	record[6] = modified.Format(time.RFC3339Nano)
+	return record
+}
+
+// checkColumnarRecords checks that records are the records of the ORC and Parquet data files of the tests
+func checkColumnarRecords(t *testing.T, records inventoryRecords) {
+	for i := 0; i < columnarTestRows; i++ {
+		record, err := records.Read()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if expected := columnarTestRecord(i); !reflect.DeepEqual(record, expected) {
+			t.Fatalf("record %d: expected %q, got %q", i, expected, record)
+		}
+	}
+	if _, err := records.Read(); err != io.EOF {
+		t.Errorf("expected the end of the records, got %v", err)
+	}
+}
+
+// checkColumnarWalk checks the objects accounted from an inventory of the ORC or Parquet data file of the tests
+func checkColumnarWalk(t *testing.T, format string, schema string, name string, content []byte) {
+	walker := &InventoryWalker{}
+	folder := writeInventory(t, format, schema, map[string]string{name: string(content)})
+	if err := walker.Init(testConfig(t, "inventory", "--inventory.manifest", folder), map[string]string{"target": "test"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var objects, size, expectedObjects, expectedSize uint64
+	for i := 0; i < columnarTestRows; i += 2 {
+		if i%14 != 0 {
+			expectedObjects++
+			expectedSize += uint64(i * 1000)
+		}
+	}
+	for _, series := range walker.Stats.(*stats.PrometheusStats).Snapshot().Series {
+		objects += series.Objects
+		size += series.Size
+	}
+	if objects != expectedObjects || size != expectedSize {
+		t.Errorf("expected %d current objects of %d bytes, got %d objects of %d bytes", expectedObjects, expectedSize, objects, size)
+	}
+}
+
+func TestReadParquet(t *testing.T) {
+	for _, name := range []string{"inventory.snappy.parquet", "inventory.gzip.parquet"} {
+		t.Run(name, func(t *testing.T) {
+			content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			walker := &InventoryWalker{}
+			location, _ := walker.latestManifest(context.Background(), inventoryLocation{path: writeInventory(t, "Parquet", parquetSchema, nil)})
+			manifest, err := walker.readManifest(context.Background(), location)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records, err := readParquet(manifest, bytes.NewReader(content), int64(len(content)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkColumnarRecords(t, records)
+			checkColumnarWalk(t, "Parquet", parquetSchema, name, content)
+		})
+	}
+}
+
+// TestReadParquetErrors checks that truncated and corrupted Parquet data files are reported as errors
+func TestReadParquetErrors(t *testing.T) {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "inventory.snappy.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &inventoryManifest{FileFormat: inventoryParquet, columns: map[string]int{"Key": 0, "Size": 1}}
+	for name, corrupted := range map[string][]byte{
+		"truncated": content[len(content)/2:],
+		"pages":     append(append(append([]byte{}, content[:4]...), bytes.Repeat([]byte{0xff}, len(content)/2)...), content[4+len(content)/2:]...),
+	} {
+		records, err := readParquet(manifest, bytes.NewReader(corrupted), int64(len(corrupted)))
+		for err == nil {
+			_, err = records.Read()
+		}
+		if err == io.EOF {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package walker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/willena/s3-exporter/stats"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const inventoryManifestName = "manifest.json"
+
+// errInventoryFormat is returned for reports in another format than CSV, ORC and Parquet
+var errInventoryFormat = errors.New("only CSV, ORC and Parquet inventories can be read")
+
+// Formats of inventory reports
+const (
+	inventoryCSV     = "CSV"
+	inventoryORC     = "ORC"
+	inventoryParquet = "PARQUET"
+)
+
+// orcSchemaField matches the fields of the struct<name:type,...> schema of ORC reports
+var orcSchemaField = regexp.MustCompile(`[<,]\s*([A-Za-z0-9_]+)\s*:`)
+
+// parquetSchemaField matches the fields of the message schema of Parquet reports
+var parquetSchemaField = regexp.MustCompile(`(?:required|optional|repeated)\s+\S+\s+([A-Za-z0-9_]+)`)
+
+// inventoryReportFolder matches the folders of the reports of an inventory configuration, named after their date
+var inventoryReportFolder = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}-\d{2}Z$`)
+
+// inventoryEncryptions maps the encryption status of inventories to the encryption of objects headers
+var inventoryEncryptions = map[string]string{
+	"":         "none",
+	"NOT-SSE":  "none",
+	"SSE-S3":   "AES256",
+	"SSE-KMS":  "aws:kms",
+	"DSSE-KMS": "aws:kms:dsse",
+	"SSE-C":    "SSE-C",
+}
+
+// inventoryLocation is a local path, or the key of an S3 object when bucket is set
+type inventoryLocation struct {
+	bucket string
+	path   string
+}
+
+// parseInventoryLocation reads a local path or an s3://bucket/key URL
+func parseInventoryLocation(value string) (inventoryLocation, error) {
+	if !strings.HasPrefix(value, "s3://") {
+		if value == "" {
+			return inventoryLocation{}, fmt.Errorf("empty inventory location")
+		}
+		return inventoryLocation{path: value}, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, "s3://"), "/", 2)
+	if parts[0] == "" || len(parts) < 2 || strings.Trim(parts[1], "/") == "" {
+		return inventoryLocation{}, fmt.Errorf("invalid inventory location %q: s3://bucket/key expected", value)
+	}
+	return inventoryLocation{bucket: parts[0], path: strings.TrimSuffix(parts[1], "/")}, nil
+}
+
+func (l inventoryLocation) String() string {
+	if l.bucket == "" {
+		return l.path
+	}
+	return "s3://" + l.bucket + "/" + l.path
+}
+
+// inventoryManifest is the manifest.json of an inventory report, listing its data files
+type inventoryManifest struct {
+	SourceBucket      string          `json:"sourceBucket"`
+	DestinationBucket string          `json:"destinationBucket"`
+	FileFormat        string          `json:"fileFormat"`
+	FileSchema        string          `json:"fileSchema"`
+	Files             []inventoryFile `json:"files"`
+
+	location inventoryLocation
+	// columns holds the index of each column of the data files
+	columns map[string]int
+}
+
+type inventoryFile struct {
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	MD5Checksum string `json:"MD5checksum"`
+}
+
+// has tells whether the data files hold one of columns
+func (m *inventoryManifest) has(columns ...string) bool {
+	for _, column := range columns {
+		if _, ok := m.columns[column]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dataLocation returns where the data file is read: in the destination bucket for manifests read from S3, or in
+// the data folder of the inventory configuration folder, next to the report folder, for local manifests
+func (m *inventoryManifest) dataLocation(file inventoryFile) inventoryLocation {
+	if m.location.bucket == "" {
+		configFolder := filepath.Dir(filepath.Dir(m.location.path))
+		return inventoryLocation{path: filepath.Join(configFolder, "data", path.Base(file.Key))}
+	}
+	bucket := m.DestinationBucket[strings.LastIndex(m.DestinationBucket, ":")+1:]
+	if bucket == "" {
+		bucket = m.location.bucket
+	}
+	return inventoryLocation{bucket: bucket, path: file.Key}
+}
+
+// open reads location from the filesystem or from S3
+func (w *InventoryWalker) open(ctx context.Context, location inventoryLocation) (io.ReadCloser, error) {
+	if location.bucket == "" {
+		return os.Open(location.path)
+	}
+	// The request is only sent once read
+	return w.connection.client.GetObject(ctx, location.bucket, location.path, minio.GetObjectOptions{})
+}
+
+// latestManifest returns location when it is a manifest, or else the manifest of the latest report of the inventory
+// configuration folder location
+func (w *InventoryWalker) latestManifest(ctx context.Context, location inventoryLocation) (inventoryLocation, error) {
+	if path.Base(filepath.ToSlash(location.path)) == inventoryManifestName {
+		return location, nil
+	}
+
+	var reports []string
+	if location.bucket == "" {
+		entries, err := os.ReadDir(location.path)
+		if err != nil {
+			return location, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && inventoryReportFolder.MatchString(entry.Name()) {
+				reports = append(reports, entry.Name())
+			}
+		}
+	} else {
+		prefix := location.path + "/"
+		for object := range w.connection.client.ListObjects(ctx, location.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+			if object.Err != nil {
+				return location, object.Err
+			}
+			name := strings.TrimSuffix(strings.TrimPrefix(object.Key, prefix), "/")
+			if isCommonPrefix(object) && inventoryReportFolder.MatchString(name) {
+				reports = append(reports, name)
+			}
+		}
+	}
+	if len(reports) == 0 {
+		return location, fmt.Errorf("no inventory report found in %s", location)
+	}
+
+	// Dates sort as strings
+	sort.Strings(reports)
+	latest := reports[len(reports)-1]
+	if location.bucket == "" {
+		location.path = filepath.Join(location.path, latest, inventoryManifestName)
+	} else {
+		location.path = path.Join(location.path, latest, inventoryManifestName)
+	}
+	return location, nil
+}
+
+// readManifest reads the manifest at location and the columns of its data files
+func (w *InventoryWalker) readManifest(ctx context.Context, location inventoryLocation) (*inventoryManifest, error) {
+	reader, err := w.open(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &inventoryManifest{location: location, columns: map[string]int{}}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("invalid inventory manifest %s: %w", location, err)
+	}
+	// The columns of ORC and Parquet reports are named like last_modified_date, rather than LastModifiedDate
+	manifest.FileFormat = strings.ToUpper(manifest.FileFormat)
+	switch manifest.FileFormat {
+	case inventoryCSV:
+		for i, column := range strings.Split(manifest.FileSchema, ",") {
+			manifest.columns[strings.TrimSpace(column)] = i
+		}
+	case inventoryORC, inventoryParquet:
+		fields := orcSchemaField
+		if manifest.FileFormat == inventoryParquet {
+			fields = parquetSchemaField
+		}
+		for i, match := range fields.FindAllStringSubmatch(manifest.FileSchema, -1) {
+			manifest.columns[inventoryColumnName(match[1])] = i
+		}
+	default:
+		return nil, fmt.Errorf("inventory %s is in %s format: %w", location, manifest.FileFormat, errInventoryFormat)
+	}
+	if !manifest.has("Key") || !manifest.has("Size") {
+		return nil, fmt.Errorf("inventory %s has no Key or Size column", location)
+	}
+	return manifest, nil
+}
+
+// inventoryRow is an object version listed in an inventory data file
+type inventoryRow struct {
+	key            string
+	isLatest       bool
+	isDeleteMarker bool
+	size           int64
+	lastModified   time.Time
+	storageClass   string
+	metadata       stats.ObjectMetadata
+	lockMode       string
+	retainUntil    time.Time
+	legalHold      bool
+}
+
+// parseRow reads the fields of record laid out as the columns of manifest. Without IsLatest column, the
+// inventory only holds current versions.
+func (m *inventoryManifest) parseRow(record []string) (inventoryRow, error) {
+	field := func(column string) string {
+		if i, ok := m.columns[column]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	// Only the keys of CSV reports are URL encoded
+	key := field("Key")
+	var err error
+	if m.FileFormat == inventoryCSV {
+		if key, err = url.QueryUnescape(key); err != nil {
+			return inventoryRow{}, fmt.Errorf("invalid key %q: %w", field("Key"), err)
+		}
+	}
+	row := inventoryRow{
+		key:            key,
+		isLatest:       !m.has("IsLatest") || field("IsLatest") == "true",
+		isDeleteMarker: field("IsDeleteMarker") == "true",
+		storageClass:   field("StorageClass"),
+		lockMode:       field("ObjectLockMode"),
+		legalHold:      field("ObjectLockLegalHoldStatus") == "ON",
+	}
+	if size := field("Size"); size != "" {
+		// Delete markers have no size
+		if row.size, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return row, fmt.Errorf("invalid size of %s: %w", key, err)
+		}
+	}
+	if modified := field("LastModifiedDate"); modified != "" {
+		if row.lastModified, err = time.Parse(time.RFC3339, modified); err != nil {
+			return row, fmt.Errorf("invalid last modified date of %s: %w", key, err)
+		}
+	}
+	if row.lockMode != "" {
+		row.retainUntil, _ = time.Parse(time.RFC3339, field("ObjectLockRetainUntilDate"))
+	}
+
+	row.metadata = stats.ObjectMetadata{
+		Encryption:        field("EncryptionStatus"),
+		ReplicationStatus: field("ReplicationStatus"),
+	}
+	if encryption, ok := inventoryEncryptions[row.metadata.Encryption]; ok {
+		row.metadata.Encryption = encryption
+	}
+	if row.metadata.ReplicationStatus == "" {
+		row.metadata.ReplicationStatus = "none"
+	}
+	return row, nil
+}
@@ -0,0 +1,265 @@
+package walker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/willena/s3-exporter/stats"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const inventorySchema = "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size, LastModifiedDate, StorageClass, EncryptionStatus, ReplicationStatus, ObjectLockRetainUntilDate, ObjectLockMode, ObjectLockLegalHoldStatus"
+
+func newTestManifest(schema string) *inventoryManifest {
+	manifest := &inventoryManifest{FileFormat: "CSV", FileSchema: schema, columns: map[string]int{}}
+	for i, column := range strings.Split(schema, ",") {
+		manifest.columns[strings.TrimSpace(column)] = i
+	}
+	return manifest
+}
+
+func TestParseRow(t *testing.T) {
+	modified := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	retainUntil := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name     string
+		schema   string
+		record   []string
+		expected inventoryRow
+	}{
+		{
+			name:   "current version",
+			schema: inventorySchema,
+			record: []string{"src", "photos/summer+2021/a%2Bb.jpg", "v1", "true", "false", "42", "2021-06-01T12:00:00.000Z", "STANDARD_IA", "SSE-KMS", "COMPLETED", "", "", "OFF"},
+			expected: inventoryRow{key: "photos/summer 2021/a+b.jpg", isLatest: true, size: 42, lastModified: modified, storageClass: "STANDARD_IA",
+				metadata: stats.ObjectMetadata{Encryption: "aws:kms", ReplicationStatus: "COMPLETED"}},
+		},
+		{
+			name:   "locked noncurrent version",
+			schema: inventorySchema,
+			record: []string{"src", "a.txt", "v0", "false", "false", "1", "2021-06-01T12:00:00.000Z", "GLACIER", "SSE-S3", "", "2030-01-01T00:00:00.000Z", "COMPLIANCE", "ON"},
+			expected: inventoryRow{key: "a.txt", size: 1, lastModified: modified, storageClass: "GLACIER",
+				metadata: stats.ObjectMetadata{Encryption: "AES256", ReplicationStatus: "none"}, lockMode: "COMPLIANCE", retainUntil: retainUntil, legalHold: true},
+		},
+		{
+			name:   "delete marker",
+			schema: inventorySchema,
+			record: []string{"src", "a.txt", "v2", "true", "true", "", "2021-06-01T12:00:00.000Z", "", "", "", "", "", ""},
+			expected: inventoryRow{key: "a.txt", isLatest: true, isDeleteMarker: true, lastModified: modified,
+				metadata: stats.ObjectMetadata{Encryption: "none", ReplicationStatus: "none"}},
+		},
+		{
+			name:   "current versions only",
+			schema: "Bucket, Key, Size, EncryptionStatus",
+			record: []string{"src", "b.txt", "7", "UNKNOWN"},
+			expected: inventoryRow{key: "b.txt", isLatest: true, size: 7,
+				metadata: stats.ObjectMetadata{Encryption: "UNKNOWN", ReplicationStatus: "none"}},
+		},
+		{
+			name:     "short record",
+			schema:   inventorySchema,
+			record:   []string{"src", "c.txt"},
+			expected: inventoryRow{key: "c.txt", metadata: stats.ObjectMetadata{Encryption: "none", ReplicationStatus: "none"}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			row, err := newTestManifest(test.schema).parseRow(test.record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(row, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, row)
+			}
+		})
+	}
+}
+
+func TestParseRowErrors(t *testing.T) {
+	manifest := newTestManifest(inventorySchema)
+	for _, record := range [][]string{
+		{"src", "a%zz", "v1", "true", "false", "1", "", "", "", "", "", "", ""},
+		{"src", "a.txt", "v1", "true", "false", "large", "", "", "", "", "", "", ""},
+		{"src", "a.txt", "v1", "true", "false", "1", "yesterday", "", "", "", "", "", ""},
+	} {
+		if _, err := manifest.parseRow(record); err == nil {
+			t.Errorf("expected an error for %v", record)
+		}
+	}
+}
+
+// writeInventory writes the manifest of a report of an inventory configuration folder, and its data files; data
+// files with a .gz extension are compressed and have a checksum. It returns the configuration folder.
+func writeInventory(t *testing.T, format string, schema string, files map[string]string) string {
+	folder := t.TempDir()
+	report := filepath.Join(folder, "2021-06-01T00-00Z")
+	for _, dir := range []string{report, filepath.Join(folder, "data")} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest := inventoryManifest{SourceBucket: "src", DestinationBucket: "arn:aws:s3:::inventories", FileFormat: format, FileSchema: schema}
+	for name, rows := range files {
+		content := []byte(rows)
+		file := inventoryFile{Key: "src/config/data/" + name, Size: int64(len(content))}
+		if strings.HasSuffix(name, ".gz") {
+			var compressed bytes.Buffer
+			writer := gzip.NewWriter(&compressed)
+			_, _ = writer.Write(content)
+			_ = writer.Close()
+			content = compressed.Bytes()
+			sum := md5.Sum(content)
+			file.MD5Checksum = hex.EncodeToString(sum[:])
+		}
+		if err := ioutil.WriteFile(filepath.Join(folder, "data", name), content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	content, _ := json.Marshal(manifest)
+	if err := ioutil.WriteFile(filepath.Join(report, inventoryManifestName), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return folder
+}
+
+func TestReadManifest(t *testing.T) {
+	walker := &InventoryWalker{}
+	folder := writeInventory(t, "CSV", "Bucket, Key, Size", nil)
+	location, err := walker.latestManifest(context.Background(), inventoryLocation{path: folder})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := walker.readManifest(context.Background(), location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.columns["Key"] != 1 || manifest.columns["Size"] != 2 || manifest.has("IsLatest") {
+		t.Errorf("unexpected columns %v", manifest.columns)
+	}
+
+	location, _ = walker.latestManifest(context.Background(), inventoryLocation{path: writeInventory(t, "CSV", "Bucket, Key", nil)})
+	if _, err := walker.readManifest(context.Background(), location); err == nil || errors.Is(err, errInventoryFormat) {
+		t.Errorf("expected an error for the missing Size column, got %v", err)
+	}
+
+	// The columns of ORC and Parquet reports are read from their schema
+	for format, schema := range map[string]string{"ORC": orcSchema, "Parquet": parquetSchema} {
+		location, _ = walker.latestManifest(context.Background(), inventoryLocation{path: writeInventory(t, format, schema, nil)})
+		manifest, err := walker.readManifest(context.Background(), location)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		expected := map[string]int{"Bucket": 0, "Key": 1, "VersionId": 2, "IsLatest": 3, "IsDeleteMarker": 4, "Size": 5, "LastModifiedDate": 6, "StorageClass": 7}
+		if !reflect.DeepEqual(manifest.columns, expected) {
+			t.Errorf("%s: unexpected columns %v", format, manifest.columns)
+		}
+	}
+
+	// Reports in other formats are rejected at startup
+	config := testConfig(t, "inventory", "--inventory.manifest", writeInventory(t, "AVRO", "Bucket, Key, Size", nil))
+	if err := (&InventoryWalker{}).Init(config, map[string]string{"target": "test"}, nil); !errors.Is(err, errInventoryFormat) {
+		t.Errorf("expected the Avro report to be rejected, got %v", err)
+	}
+	// As are local reports that can not be read
+	for name, location := range map[string]string{
+		"missing folder": filepath.Join(t.TempDir(), "missing"),
+		"no report":      t.TempDir(),
+		"missing column": writeInventory(t, "CSV", "Bucket, Key", nil),
+	} {
+		config := testConfig(t, "inventory", "--inventory.manifest", location)
+		if err := (&InventoryWalker{}).Init(config, map[string]string{"target": "test"}, nil); err == nil {
+			t.Errorf("%s: expected the report to be rejected", name)
+		}
+	}
+}
+
+// TestInventoryVersions checks that the versions of keys are accounted together, when split across data files too
+func TestInventoryVersions(t *testing.T) {
+	now := time.Now().UTC()
+	date := func(age time.Duration) string {
+		return now.Add(-age).Format(time.RFC3339)
+	}
+	folder := writeInventory(t, "CSV", "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size, LastModifiedDate, StorageClass", map[string]string{
+		"1.csv.gz": fmt.Sprintf("\"src\",\"a.txt\",\"v2\",\"true\",\"false\",\"10\",\"%s\",\"STANDARD\"\n", date(12*time.Hour)) +
+			fmt.Sprintf("\"src\",\"b.txt\",\"v1\",\"false\",\"false\",\"20\",\"%s\",\"STANDARD\"\n", date(72*time.Hour)),
+		"2.csv": fmt.Sprintf("\"src\",\"a.txt\",\"v1\",\"false\",\"false\",\"5\",\"%s\",\"STANDARD\"\n", date(96*time.Hour)) +
+			fmt.Sprintf("\"src\",\"b.txt\",\"v2\",\"true\",\"true\",\"\",\"%s\",\"STANDARD\"\n", date(48*time.Hour)),
+		"3.csv": fmt.Sprintf("\"src\",\"c.txt\",\"v2\",\"true\",\"false\",\"7\",\"%s\",\"STANDARD\"\n", date(time.Hour)) +
+			fmt.Sprintf("\"src\",\"d.txt\",\"v2\",\"true\",\"false\",\"4\",\"%s\",\"STANDARD\"\n", date(time.Hour)) +
+			fmt.Sprintf("\"src\",\"d.txt\",\"v1\",\"false\",\"false\",\"3\",\"%s\",\"STANDARD\"\n", date(240*time.Hour)) +
+			fmt.Sprintf("\"src\",\"e.txt\",\"v1\",\"true\",\"false\",\"6\",\"%s\",\"STANDARD\"\n", date(time.Hour)),
+	})
+
+	for _, workers := range []string{"1", "2"} {
+		walker := &InventoryWalker{}
+		config := testConfig(t, "inventory", "--inventory.manifest", folder, "--inventory.workers", workers)
+		if err := walker.Init(config, map[string]string{"target": "test"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := walker.Walk(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		series := walker.Stats.(*stats.PrometheusStats).Snapshot().Series[stats.SeriesKey(map[string]string{"bucket": "src", "scope": "", "region": "", "storageClass": "STANDARD"})]
+		if series == nil || series.Objects != 4 || series.Size != 27 {
+			t.Fatalf("%s workers: expected the current versions of a.txt, c.txt, d.txt and e.txt, got %+v", workers, series)
+		}
+		versions := series.Versions["ROOT"]
+		if versions == nil {
+			t.Fatalf("%s workers: versions were not accounted", workers)
+		}
+		for state, expected := range map[string]stats.Usage{
+			stats.VersionCurrent:      {Objects: 4, Size: 27},
+			stats.VersionNoncurrent:   {Objects: 3, Size: 28},
+			stats.VersionDeleteMarker: {Objects: 1},
+		} {
+			if usage := versions.States[state]; usage == nil || *usage != expected {
+				t.Errorf("%s workers: expected %+v %s versions, got %+v", workers, expected, state, usage)
+			}
+		}
+		// Noncurrent versions became noncurrent when the next version of their key, read from the same file or from
+		// another one, was written
+		var recent uint64
+		for _, count := range versions.NoncurrentAgeHistogram.Counts[:2] {
+			recent += count
+		}
+		if recent != 3 {
+			t.Errorf("%s workers: expected 3 versions noncurrent for less than 7 days, got %v", workers, versions.NoncurrentAgeHistogram.Counts)
+		}
+	}
+}
+
+// TestInventoryUnsortedVersions checks that data files of reports of all versions whose keys are not sorted make
+// walks partial, the rows read until then being accounted
+func TestInventoryUnsortedVersions(t *testing.T) {
+	folder := writeInventory(t, "CSV", "Bucket, Key, VersionId, IsLatest, Size", map[string]string{
+		"1.csv": "src,a.txt,v1,true,1\nsrc,c.txt,v1,true,2\nsrc,b.txt,v1,true,4\n",
+	})
+	walker := &InventoryWalker{}
+	if err := walker.Init(testConfig(t, "inventory", "--inventory.manifest", folder, "--on-error", "partial"), map[string]string{"target": "test"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := walker.Walk(context.Background()); err != ErrPartialWalk {
+		t.Fatalf("expected a partial walk, got %v", err)
+	}
+	if errors := walker.Stats.(*stats.PrometheusStats).Status().BucketErrors; errors["src"] != 1 {
+		t.Errorf("expected 1 error of the src bucket, got %v", errors)
+	}
+	series := walker.Stats.(*stats.PrometheusStats).Snapshot().Series[stats.SeriesKey(map[string]string{"bucket": "src", "scope": "", "region": "", "storageClass": ""})]
+	if series == nil || series.Objects != 2 || series.Size != 3 {
+		t.Errorf("expected the objects read before b.txt, got %+v", series)
+	}
+}
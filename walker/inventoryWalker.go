@@ -0,0 +1,405 @@
+package walker
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/willena/s3-exporter/stats"
+	"github.com/willena/s3-exporter/utils"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// inventoryCheckTimeout bounds the reading of local manifests when the walker starts
+const inventoryCheckTimeout = 30 * time.Second
+
+type InventoryWalkerConfig struct {
+	InventoryConfiguration `group:"Inventory Configuration" namespace:"inventory" env-namespace:"INVENTORY"`
+}
+
+type InventoryConfiguration struct {
+	Manifests []string `long:"manifest" description:"S3 inventory manifest.json, or folder of an inventory configuration to read its latest report, as a local path or s3://bucket/key" required:"false" env:"MANIFESTS" env-delim:","`
+	Workers   int      `long:"workers" description:"Number of inventory data files read in parallel" required:"false" env:"WORKERS" default:"1"`
+
+	S3Connection `group:"Inventory S3 Connection" namespace:"s3" env-namespace:"S3"`
+}
+
+func init() {
+	Register(Registration{
+		Name:        "inventory",
+		Description: "S3 inventory walker configuration",
+		NewConfig:   func() interface{} { return &InventoryWalkerConfig{} },
+		New:         func() Walker { return &InventoryWalker{} },
+	})
+}
+
+// InventoryWalker reads S3 inventory reports instead of listing buckets; objects are accounted as by the S3 walker
+type InventoryWalker struct {
+	baseWalker
+	config    *InventoryWalkerConfig
+	locations []inventoryLocation
+	// connection reads the reports stored in S3; nil when all of them are local
+	connection *s3Connection
+}
+
+func (w *InventoryWalker) Init(config Config, labels map[string]string, _ []string) error {
+	err := w.ValidateConfig(config)
+	if err != nil {
+		return err
+	}
+	w.config = config.Options.(*InventoryWalkerConfig)
+	w.locations, _ = parseInventoryLocations(w.config.Manifests)
+
+	err = w.baseWalker.Init(config,
+		utils.MergeMapsRight(map[string]string{"type": "inventoryWalker"}, labels),
+		[]string{"bucket", "scope", "region", "storageClass"})
+	if err != nil || !inventoryInS3(w.locations) {
+		return err
+	}
+	w.connection, err = newS3Connection(&w.config.S3Connection, w.Stats)
+	return err
+}
+
+func (w *InventoryWalker) ValidateConfig(config Config) error {
+	inventoryConfig, ok := config.Options.(*InventoryWalkerConfig)
+	if !ok {
+		return fmt.Errorf("inventory walker options are missing")
+	}
+	if inventoryConfig.Workers < 1 {
+		return fmt.Errorf("the number of inventory workers must be at least 1")
+	}
+	locations, err := parseInventoryLocations(inventoryConfig.Manifests)
+	if err != nil {
+		return err
+	}
+	if len(locations) == 0 {
+		return fmt.Errorf("at least one inventory manifest is needed when using inventory mode")
+	}
+	for _, location := range locations {
+		if err := w.checkManifest(location); err != nil {
+			return err
+		}
+	}
+	if inventoryInS3(locations) {
+		return validateConnection(&inventoryConfig.S3Connection)
+	}
+	return nil
+}
+
+func parseInventoryLocations(values []string) ([]inventoryLocation, error) {
+	locations := make([]inventoryLocation, 0, len(values))
+	for _, value := range values {
+		location, err := parseInventoryLocation(value)
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, location)
+	}
+	return locations, nil
+}
+
+// checkManifest reads the manifest of a local report, so that reports that can not be read are rejected at startup.
+// Reports read from S3 are only read when walked.
+func (w *InventoryWalker) checkManifest(location inventoryLocation) error {
+	if location.bucket != "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), inventoryCheckTimeout)
+	defer cancel()
+	location, err := w.latestManifest(ctx, location)
+	if err != nil {
+		return err
+	}
+	_, err = w.readManifest(ctx, location)
+	return err
+}
+
+// inventoryInS3 tells whether one of locations is read from S3
+func inventoryInS3(locations []inventoryLocation) bool {
+	for _, location := range locations {
+		if location.bucket != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *InventoryWalker) Walk(ctx context.Context) error {
+	return w.runWalk(ctx, w.walkInventories)
+}
+
+// inventoryVersions gathers the versions of the keys of a report of all versions that may be split across data
+// files. Data files list keys in order, so the versions of a key are accounted as soon as the next key is read; only
+// the versions of the first and last keys of each file, which may continue in other files, are kept until all the
+// data files were read.
+type inventoryVersions struct {
+	manifest *inventoryManifest
+	mutex    sync.Mutex
+	// boundaries holds the versions of the first and last keys of the data files read
+	boundaries map[string][]inventoryRow
+}
+
+// add keeps versions, the versions of a key at the boundary of a data file
+func (v *inventoryVersions) add(versions []inventoryRow) {
+	if len(versions) == 0 {
+		return
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	key := versions[0].key
+	v.boundaries[key] = append(v.boundaries[key], versions...)
+}
+
+// walkInventories reads the manifests, then their data files in parallel. A manifest or a data file that can not
+// be read does not stop the walk of the others, which is then partial. The versions of the keys at the boundaries of
+// the data files of reports of all versions are accounted once all the data files were read.
+func (w *InventoryWalker) walkInventories(ctx context.Context) error {
+	pool := utils.NewTaskPool(ctx, w.config.Workers)
+	var versioned []*inventoryVersions
+	for _, location := range w.locations {
+		manifest, err := w.openInventory(ctx, location)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Warningf("Could not read inventory %s: %s", location, err.Error())
+			w.recordError(err)
+			continue
+		}
+
+		var versions *inventoryVersions
+		if manifest.has("IsLatest") {
+			versions = &inventoryVersions{manifest: manifest, boundaries: map[string][]inventoryRow{}}
+			versioned = append(versioned, versions)
+		}
+		for i := range manifest.Files {
+			file := manifest.Files[i]
+			pool.Submit(func(ctx context.Context) {
+				if err := w.readDataFile(ctx, manifest, file, versions); err != nil && ctx.Err() == nil {
+					log.Warningf("Could not read inventory file %s of bucket %s: %s", manifest.dataLocation(file), manifest.SourceBucket, err.Error())
+					w.Stats.RecordBucketError(manifest.SourceBucket, classifyError(err))
+				}
+			})
+		}
+	}
+	pool.Wait()
+
+	for _, versions := range versioned {
+		for _, rows := range versions.boundaries {
+			if err := w.processVersions(ctx, versions.manifest, rows); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// openInventory reads the manifest of location, or of the latest report of the configuration folder location
+func (w *InventoryWalker) openInventory(ctx context.Context, location inventoryLocation) (*inventoryManifest, error) {
+	location, err := w.latestManifest(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Reading inventory manifest %s", location)
+	return w.readManifest(ctx, location)
+}
+
+// readDataFile accounts the objects of a data file of manifest, keeping the versions of its boundary keys in versions
+// for reports of all versions. Its checksum is verified once read: a corrupted file is reported as an error, its objects remaining
+// accounted.
+func (w *InventoryWalker) readDataFile(ctx context.Context, manifest *inventoryManifest, file inventoryFile, versions *inventoryVersions) error {
+	if manifest.FileFormat != inventoryCSV {
+		return w.readColumnarFile(ctx, manifest, file, versions)
+	}
+	reader, err := w.open(ctx, manifest.dataLocation(file))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	checksum := md5.New()
+	content := io.TeeReader(reader, checksum)
+	var data io.Reader = content
+	if strings.HasSuffix(file.Key, ".gz") {
+		uncompressed, err := gzip.NewReader(content)
+		if err != nil {
+			return err
+		}
+		defer uncompressed.Close()
+		data = uncompressed
+	}
+
+	records := csv.NewReader(data)
+	records.FieldsPerRecord = -1
+	records.ReuseRecord = true
+	if err := w.readRows(ctx, manifest, records, versions); err != nil {
+		return err
+	}
+	return verifyChecksum(content, checksum, file.MD5Checksum)
+}
+
+// readColumnarFile reads an ORC or Parquet data file like readDataFile does. Their metadata being at their end, the
+// data files read from S3 are first downloaded to a temporary file.
+func (w *InventoryWalker) readColumnarFile(ctx context.Context, manifest *inventoryManifest, file inventoryFile, versions *inventoryVersions) error {
+	location := manifest.dataLocation(file)
+	var data *os.File
+	if location.bucket == "" {
+		local, err := os.Open(location.path)
+		if err != nil {
+			return err
+		}
+		defer local.Close()
+		data = local
+	} else {
+		object, err := w.open(ctx, location)
+		if err != nil {
+			return err
+		}
+		downloaded, err := download(object)
+		object.Close()
+		if err != nil {
+			return err
+		}
+		defer downloaded.Close()
+		data = downloaded.File
+	}
+	info, err := data.Stat()
+	if err != nil {
+		return err
+	}
+
+	read := readOrc
+	if manifest.FileFormat == inventoryParquet {
+		read = readParquet
+	}
+	records, err := read(manifest, data, info.Size())
+	if err != nil {
+		return err
+	}
+	if err := w.readRows(ctx, manifest, records, versions); err != nil {
+		return err
+	}
+	checksum := md5.New()
+	return verifyChecksum(io.TeeReader(io.NewSectionReader(data, 0, info.Size()), checksum), checksum, file.MD5Checksum)
+}
+
+// readRows accounts the rows of a data file, holding the current versions of objects. With versions, the rows are
+// grouped per key and the versions of a key are accounted once the next key is read, except for the first and last
+// keys of the file, which are kept in versions. Keys must then be sorted, as in the data files written by S3.
+func (w *InventoryWalker) readRows(ctx context.Context, manifest *inventoryManifest, reader inventoryRecords, versions *inventoryVersions) error {
+	// group holds the versions of the key being read
+	var group []inventoryRow
+	first := true
+	if versions != nil {
+		// Rows read before an error are accounted too
+		defer func() { versions.add(group) }()
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		row, err := manifest.parseRow(record)
+		if err != nil {
+			return err
+		}
+
+		if versions == nil {
+			if err := w.processVersions(ctx, manifest, []inventoryRow{row}); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(group) > 0 && row.key != group[0].key {
+			if row.key < group[0].key {
+				return fmt.Errorf("keys are not sorted: %s is listed after %s", row.key, group[0].key)
+			}
+			if first {
+				versions.add(group)
+				first = false
+			} else if err := w.processVersions(ctx, manifest, group); err != nil {
+				return err
+			}
+			group = nil
+		}
+		group = append(group, row)
+	}
+	return nil
+}
+
+// processVersions accounts the versions of a key as the S3 walker does: the current version is accounted as an
+// object with its metadata and, in versioned inventories, each version is accounted along with its retention
+func (w *InventoryWalker) processVersions(ctx context.Context, manifest *inventoryManifest, versions []inventoryRow) error {
+	// Newest first
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].isLatest != versions[j].isLatest {
+			return versions[i].isLatest
+		}
+		return versions[i].lastModified.After(versions[j].lastModified)
+	})
+
+	depth := w.baseWalker.config.Depth
+	withVersions := manifest.has("IsLatest")
+	withMetadata := manifest.has("EncryptionStatus", "ReplicationStatus")
+	withRetention := manifest.has("ObjectLockMode", "ObjectLockLegalHoldStatus")
+	for i, version := range versions {
+		labels := map[string]string{"bucket": manifest.SourceBucket, "scope": "", "region": "", "storageClass": version.storageClass}
+
+		var replaced time.Time
+		state := stats.VersionNoncurrent
+		switch {
+		case version.isDeleteMarker:
+			state = stats.VersionDeleteMarker
+		case version.isLatest:
+			state = stats.VersionCurrent
+		case i > 0:
+			replaced = versions[i-1].lastModified
+		}
+
+		if withVersions {
+			if err := w.processVersionTo(ctx, w.Stats, "", version.key, state, version.size, replaced, depth, labels); err != nil {
+				return err
+			}
+		}
+		if state == stats.VersionCurrent {
+			if err := w.processFileTo(ctx, w.Stats, "", version.key, version.size, depth, "", version.lastModified, labels); err != nil {
+				return err
+			}
+			if withMetadata {
+				if err := w.processMetadataTo(ctx, w.Stats, "", version.key, version.size, version.metadata, depth, labels); err != nil {
+					return err
+				}
+			}
+		}
+		if withRetention && state != stats.VersionDeleteMarker {
+			if err := w.processRetentionTo(ctx, w.Stats, "", version.key, version.size, version.lockMode, version.retainUntil, version.legalHold, depth, labels); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// verifyChecksum reads what is left of content, then compares the MD5 checksum of content to expected, if any
+func verifyChecksum(content io.Reader, checksum hash.Hash, expected string) error {
+	if _, err := io.Copy(ioutil.Discard, content); err != nil {
+		return err
+	}
+	if expected != "" && !strings.EqualFold(hex.EncodeToString(checksum.Sum(nil)), expected) {
+		return fmt.Errorf("checksum mismatch: the file is corrupted or was modified")
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package walker
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	log "github.com/sirupsen/logrus"
+	"github.com/willena/s3-exporter/stats"
+	"github.com/willena/s3-exporter/utils"
+	"net/url"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type MinioUsageWalkerConfig struct {
+	MinioUsageConfiguration `group:"MinIO Usage Configuration" namespace:"minio" env-namespace:"MINIO"`
+}
+
+type MinioUsageConfiguration struct {
+	S3Connection
+
+	BucketFilters      []string      `long:"bucket-filter" description:"Exclude buckets based on name" required:"false" env:"BUCKET_FILTER"`
+	CrossCheckInterval time.Duration `long:"cross-check-interval" description:"Minimum delay between listings of all the buckets cross-checking the usage reported by MinIO. 0 disables cross-checks" required:"false" env:"CROSS_CHECK_INTERVAL" default:"0"`
+}
+
+func init() {
+	Register(Registration{
+		Name:        "minio",
+		Description: "MinIO usage walker configuration",
+		NewConfig:   func() interface{} { return &MinioUsageWalkerConfig{} },
+		New:         func() Walker { return &MinioUsageWalker{} },
+	})
+}
+
+// minioUsageSizeBuckets are the upper bounds of the object size ranges of MinIO usage histograms
+var minioUsageSizeBuckets = []float64{1 << 10, 64 << 10, 256 << 10, 512 << 10, 1 << 20, 10 << 20, 64 << 20, 128 << 20, 512 << 20}
+
+// minioUsageSizeRanges maps the size ranges of MinIO usage histograms to their upper bound in minioUsageSizeBuckets.
+// Objects above 512 MiB are only part of the histogram count.
+var minioUsageSizeRanges = map[string]int{
+	"LESS_THAN_1024_B":          0,
+	"BETWEEN_1024_B_AND_64_KB":  1,
+	"BETWEEN_64_KB_AND_256_KB":  2,
+	"BETWEEN_256_KB_AND_512_KB": 3,
+	"BETWEEN_512_KB_AND_1_MB":   4,
+	"BETWEEN_1_MB_AND_10_MB":    5,
+	"BETWEEN_10_MB_AND_64_MB":   6,
+	"BETWEEN_64_MB_AND_128_MB":  7,
+	"BETWEEN_128_MB_AND_512_MB": 8,
+}
+
+// minioUsageCoarseRange sums the ranges from 1 KiB to 1 MiB; it is only used when the finer ranges are missing
+const minioUsageCoarseRange = "BETWEEN_1024B_AND_1_MB"
+
+// minioDataUsage is the part of the MinIO admin DataUsageInfo response used by the walker
+type minioDataUsage struct {
+	LastUpdate   time.Time                   `json:"lastUpdate"`
+	BucketsUsage map[string]minioBucketUsage `json:"bucketsUsageInfo"`
+}
+
+type minioBucketUsage struct {
+	Size                 uint64            `json:"size"`
+	ObjectsCount         uint64            `json:"objectsCount"`
+	ObjectSizesHistogram map[string]uint64 `json:"objectsSizesHistogram"`
+}
+
+// MinioUsageWalker exports the data usage MinIO maintains with its scanner, read with the admin API, instead of
+// listing buckets. Listings may cross-check it from time to time, in the background of walks.
+type MinioUsageWalker struct {
+	baseWalker
+	*s3Connection
+	config         *MinioUsageWalkerConfig
+	bucketPatterns []*regexp.Regexp
+	crossChecking  int32
+	// crossChecks holds the last cross-check of each bucket, published with every walk until the next one
+	crossChecks    map[string]stats.CrossCheck
+	lastCrossCheck time.Time
+	crossCheckLock sync.Mutex
+}
+
+func (m *MinioUsageWalker) Init(config Config, labels map[string]string, _ []string) error {
+	err := m.ValidateConfig(config)
+	if err != nil {
+		return err
+	}
+	m.config = config.Options.(*MinioUsageWalkerConfig)
+	m.bucketPatterns, err = utils.BuildPatternsFromStrings(m.config.BucketFilters)
+	if err != nil {
+		return err
+	}
+	m.crossChecks = map[string]stats.CrossCheck{}
+
+	// Sizes are only known per range of the MinIO histograms
+	m.sizeBuckets = minioUsageSizeBuckets
+	err = m.baseWalker.Init(config,
+		utils.MergeMapsRight(map[string]string{
+			"type":       "minioUsageWalker",
+			"s3Endpoint": m.config.Endpoint,
+		}, labels), []string{"bucket", "scope", "region", "storageClass"})
+	if err != nil {
+		return err
+	}
+	m.s3Connection, err = newS3Connection(&m.config.S3Connection, m.Stats)
+	return err
+}
+
+func (m *MinioUsageWalker) ValidateConfig(config Config) error {
+	minioConfig, ok := config.Options.(*MinioUsageWalkerConfig)
+	if !ok {
+		return fmt.Errorf("MinIO usage walker options are missing")
+	}
+	if minioConfig.Endpoint == "" {
+		return fmt.Errorf("the MinIO endpoint is needed when using MinIO mode")
+	}
+	if minioConfig.Signature != signatureV4 {
+		return fmt.Errorf("admin requests can only be signed with signature V4")
+	}
+	if minioConfig.CrossCheckInterval < 0 {
+		return fmt.Errorf("cross-check interval can not be negative")
+	}
+	return validateConnection(&minioConfig.S3Connection)
+}
+
+// Walk reads the data usage; cross-checks started by walks are bound to ctx rather than to the walk, so that the
+// maximum walk duration only applies to the reading of the usage
+func (m *MinioUsageWalker) Walk(ctx context.Context) error {
+	return m.runWalk(ctx, func(walkCtx context.Context) error {
+		return m.walkUsage(walkCtx, ctx)
+	})
+}
+
+// walkUsage accounts the usage of every bucket not excluded as a single prefix, and publishes the last cross-checks.
+// When due, it starts a cross-check bound to crossCheckCtx, published by the following walks.
+func (m *MinioUsageWalker) walkUsage(ctx context.Context, crossCheckCtx context.Context) error {
+	var usage minioDataUsage
+	if err := m.adminRequest(ctx, "DataUsageInfo", "/minio/admin/v3/datausageinfo", url.Values{}, &usage); err != nil {
+		log.Errorf("Could not read MinIO data usage: %s", err)
+		return err
+	}
+	if usage.LastUpdate.IsZero() {
+		return fmt.Errorf("MinIO did not compute the data usage yet")
+	}
+	m.Stats.RecordUsageDate(usage.LastUpdate)
+
+	buckets := make([]string, 0, len(usage.BucketsUsage))
+	for bucket := range usage.BucketsUsage {
+		if utils.MatchExclude(m.bucketPatterns, bucket) {
+			log.Infof("Bucket %s excluded !", bucket)
+			continue
+		}
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	for _, bucket := range buckets {
+		bucketUsage := usage.BucketsUsage[bucket]
+		m.Stats.ProcessUsage("ROOT", bucketUsage.ObjectsCount, bucketUsage.Size, minioSizeHistogram(bucketUsage), m.labels(bucket))
+	}
+
+	m.crossCheckLock.Lock()
+	defer m.crossCheckLock.Unlock()
+	for bucket, check := range m.crossChecks {
+		m.Stats.RecordCrossCheck(bucket, check)
+	}
+	if m.config.CrossCheckInterval > 0 && time.Since(m.lastCrossCheck) >= m.config.CrossCheckInterval &&
+		atomic.CompareAndSwapInt32(&m.crossChecking, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&m.crossChecking, 0)
+			m.crossCheck(crossCheckCtx, buckets, usage)
+		}()
+	}
+	return nil
+}
+
+// labels returns the labels of the series of bucket; MinIO usage does not tell storage classes apart
+func (m *MinioUsageWalker) labels(bucket string) map[string]string {
+	return map[string]string{"bucket": bucket, "scope": "", "region": m.config.Region, "storageClass": ""}
+}
+
+// minioSizeHistogram converts the size histogram of a MinIO bucket usage to the size buckets of the walker
+func minioSizeHistogram(usage minioBucketUsage) *stats.Histogram {
+	histogram := stats.NewHistogram(minioUsageSizeBuckets)
+	histogram.Count = usage.ObjectsCount
+	histogram.Sum = float64(usage.Size)
+
+	fine := false
+	for sizeRange, count := range usage.ObjectSizesHistogram {
+		if i, ok := minioUsageSizeRanges[sizeRange]; ok {
+			histogram.Counts[i] += count
+			fine = fine || (i > 0 && i < 5)
+		}
+	}
+	if !fine {
+		// Older MinIO versions only report the coarse range, counted at its upper bound
+		histogram.Counts[4] += usage.ObjectSizesHistogram[minioUsageCoarseRange]
+	}
+	return histogram
+}
+
+// crossCheck lists the current objects of buckets and compares them to their usage. The usage is only updated by
+// the MinIO scanner, so objects written since its last update make small differences. Buckets that can not be listed
+// keep their previous cross-check; their errors are enrichment errors, so they do not affect walks.
+func (m *MinioUsageWalker) crossCheck(ctx context.Context, buckets []string, usage minioDataUsage) {
+	crossChecks := make(map[string]stats.CrossCheck, len(buckets))
+	for _, bucket := range buckets {
+		check, err := m.listBucket(ctx, bucket)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Warningf("Could not cross-check bucket %s: %s", bucket, err.Error())
+			m.recordEnrichmentError(FeatureCrossCheck, err)
+			m.crossCheckLock.Lock()
+			previous, ok := m.crossChecks[bucket]
+			m.crossCheckLock.Unlock()
+			if ok {
+				crossChecks[bucket] = previous
+			}
+			continue
+		}
+
+		reported := usage.BucketsUsage[bucket]
+		if check.Objects != reported.ObjectsCount || check.Size != reported.Size {
+			log.Warningf("Usage of bucket %s reported by MinIO on %s (%d objects, %d bytes) differs from its listing (%d objects, %d bytes)",
+				bucket, usage.LastUpdate.Format(time.RFC3339), reported.ObjectsCount, reported.Size, check.Objects, check.Size)
+			check.Mismatch = true
+		}
+		crossChecks[bucket] = check
+	}
+
+	m.crossCheckLock.Lock()
+	defer m.crossCheckLock.Unlock()
+	m.crossChecks = crossChecks
+	m.lastCrossCheck = time.Now()
+}
+
+// listBucket counts the current objects of bucket
+func (m *MinioUsageWalker) listBucket(ctx context.Context, bucket string) (stats.CrossCheck, error) {
+	check := stats.CrossCheck{Date: time.Now()}
+	for object := range m.client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Recursive: true, MaxKeys: m.config.PageSize}) {
+		if object.Err != nil {
+			return check, object.Err
+		}
+		check.Objects++
+		check.Size += uint64(object.Size)
+	}
+	return check, nil
+}
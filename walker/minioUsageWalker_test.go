@@ -0,0 +1,158 @@
+package walker
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/willena/s3-exporter/stats"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeMinio serves the data usage of testdata/minio-datausageinfo.json, and the listings of buckets
+func newFakeMinio(t *testing.T, buckets map[string]map[string]int64) string {
+	usage, err := ioutil.ReadFile("testdata/minio-datausageinfo.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeS3{buckets: buckets}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/minio/admin/v3/datausageinfo" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write(usage)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func newMinioUsageWalker(t *testing.T, endpoint string, args ...string) *MinioUsageWalker {
+	config := testConfig(t, "minio", append([]string{
+		"--minio.endpoint", endpoint, "--minio.access-key", "access", "--minio.secret-key", "secret",
+		"--minio.bucket-path-style", "--minio.region", "us-east-1", "--minio.max-attempts", "1", "--minio.bucket-filter", "^tmp-",
+	}, args...)...)
+	walker := &MinioUsageWalker{}
+	if err := walker.Init(config, map[string]string{"target": "test"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	return walker
+}
+
+func minioSeries(snapshot *stats.Snapshot, bucket string) *stats.Series {
+	return snapshot.Series[stats.SeriesKey(map[string]string{"bucket": bucket, "scope": "", "region": "us-east-1", "storageClass": ""})]
+}
+
+func TestMinioUsage(t *testing.T) {
+	walker := newMinioUsageWalker(t, newFakeMinio(t, nil))
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := walker.Stats.(*stats.PrometheusStats).Snapshot()
+
+	if !snapshot.UsageDate.Equal(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected usage date %s", snapshot.UsageDate)
+	}
+	if len(snapshot.Series) != 3 || minioSeries(snapshot, "tmp-uploads") != nil {
+		t.Errorf("expected 3 buckets, the excluded one apart, got %d series", len(snapshot.Series))
+	}
+	for _, test := range []struct {
+		bucket  string
+		objects uint64
+		size    uint64
+		counts  []uint64
+	}{
+		{"photos", 3, 3146752, []uint64{1, 1, 0, 0, 0, 1, 0, 0, 0}},
+		// Only the coarse range is reported, counted at its upper bound
+		{"archive", 2, 600000, []uint64{0, 0, 0, 0, 2, 0, 0, 0, 0}},
+		{"logs", 1, 10, []uint64{1, 0, 0, 0, 0, 0, 0, 0, 0}},
+	} {
+		series := minioSeries(snapshot, test.bucket)
+		if series == nil || series.Objects != test.objects || series.Size != test.size {
+			t.Errorf("%s: expected %d objects of %d bytes, got %+v", test.bucket, test.objects, test.size, series)
+			continue
+		}
+		prefix := series.Prefixes["ROOT"]
+		if prefix == nil || prefix.SizeHistogram == nil {
+			t.Errorf("%s: the usage was not accounted under the ROOT prefix", test.bucket)
+			continue
+		}
+		if histogram := prefix.SizeHistogram; !reflect.DeepEqual(histogram.Counts, test.counts) || histogram.Count != test.objects || histogram.Sum != float64(test.size) {
+			t.Errorf("%s: unexpected size histogram %+v", test.bucket, histogram)
+		}
+	}
+}
+
+// TestMinioCrossCheck checks that cross-checks run in the background of walks, are published by the next walks, and
+// flag the buckets whose listing differs from their usage without making walks partial
+func TestMinioCrossCheck(t *testing.T) {
+	walker := newMinioUsageWalker(t, newFakeMinio(t, map[string]map[string]int64{
+		"photos":  {"a.jpg": 100, "b.jpg": 2048, "c.jpg": 3144604},
+		"archive": {"2019.tar": 300000, "2020.tar": 300000, "2021.tar": 1},
+	}), "--minio.cross-check-interval", "1h")
+
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&walker.crossChecking) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the cross-check did not complete")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := walker.Stats.(*stats.PrometheusStats).Snapshot()
+	if snapshot.Partial || len(snapshot.Errors) != 0 {
+		t.Errorf("cross-check errors made the walk partial: %v", snapshot.Errors)
+	}
+	photos, archive := snapshot.CrossChecks["photos"], snapshot.CrossChecks["archive"]
+	if photos == nil || photos.Objects != 3 || photos.Size != 3146752 || photos.Mismatch {
+		t.Errorf("unexpected photos cross-check %+v", photos)
+	}
+	if archive == nil || archive.Objects != 3 || archive.Size != 600001 || !archive.Mismatch {
+		t.Errorf("unexpected archive cross-check %+v", archive)
+	}
+	mismatches := map[string]float64{}
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(walker.Collector())
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != stats.METRICS_GROUP+"_cross_check_mismatch" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "bucket" {
+					mismatches[label.GetValue()] = metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	if !reflect.DeepEqual(mismatches, map[string]float64{"photos": 0, "archive": 1}) {
+		t.Errorf("unexpected cross-check mismatch gauges %v", mismatches)
+	}
+
+	// The logs bucket can not be listed
+	if check, ok := snapshot.CrossChecks["logs"]; ok {
+		t.Errorf("unexpected logs cross-check %+v", check)
+	}
+	var errors uint64
+	for _, count := range walker.Stats.(*stats.PrometheusStats).Status().EnrichmentErrors[FeatureCrossCheck] {
+		errors += count
+	}
+	if errors != 1 {
+		t.Errorf("expected 1 cross-check error, got %d", errors)
+	}
+}
@@ -0,0 +1,84 @@
+package walker
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registration describes a walker implementation selectable with --type
+type Registration struct {
+	// Name is the --type value selecting the walker
+	Name string
+	// Description is used as title of the walker options group in the CLI help
+	Description string
+	// Namespace and EnvNamespace are prepended to the walker options, below the walker namespace.
+	// Leave empty to expose options as --walker.<option> / WALKER_<OPTION>
+	Namespace    string
+	EnvNamespace string
+	// NewConfig returns a pointer to a new options struct; options are declared with go-flags tags.
+	// Options must not be marked as required since every registered walker is exposed in the CLI.
+	NewConfig func() interface{}
+	// New returns a new walker, to be initialised with Init
+	New func() Walker
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[string]Registration{}
+)
+
+// Register makes a walker available by name. It is intended to be called from init functions
+// and panics if the name is already taken or the registration is incomplete.
+func Register(registration Registration) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if registration.Name == "" || registration.New == nil || registration.NewConfig == nil {
+		panic("walker: incomplete registration")
+	}
+	if _, exists := registry[registration.Name]; exists {
+		panic("walker: Register called twice for walker " + registration.Name)
+	}
+	registry[registration.Name] = registration
+}
+
+// Lookup returns the registration of the walker named name
+func Lookup(name string) (Registration, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	registration, ok := registry[name]
+	if !ok {
+		return Registration{}, fmt.Errorf("unknown walker type %q (available: %v)", name, names())
+	}
+	return registration, nil
+}
+
+// Registrations returns all the registered walkers sorted by name
+func Registrations() []Registration {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	var registrations []Registration
+	for _, name := range names() {
+		registrations = append(registrations, registry[name])
+	}
+	return registrations
+}
+
+// Names returns the sorted names of registered walkers
+func Names() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return names()
+}
+
+func names() []string {
+	var list []string
+	for name := range registry {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
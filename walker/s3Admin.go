@@ -0,0 +1,71 @@
+package walker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/signer"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// emptyPayloadHash is the SHA-256 of the empty body of GET requests
+var emptyPayloadHash = sha256.Sum256(nil)
+
+// adminRequest sends a GET request for operation to path on the endpoint, signed with signature V4 like S3 requests,
+// and decodes its JSON response into result. Error responses are returned as minio.ErrorResponse, so that they are
+// classified like S3 errors.
+func (c *s3Connection) adminRequest(ctx context.Context, operation string, path string, query url.Values, result interface{}) error {
+	endpoint, err := url.ParseRequestURI(c.config.Endpoint)
+	if err != nil {
+		return fmt.Errorf("could not read S3 url: %w", err)
+	}
+	endpoint.Path = path
+	endpoint.RawQuery = query.Encode()
+
+	creds, err := c.creds.Get()
+	if err != nil {
+		return err
+	}
+	if creds.SignerType.IsAnonymous() {
+		return fmt.Errorf("admin requests need credentials")
+	}
+
+	request, err := http.NewRequest(http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(withOperation(ctx, operation))
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(emptyPayloadHash[:]))
+	request = signer.SignV4(*request, creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken, c.config.Region)
+
+	response, err := (&http.Client{Transport: c.transport}).Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		// MinIO and Ceph RGW both answer errors with a JSON Code and Message
+		errorResponse := minio.ErrorResponse{StatusCode: response.StatusCode}
+		if json.Unmarshal(body, &errorResponse) != nil || errorResponse.Code == "" {
+			errorResponse.Message = response.Status
+			if response.StatusCode == http.StatusForbidden {
+				errorResponse.Code = "AccessDenied"
+			}
+		}
+		return errorResponse
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("invalid %s response: %w", operation, err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package walker
+
+import (
+	"context"
+	"github.com/minio/minio-go/v7"
+	log "github.com/sirupsen/logrus"
+	"github.com/willena/s3-exporter/stats"
+)
+
+// notConfiguredCodes are the error codes returned when a bucket setting is not configured
+var notConfiguredCodes = map[string]bool{
+	"NoSuchLifecycleConfiguration":                   true,
+	"ServerSideEncryptionConfigurationNotFoundError": true,
+	"ObjectLockConfigurationNotFoundError":           true,
+	"NoSuchBucketPolicy":                             true,
+	"ReplicationConfigurationNotFoundError":          true,
+}
+
+// bucketConfig reads the configuration of bucket. Settings that could not be read are unknown and
+// accounted as bucket configuration errors, unless the backend does not implement them.
+func (s *S3Walker) bucketConfig(ctx context.Context, bucket *s3Bucket) stats.BucketConfig {
+	config := stats.BucketConfig{
+		CreationDate:   bucket.CreationDate,
+		Region:         bucket.region,
+		Versioning:     stats.BucketUnknown,
+		MFADelete:      stats.BucketUnknown,
+		ObjectLock:     stats.BucketUnknown,
+		ObjectLockMode: stats.BucketUnknown,
+		Encryption:     stats.BucketUnknown,
+	}
+
+	// read returns whether setting was read, either configured or not; failures mark it unknown
+	read := func(setting string, err error) bool {
+		if err == nil {
+			return true
+		}
+		code := minio.ToErrorResponse(err).Code
+		if notConfiguredCodes[code] {
+			return true
+		}
+		config.Unknown = append(config.Unknown, setting)
+		if code != "NotImplemented" && ctx.Err() == nil {
+			log.Warningf("Could not read %s configuration of bucket %s: %s", setting, bucket.Name, err.Error())
+			s.recordEnrichmentError(FeatureBucketConfig, err)
+		}
+		return false
+	}
+
+	versioning, err := bucket.client.GetBucketVersioning(ctx, bucket.Name)
+	if read(stats.BucketSettingVersioning, err) {
+		config.Versioning = versioning.Status
+		if config.Versioning == "" {
+			config.Versioning = "Disabled"
+		}
+		config.MFADelete = versioning.MFADelete
+		if config.MFADelete == "" {
+			config.MFADelete = "Disabled"
+		}
+	}
+
+	objectLock, mode, _, _, err := bucket.client.GetObjectLockConfig(ctx, bucket.Name)
+	if read(stats.BucketSettingObjectLock, err) {
+		config.ObjectLock = objectLock
+		if config.ObjectLock == "" {
+			config.ObjectLock = "Disabled"
+		}
+		config.ObjectLockMode = ""
+		if mode != nil {
+			config.ObjectLockMode = mode.String()
+		}
+	}
+
+	encryption, err := bucket.client.GetBucketEncryption(ctx, bucket.Name)
+	if read(stats.BucketSettingEncryption, err) {
+		config.Encryption = "none"
+		if encryption != nil && len(encryption.Rules) > 0 {
+			config.Encryption = encryption.Rules[0].Apply.SSEAlgorithm
+		}
+	}
+
+	lifecycle, err := bucket.client.GetBucketLifecycle(ctx, bucket.Name)
+	if read(stats.BucketSettingLifecycle, err) && lifecycle != nil {
+		config.LifecycleRules = len(lifecycle.Rules)
+	}
+
+	replication, err := bucket.client.GetBucketReplication(ctx, bucket.Name)
+	if read(stats.BucketSettingReplication, err) {
+		config.ReplicationRules = len(replication.Rules)
+	}
+
+	notification, err := bucket.client.GetBucketNotification(ctx, bucket.Name)
+	if read(stats.BucketSettingNotification, err) {
+		config.NotificationRules = len(notification.LambdaConfigs) + len(notification.TopicConfigs) + len(notification.QueueConfigs)
+	}
+
+	policy, err := bucket.client.GetBucketPolicy(ctx, bucket.Name)
+	if read(stats.BucketSettingPolicy, err) {
+		config.Policy = policy != ""
+	}
+
+	return config
+}
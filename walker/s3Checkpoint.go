@@ -0,0 +1,299 @@
+package walker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/willena/s3-exporter/stats"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// s3Checkpoint is the state of an S3 walk, persisted to resume interrupted listings
+type s3Checkpoint struct {
+	// Fingerprint identifies the configuration the checkpoint was built with
+	Fingerprint string                       `json:"fingerprint"`
+	StartTime   time.Time                    `json:"startTime"`
+	Buckets     map[string]*bucketCheckpoint `json:"buckets"`
+	// Errors counts the walk errors per class
+	Errors map[string]uint64 `json:"errors"`
+}
+
+type bucketCheckpoint struct {
+	Done bool `json:"done"`
+	// Listings holds the progress of each listing of the bucket, keyed by listing ID
+	Listings map[string]*listingCheckpoint `json:"listings"`
+	// Snapshot aggregates the objects processed so far
+	Snapshot *stats.Snapshot `json:"snapshot"`
+}
+
+type listingCheckpoint struct {
+	LastKey string `json:"lastKey"`
+	Done    bool   `json:"done"`
+}
+
+// s3Checkpointer accounts objects per bucket and periodically saves the walk state to a file. The samples of the
+// processed keys are accounted asynchronously: the state is only saved once none of them is pending, so that resumed
+// walks do not miss the samples of the keys they skip.
+type s3Checkpointer struct {
+	path        string
+	interval    time.Duration
+	newSnapshot func() *stats.Snapshot
+
+	mutex    sync.Mutex
+	state    *s3Checkpoint
+	lastSave time.Time
+	// pending counts the samples of the processed keys not accounted yet; drained is signaled when it drops to 0
+	pending int
+	drained *sync.Cond
+	// lostSamples is set once a sample could not complete because the walk was interrupted: the state misses it and
+	// is not saved anymore
+	lostSamples bool
+}
+
+// loadCheckpoint resumes the walk saved at path, or starts a new one if there is none
+// or if it was built with another configuration
+func loadCheckpoint(path string, interval time.Duration, fingerprint string, newSnapshot func() *stats.Snapshot) *s3Checkpointer {
+	checkpointer := &s3Checkpointer{
+		path:        path,
+		interval:    interval,
+		newSnapshot: newSnapshot,
+		lastSave:    time.Now(),
+		state: &s3Checkpoint{
+			Fingerprint: fingerprint,
+			StartTime:   time.Now(),
+			Buckets:     map[string]*bucketCheckpoint{},
+			Errors:      map[string]uint64{},
+		},
+	}
+	checkpointer.drained = sync.NewCond(&checkpointer.mutex)
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Could not read checkpoint %s, starting a new walk: %s", path, err.Error())
+		}
+		return checkpointer
+	}
+
+	var state s3Checkpoint
+	if err := json.Unmarshal(content, &state); err != nil {
+		log.Warnf("Invalid checkpoint %s, starting a new walk: %s", path, err.Error())
+		return checkpointer
+	}
+	if state.Fingerprint != fingerprint {
+		log.Warnf("Checkpoint %s was built with another configuration, starting a new walk", path)
+		return checkpointer
+	}
+
+	log.Infof("Resuming walk started on %s from checkpoint %s", state.StartTime, path)
+	if state.Errors == nil {
+		state.Errors = map[string]uint64{}
+	}
+	checkpointer.state = &state
+	return checkpointer
+}
+
+// checkpointFormat is increased when the content of checkpoints changes, so that older checkpoints are ignored
+const checkpointFormat = 3
+
+// checkpointFingerprint identifies the options changing the way objects are listed or aggregated
+func checkpointFingerprint(options ...interface{}) string {
+	content, _ := json.Marshal(append([]interface{}{checkpointFormat}, options...))
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// bucketDone returns the aggregates of bucket if it was completely walked
+func (c *s3Checkpointer) bucketDone(bucket string) (*stats.Snapshot, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state, ok := c.state.Buckets[bucket]
+	if !ok || !state.Done {
+		return nil, false
+	}
+	return state.Snapshot, true
+}
+
+// listing returns the progress of listing of bucket
+func (c *s3Checkpointer) listing(bucket string, listing string) listingCheckpoint {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return *c.listingState(bucket, listing)
+}
+
+// process accounts an object of a listing with account, and records key as the last processed one; samples is the
+// number of samples of key to be accounted with account later, each one followed by sampleDone. When a save is due,
+// it first waits for the samples of the keys processed so far.
+func (c *s3Checkpointer) process(bucket string, listing string, key string, samples int, account func(processor stats.FileProcessor) error) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for c.pending > 0 && time.Since(c.lastSave) >= c.interval {
+		c.drained.Wait()
+	}
+	if time.Since(c.lastSave) >= c.interval {
+		c.save()
+	}
+
+	if err := account(c.bucketState(bucket).Snapshot); err != nil {
+		return err
+	}
+	c.listingState(bucket, listing).LastKey = key
+	c.pending += samples
+	return nil
+}
+
+// sampleDone tells that a sample of a processed key completed, or was lost when the walk was interrupted
+func (c *s3Checkpointer) sampleDone(lost bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lostSamples = c.lostSamples || lost
+	c.pending--
+	if c.pending == 0 {
+		c.drained.Broadcast()
+	}
+}
+
+// recordError accounts a walk error of the given class
+func (c *s3Checkpointer) recordError(class string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.state.Errors[class]++
+}
+
+// errorsSnapshot returns a snapshot holding the walk errors accounted so far
+func (c *s3Checkpointer) errorsSnapshot() *stats.Snapshot {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	snapshot := c.newSnapshot()
+	for class, count := range c.state.Errors {
+		snapshot.Errors[class] = count
+	}
+	return snapshot
+}
+
+// account runs account on the aggregates of bucket
+func (c *s3Checkpointer) account(bucket string, account func(processor stats.FileProcessor) error) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return account(c.bucketState(bucket).Snapshot)
+}
+
+func (c *s3Checkpointer) completeListing(bucket string, listing string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.listingState(bucket, listing).Done = true
+}
+
+// mergeListing adds the aggregates of a whole listing to bucket and marks the listing as done
+func (c *s3Checkpointer) mergeListing(bucket string, listing string, snapshot *stats.Snapshot) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.bucketState(bucket).Snapshot.Merge(snapshot)
+	c.listingState(bucket, listing).Done = true
+}
+
+// completeBucket marks bucket as done and returns its aggregates. The samples of the bucket must have been accounted;
+// the state is saved once the samples of the other buckets are too.
+func (c *s3Checkpointer) completeBucket(bucket string) *stats.Snapshot {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state := c.bucketState(bucket)
+	state.Done = true
+	state.Listings = map[string]*listingCheckpoint{}
+	for c.pending > 0 {
+		c.drained.Wait()
+	}
+	c.save()
+	return state.Snapshot
+}
+
+// flush writes the walk state to the checkpoint file, unless samples of the processed keys are missing: the last
+// saved state is then kept
+func (c *s3Checkpointer) flush() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.save()
+}
+
+// clear removes the checkpoint file once the walk completed
+func (c *s3Checkpointer) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Could not remove checkpoint %s: %s", c.path, err.Error())
+	}
+}
+
+func (c *s3Checkpointer) bucketState(bucket string) *bucketCheckpoint {
+	state, ok := c.state.Buckets[bucket]
+	if !ok {
+		state = &bucketCheckpoint{
+			Listings: map[string]*listingCheckpoint{},
+			Snapshot: c.newSnapshot(),
+		}
+		c.state.Buckets[bucket] = state
+	}
+	return state
+}
+
+func (c *s3Checkpointer) listingState(bucket string, listing string) *listingCheckpoint {
+	state := c.bucketState(bucket)
+	listingState, ok := state.Listings[listing]
+	if !ok {
+		listingState = &listingCheckpoint{}
+		state.Listings[listing] = listingState
+	}
+	return listingState
+}
+
+// save writes the state to a temporary file renamed over the checkpoint, so that it is never half written. States
+// missing samples are not saved.
+func (c *s3Checkpointer) save() {
+	c.lastSave = time.Now()
+	if c.pending > 0 || c.lostSamples {
+		log.Warnf("Samples of the processed objects are missing, keeping the previous checkpoint %s", c.path)
+		return
+	}
+	if err := c.write(); err != nil {
+		log.Errorf("Could not save checkpoint %s: %s", c.path, err.Error())
+	}
+}
+
+func (c *s3Checkpointer) write() error {
+	content, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), c.path); err != nil {
+		return fmt.Errorf("could not replace checkpoint: %w", err)
+	}
+	log.Debugf("Checkpoint saved to %s", c.path)
+	return nil
+}
@@ -0,0 +1,159 @@
+package walker
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/willena/s3-exporter/stats"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// interruptWalk walks with walker until a listing request is held by fake, then cancels the walk and waits for it
+// to stop; it returns the continuation token of the held request
+func interruptWalk(t *testing.T, fake *fakeS3, walker *S3Walker) string {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = walker.Walk(ctx)
+	}()
+
+	var token string
+	select {
+	case token = <-fake.blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the listing was not held")
+	}
+	cancel()
+	// Walk returns before the aborted walk stops, which then saves the checkpoint
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&walker.walking) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the interrupted walk did not stop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return token
+}
+
+func readCheckpoint(t *testing.T, path string) *s3Checkpoint {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state s3Checkpoint
+	if err := json.Unmarshal(content, &state); err != nil {
+		t.Fatal(err)
+	}
+	return &state
+}
+
+// TestCheckpointResume interrupts a walk, then checks that resuming it skips the keys up to the last one processed,
+// and that walks with another configuration start over
+func TestCheckpointResume(t *testing.T) {
+	const objects = 100
+	fake, endpoint := newFakeS3(t, map[string]map[string]int64{"data": fakeBucket(objects)})
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	args := []string{"--maxDepth", "2", "--s3.page-size", "10", "--s3.checkpoint-file", path, "--s3.checkpoint-interval", "1h"}
+	expected := walkedSeries(walkS3(t, endpoint, "--maxDepth", "2"))
+
+	fake.block("images/")
+	lastKey := interruptWalk(t, fake, newS3Walker(t, endpoint, args...))
+	fake.block("")
+
+	state := readCheckpoint(t, path)
+	bucket := state.Buckets["data/"]
+	if bucket == nil || bucket.Done || bucket.Listings[listingID("", true)] == nil {
+		t.Fatalf("unexpected checkpoint: %+v", state)
+	}
+	if key := bucket.Listings[listingID("", true)].LastKey; key != lastKey {
+		t.Fatalf("expected the checkpoint to stop at %s, got %s", lastKey, key)
+	}
+	processed := uint64(0)
+	for key := range fakeBucket(objects) {
+		if key <= lastKey {
+			processed++
+		}
+	}
+	if count := bucket.Snapshot.Series[stats.SeriesKey(map[string]string{"bucket": "data", "scope": "", "region": "us-east-1", "storageClass": "STANDARD"})].Objects; count != processed {
+		t.Fatalf("expected %d objects in the checkpoint, got %d", processed, count)
+	}
+	saved, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another configuration does not resume the walk
+	before := len(fake.requests())
+	snapshot := walkS3(t, endpoint, append(args, "--maxDepth", "3")...)
+	if start := fake.requests()[before].Get("start-after"); start != "" {
+		t.Errorf("walk with another configuration resumed after %s", start)
+	}
+	var count uint64
+	for _, series := range snapshot.Series {
+		count += series.Objects
+	}
+	if count != objects {
+		t.Errorf("walk with another configuration accounted %d objects, expected %d", count, objects)
+	}
+
+	if err := ioutil.WriteFile(path, saved, 0600); err != nil {
+		t.Fatal(err)
+	}
+	before = len(fake.requests())
+	resumed := walkedSeries(walkS3(t, endpoint, args...))
+	if start := fake.requests()[before].Get("start-after"); start != lastKey {
+		t.Errorf("expected the walk to resume after %s, got %q", lastKey, start)
+	}
+	if !reflect.DeepEqual(resumed, expected) {
+		t.Error("resumed walk aggregates differ from the uninterrupted walk ones")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("checkpoint was not removed once the walk completed: %v", err)
+	}
+}
+
+// TestCheckpointSamples checks that walk errors are saved, and that states missing samples are not
+func TestCheckpointSamples(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	newSnapshot := func() *stats.Snapshot { return stats.NewSnapshot(nil, nil) }
+	labels := map[string]string{"bucket": "data"}
+	account := func(processor stats.FileProcessor) error {
+		processor.ProcessFile("ROOT", 1, 1, "", "", time.Time{}, labels)
+		return nil
+	}
+
+	checkpoint := loadCheckpoint(path, time.Hour, "fingerprint", newSnapshot)
+	if err := checkpoint.process("data/", "list:", "a", 1, account); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint.recordError("access_denied")
+	checkpoint.sampleDone(false)
+	checkpoint.flush()
+
+	resumed := loadCheckpoint(path, time.Hour, "fingerprint", newSnapshot)
+	if key := resumed.listing("data/", "list:").LastKey; key != "a" {
+		t.Errorf("expected the listing to resume after a, got %q", key)
+	}
+	if errors := resumed.errorsSnapshot().Errors; errors["access_denied"] != 1 {
+		t.Errorf("walk errors were not saved: %v", errors)
+	}
+
+	// The sample of b is lost: the state stays at a
+	if err := resumed.process("data/", "list:", "b", 1, account); err != nil {
+		t.Fatal(err)
+	}
+	resumed.sampleDone(true)
+	resumed.flush()
+	if key := readCheckpoint(t, path).Buckets["data/"].Listings["list:"].LastKey; key != "a" {
+		t.Errorf("state missing samples was saved, listing stopped at %q", key)
+	}
+
+	if key := loadCheckpoint(path, time.Hour, "other", newSnapshot).listing("data/", "list:").LastKey; key != "" {
+		t.Errorf("checkpoint of another configuration was resumed after %q", key)
+	}
+}
@@ -0,0 +1,136 @@
+package walker
+
+import (
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/willena/s3-exporter/stats"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// S3Connection holds the options to reach an S3 endpoint, shared by the walkers reading S3
+type S3Connection struct {
+	Endpoint        string `long:"endpoint" description:"URL to the S3" required:"false" env:"ENDPOINT"`
+	AccessKey       string `long:"access-key" description:"S3 Storage Access Key" required:"false" env:"ACCESS_KEY"`
+	SecretKey       string `long:"secret-key" description:"S3 Storage Secret Key" required:"false" env:"SECRET_KEY"`
+	SessionToken    string `long:"session-token" description:"S3 Storage Session Token, for temporary credentials" required:"false" env:"SESSION_TOKEN"`
+	Region          string `long:"region" description:"S3 Storage Region" required:"false" env:"REGION" default:"us-west"`
+	BucketPathStyle bool   `long:"bucket-path-style" description:"Bucket type" required:"false" env:"BUCKET_PATH_STYLE"`
+
+	Credentials          []string      `long:"credentials" description:"Credential sources, tried in order until one provides credentials: static (access key, secret key and session token), env (AWS_* or MINIO_* variables), file (shared credentials file), iam (EC2/ECS metadata, or web identity from AWS_* variables), web-identity (token file)" required:"false" env:"CREDENTIALS" env-delim:"," default:"static"`
+	CredentialsFile      string        `long:"credentials-file" description:"Shared credentials file of the file source; defaults to AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials" required:"false" env:"CREDENTIALS_FILE"`
+	Profile              string        `long:"profile" description:"Profile of the shared credentials file; defaults to AWS_PROFILE or default" required:"false" env:"PROFILE"`
+	IAMEndpoint          string        `long:"iam-endpoint" description:"Custom EC2/ECS metadata endpoint of the iam source" required:"false" env:"IAM_ENDPOINT"`
+	WebIdentityTokenFile string        `long:"web-identity-token-file" description:"Token file of the web-identity source, exchanged for temporary credentials of the role" required:"false" env:"WEB_IDENTITY_TOKEN_FILE"`
+	STSEndpoint          string        `long:"sts-endpoint" description:"STS endpoint used to assume roles" required:"false" env:"STS_ENDPOINT" default:"https://sts.amazonaws.com"`
+	STSRegion            string        `long:"sts-region" description:"Region signing STS requests; defaults to us-east-1 for sts.amazonaws.com, to the region of regional AWS STS endpoints, or else to the S3 region" required:"false" env:"STS_REGION"`
+	RoleARN              string        `long:"role-arn" description:"Role assumed with the credentials of the sources (STS AssumeRole), or with the web identity token" required:"false" env:"ROLE_ARN"`
+	RoleSessionName      string        `long:"role-session-name" description:"Session name of the assumed role" required:"false" env:"ROLE_SESSION_NAME" default:"s3-exporter"`
+	ExternalID           string        `long:"external-id" description:"External ID given when assuming the role" required:"false" env:"EXTERNAL_ID"`
+	RoleDuration         time.Duration `long:"role-duration" description:"Validity of the temporary credentials of the role; they are refreshed before they expire" required:"false" env:"ROLE_DURATION" default:"1h"`
+	Signature            string        `long:"signature" description:"Signature of requests; v2 is only meant for legacy gateways" required:"false" env:"SIGNATURE" default:"v4" choice:"v4" choice:"v2"`
+
+	CACert              string        `long:"ca-cert" description:"PEM bundle of the certificate authorities trusted in addition to the system ones" required:"false" env:"CA_CERT"`
+	ClientCert          string        `long:"client-cert" description:"PEM client certificate presented to the S3 endpoint" required:"false" env:"CLIENT_CERT"`
+	ClientKey           string        `long:"client-key" description:"PEM private key of the client certificate" required:"false" env:"CLIENT_KEY"`
+	TLSMinVersion       string        `long:"tls-min-version" description:"Minimum TLS version: 1.0, 1.1, 1.2 or 1.3" required:"false" env:"TLS_MIN_VERSION" default:"1.2"`
+	TLSSkipVerify       bool          `long:"tls-skip-verify" description:"Do not verify the certificate of the S3 endpoint; only meant for labs" required:"false" env:"TLS_SKIP_VERIFY"`
+	Proxy               string        `long:"proxy" description:"URL of the proxy used to reach the S3 and STS endpoints; defaults to HTTPS_PROXY, HTTP_PROXY and NO_PROXY" required:"false" env:"PROXY"`
+	ConnectTimeout      time.Duration `long:"connect-timeout" description:"Timeout of connections to the endpoints. 0 disables the timeout" required:"false" env:"CONNECT_TIMEOUT" default:"30s"`
+	ReadTimeout         time.Duration `long:"read-timeout" description:"Timeout waiting for the response headers of requests. 0 disables the timeout" required:"false" env:"READ_TIMEOUT" default:"1m"`
+	MaxIdleConns        int           `long:"max-idle-conns" description:"Maximum number of idle connections kept open. 0 disables the limit" required:"false" env:"MAX_IDLE_CONNS" default:"256"`
+	MaxIdleConnsPerHost int           `long:"max-idle-conns-per-host" description:"Maximum number of idle connections kept open per host" required:"false" env:"MAX_IDLE_CONNS_PER_HOST" default:"16"`
+	MaxConnsPerHost     int           `long:"max-conns-per-host" description:"Maximum number of connections per host. 0 disables the limit" required:"false" env:"MAX_CONNS_PER_HOST" default:"0"`
+
+	RequestRate     float64       `long:"request-rate" description:"Maximum number of requests per second to the endpoints. 0 disables the limit" required:"false" env:"REQUEST_RATE" default:"0"`
+	MaxRequests     int           `long:"max-requests" description:"Maximum number of requests in flight to the endpoints. 0 disables the limit" required:"false" env:"MAX_REQUESTS" default:"0"`
+	MaxAttempts     int           `long:"max-attempts" description:"Maximum number of attempts of requests failing with throttling, server or network errors" required:"false" env:"MAX_ATTEMPTS" default:"10"`
+	RetryUnit       time.Duration `long:"retry-unit" description:"Base delay between attempts, doubled after each attempt" required:"false" env:"RETRY_UNIT" default:"200ms"`
+	RetryCap        time.Duration `long:"retry-cap" description:"Maximum delay between attempts" required:"false" env:"RETRY_CAP" default:"1s"`
+	PageSize        int           `long:"page-size" description:"Number of keys or parts per listing request, at most 1000" required:"false" env:"PAGE_SIZE" default:"1000"`
+	ListRequestCost float64       `long:"list-request-cost" description:"Price of 1000 LIST, PUT and POST requests, used to estimate the cost of walks" required:"false" env:"LIST_REQUEST_COST" default:"0.005"`
+	GetRequestCost  float64       `long:"get-request-cost" description:"Price of 1000 GET, HEAD and other requests, used to estimate the cost of walks" required:"false" env:"GET_REQUEST_COST" default:"0.0004"`
+}
+
+// s3Connection creates the clients of an S3 endpoint, which share the credentials and the transport
+type s3Connection struct {
+	config    *S3Connection
+	client    *minio.Client
+	creds     *credentials.Credentials
+	transport http.RoundTripper
+	// stats accounts the requests sent through the transport
+	stats stats.StatsInterface
+
+	clientsMutex sync.Mutex
+	// clients holds the client of each region, the client of the configured region included
+	clients map[string]*minio.Client
+}
+
+func newS3Connection(config *S3Connection, stats stats.StatsInterface) (*s3Connection, error) {
+	c := &s3Connection{config: config, stats: stats, clients: map[string]*minio.Client{}}
+	transport, err := c.createTransport()
+	if err != nil {
+		return nil, err
+	}
+	c.transport = newRequestsTransport(c, transport)
+	c.creds = c.credentials()
+	c.client, err = c.clientFor(config.Region)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// createClient creates a client signing requests for region; with an empty region, the client discovers
+// the region of buckets
+func (c *s3Connection) createClient(region string) (*minio.Client, error) {
+	// Initialize minio client object.
+	uri, err := url.ParseRequestURI(c.config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("could not read S3 url: %w", err)
+	}
+
+	bucketType := minio.BucketLookupDNS
+	if c.config.BucketPathStyle {
+		bucketType = minio.BucketLookupPath
+	}
+
+	return minio.New(uri.Host, &minio.Options{
+		Region:       region,
+		Creds:        c.creds,
+		Secure:       uri.Scheme == "https",
+		BucketLookup: bucketType,
+		Transport:    c.transport,
+	})
+}
+
+// clientFor returns the client signing requests for region, created on first use.
+// The client of the empty region discovers the region of each bucket itself.
+func (c *s3Connection) clientFor(region string) (*minio.Client, error) {
+	c.clientsMutex.Lock()
+	defer c.clientsMutex.Unlock()
+
+	if client, ok := c.clients[region]; ok {
+		return client, nil
+	}
+	client, err := c.createClient(region)
+	if err != nil {
+		return nil, err
+	}
+	c.clients[region] = client
+	return client, nil
+}
+
+// validateConnection checks the options of the credentials, transport and requests
+func validateConnection(config *S3Connection) error {
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+	if err := validateTransport(config); err != nil {
+		return err
+	}
+	return validateRequests(config)
+}
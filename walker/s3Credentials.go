@@ -0,0 +1,254 @@
+package walker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/signer"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Credential sources, tried in the configured order
+const (
+	credentialsStatic      = "static"
+	credentialsEnv         = "env"
+	credentialsFile        = "file"
+	credentialsIAM         = "iam"
+	credentialsWebIdentity = "web-identity"
+)
+
+// Request signatures
+const (
+	signatureV4 = "v4"
+	signatureV2 = "v2"
+)
+
+// credentials chains the configured credential sources. When a role is configured, the resolved credentials
+// are used to assume it, unless they come from a web identity which already assumed it. Temporary credentials
+// are refreshed before they expire.
+func (c *s3Connection) credentials() *credentials.Credentials {
+	providers := make([]credentials.Provider, 0, len(c.config.Credentials))
+	webIdentity := false
+	for _, source := range c.config.Credentials {
+		providers = append(providers, &sourceProvider{Provider: c.credentialsSource(source), source: source})
+		webIdentity = webIdentity || source == credentialsWebIdentity
+	}
+
+	var provider credentials.Provider = &credentials.Chain{Providers: providers}
+	if c.config.RoleARN != "" && !webIdentity {
+		provider = &assumeRoleProvider{
+			client:   &http.Client{Transport: c.transport},
+			endpoint: c.config.STSEndpoint,
+			region:   stsRegion(c.config),
+			source:   credentials.New(provider),
+			options:  c.config,
+		}
+	}
+	if c.config.Signature == signatureV2 {
+		provider = &signatureV2Provider{Provider: provider}
+	}
+	return credentials.New(provider)
+}
+
+func (c *s3Connection) credentialsSource(source string) credentials.Provider {
+	switch source {
+	case credentialsEnv:
+		return &credentials.Chain{Providers: []credentials.Provider{&credentials.EnvAWS{}, &credentials.EnvMinio{}}}
+	case credentialsFile:
+		return &credentials.FileAWSCredentials{Filename: c.config.CredentialsFile, Profile: c.config.Profile}
+	case credentialsIAM:
+		// The metadata endpoint is link-local: never go through the proxy nor present the client certificate
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		return &credentials.IAM{Client: &http.Client{Transport: transport}, Endpoint: c.config.IAMEndpoint}
+	case credentialsWebIdentity:
+		return &credentials.STSWebIdentity{
+			Client:      &http.Client{Transport: c.transport},
+			STSEndpoint: c.config.STSEndpoint,
+			RoleARN:     c.config.RoleARN,
+			GetWebIDTokenExpiry: func() (*credentials.WebIdentityToken, error) {
+				// Read again on each refresh, as the token is rotated
+				token, err := ioutil.ReadFile(c.config.WebIdentityTokenFile)
+				if err != nil {
+					return nil, err
+				}
+				return &credentials.WebIdentityToken{
+					Token:  strings.TrimSpace(string(token)),
+					Expiry: int(c.config.RoleDuration.Seconds()),
+				}, nil
+			},
+		}
+	default: // credentialsStatic
+		return &credentials.Static{Value: credentials.Value{
+			AccessKeyID:     c.config.AccessKey,
+			SecretAccessKey: c.config.SecretKey,
+			SessionToken:    c.config.SessionToken,
+			SignerType:      credentials.SignatureV4,
+		}}
+	}
+}
+
+// sourceProvider logs why a credential source was skipped, the chain silently trying the next one
+type sourceProvider struct {
+	credentials.Provider
+	source string
+}
+
+func (p *sourceProvider) Retrieve() (credentials.Value, error) {
+	value, err := p.Provider.Retrieve()
+	if err != nil {
+		log.Debugf("No credentials from source %s: %s", p.source, err.Error())
+	}
+	return value, err
+}
+
+// signatureV2Provider signs requests with the credentials of Provider using signature V2, for legacy gateways.
+// Signature V2 can not carry session tokens, so temporary credentials are refused.
+type signatureV2Provider struct {
+	credentials.Provider
+}
+
+func (p *signatureV2Provider) Retrieve() (credentials.Value, error) {
+	value, err := p.Provider.Retrieve()
+	if err != nil || value.SignerType.IsAnonymous() {
+		return value, err
+	}
+	if value.SessionToken != "" {
+		return credentials.Value{}, fmt.Errorf("temporary credentials can not be used with signature V2")
+	}
+	value.SignerType = credentials.SignatureV2
+	return value, nil
+}
+
+// assumeRoleProvider assumes a role with STS AssumeRole, signed with the source credentials.
+// Unlike credentials.STSAssumeRole, it supports session tokens in the source credentials and external IDs.
+type assumeRoleProvider struct {
+	credentials.Expiry
+
+	client   *http.Client
+	endpoint string
+	region   string
+	source   *credentials.Credentials
+	options  *S3Connection
+}
+
+func (p *assumeRoleProvider) Retrieve() (credentials.Value, error) {
+	source, err := p.source.Get()
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	if source.SignerType.IsAnonymous() {
+		return credentials.Value{}, fmt.Errorf("no credentials to assume role %s", p.options.RoleARN)
+	}
+
+	values := url.Values{}
+	values.Set("Action", "AssumeRole")
+	values.Set("Version", credentials.STSVersion)
+	values.Set("RoleArn", p.options.RoleARN)
+	values.Set("RoleSessionName", p.options.RoleSessionName)
+	values.Set("DurationSeconds", strconv.Itoa(int(p.options.RoleDuration.Seconds())))
+	if p.options.ExternalID != "" {
+		values.Set("ExternalId", p.options.ExternalID)
+	}
+	body := values.Encode()
+	sum := sha256.Sum256([]byte(body))
+
+	endpoint, err := url.Parse(p.endpoint)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("invalid STS endpoint: %w", err)
+	}
+	if endpoint.Path == "" {
+		// The signature covers the path, which servers read as the root
+		endpoint.Path = "/"
+	}
+	request, err := http.NewRequest(http.MethodPost, endpoint.String(), strings.NewReader(body))
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	request = request.WithContext(withOperation(request.Context(), "AssumeRole"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	if source.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", source.SessionToken)
+	}
+	request = signer.SignV4STS(*request, source.AccessKeyID, source.SecretAccessKey, p.region)
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return credentials.Value{}, fmt.Errorf("could not assume role %s: %s", p.options.RoleARN, response.Status)
+	}
+
+	var result credentials.AssumeRoleResponse
+	if err := xml.NewDecoder(response.Body).Decode(&result); err != nil {
+		return credentials.Value{}, fmt.Errorf("invalid AssumeRole response: %w", err)
+	}
+	assumed := result.Result.Credentials
+	p.SetExpiration(assumed.Expiration, credentials.DefaultExpiryWindow)
+	log.Debugf("Assumed role %s until %s", p.options.RoleARN, assumed.Expiration)
+	return credentials.Value{
+		AccessKeyID:     assumed.AccessKey,
+		SecretAccessKey: assumed.SecretKey,
+		SessionToken:    assumed.SessionToken,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+// stsRegion returns the region signing STS requests: the configured one, or else us-east-1 for the global AWS
+// endpoint, the region of regional AWS endpoints (sts.<region>.amazonaws.com), or the S3 region
+func stsRegion(config *S3Connection) string {
+	if config.STSRegion != "" {
+		return config.STSRegion
+	}
+	endpoint, err := url.Parse(config.STSEndpoint)
+	if err != nil {
+		return config.Region
+	}
+	host := strings.Split(endpoint.Hostname(), ".")
+	switch {
+	case len(host) == 3 && host[0] == "sts" && host[1] == "amazonaws":
+		return "us-east-1"
+	case len(host) >= 4 && (host[0] == "sts" || host[0] == "sts-fips") && host[2] == "amazonaws":
+		return host[1]
+	}
+	return config.Region
+}
+
+// validateCredentials checks the options of the credential sources
+func validateCredentials(config *S3Connection) error {
+	if len(config.Credentials) == 0 {
+		return fmt.Errorf("at least one credential source is required")
+	}
+	for _, source := range config.Credentials {
+		switch source {
+		case credentialsStatic, credentialsEnv, credentialsFile, credentialsIAM:
+		case credentialsWebIdentity:
+			if config.WebIdentityTokenFile == "" {
+				return fmt.Errorf("web identity credentials require a token file")
+			}
+		default:
+			return fmt.Errorf("unknown credential source %q", source)
+		}
+	}
+	if config.ExternalID != "" && config.RoleARN == "" {
+		return fmt.Errorf("an external ID requires a role to assume")
+	}
+	if config.Signature == signatureV2 && (config.RoleARN != "" || config.SessionToken != "") {
+		return fmt.Errorf("signature V2 can not be used with temporary credentials")
+	}
+	if config.RoleDuration < 15*time.Minute || config.RoleDuration > 12*time.Hour {
+		return fmt.Errorf("role duration must be between 15m and 12h")
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package walker
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeCredentials serves EC2 instance metadata credentials and STS AssumeRole requests, counting them
+type fakeCredentials struct {
+	// expiration returns the expiration date of the credentials served
+	expiration func() time.Time
+
+	mutex sync.Mutex
+	// imds and sts count the credentials served by each endpoint
+	imds int
+	sts  int
+	// signers holds the access keys signing the AssumeRole requests, and externalIDs their external IDs
+	signers     []string
+	externalIDs []string
+}
+
+func newFakeCredentials(t *testing.T) (*fakeCredentials, string) {
+	fake := &fakeCredentials{expiration: func() time.Time { return time.Now().Add(time.Hour) }}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server.URL
+}
+
+func (f *fakeCredentials) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	expiration := f.expiration().UTC().Format(time.RFC3339)
+
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+		_, _ = w.Write([]byte("imds-token"))
+	case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+		_, _ = w.Write([]byte("walker-role"))
+	case r.URL.Path == "/latest/meta-data/iam/security-credentials/walker-role":
+		f.imds++
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"Code":            "Success",
+			"AccessKeyId":     "imds-access",
+			"SecretAccessKey": "imds-secret",
+			"Token":           "imds-session",
+			"Expiration":      expiration,
+		})
+	case r.Method == http.MethodPost && r.FormValue("Action") == "AssumeRole":
+		f.sts++
+		authorization := r.Header.Get("Authorization")
+		if i := strings.Index(authorization, "Credential="); i >= 0 {
+			f.signers = append(f.signers, strings.SplitN(authorization[i+len("Credential="):], "/", 2)[0])
+		}
+		f.externalIDs = append(f.externalIDs, r.FormValue("ExternalId"))
+		w.Header().Set("Content-Type", "text/xml")
+		_, _ = fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials>`+
+			`<AccessKeyId>role-access-%d</AccessKeyId><SecretAccessKey>role-secret</SecretAccessKey><SessionToken>role-session</SessionToken>`+
+			`<Expiration>%s</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`, f.sts, expiration)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeCredentials) counts() (imds int, sts int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.imds, f.sts
+}
+
+// TestCredentialsChain checks that the first source providing credentials wins, and that roles are assumed with them
+func TestCredentialsChain(t *testing.T) {
+	for _, variable := range []string{"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY", "MINIO_ACCESS_KEY", "MINIO_SECRET_KEY", "MINIO_ROOT_USER", "MINIO_ROOT_PASSWORD"} {
+		if value, ok := os.LookupEnv(variable); ok {
+			os.Unsetenv(variable)
+			defer os.Setenv(variable, value)
+		}
+	}
+
+	fake, endpoint := newFakeCredentials(t)
+	for _, test := range []struct {
+		name     string
+		args     []string
+		expected string
+		imds     int
+		sts      int
+	}{
+		{"static first", []string{"--s3.credentials", "static", "--s3.credentials", "iam"}, "access", 0, 0},
+		{"empty environment skipped", []string{"--s3.credentials", "env", "--s3.credentials", "iam", "--s3.credentials", "static"}, "imds-access", 1, 0},
+		{"role assumed with the instance credentials", []string{"--s3.credentials", "iam", "--s3.role-arn", "arn:aws:iam::1:role/walker", "--s3.external-id", "secret-id"}, "role-access-1", 1, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			imds, sts := fake.counts()
+			walker := newS3Walker(t, endpoint, append(test.args, "--s3.iam-endpoint", endpoint, "--s3.sts-endpoint", endpoint)...)
+			value, err := walker.creds.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value.AccessKeyID != test.expected {
+				t.Errorf("expected credentials %s, got %s", test.expected, value.AccessKeyID)
+			}
+			if newIMDS, newSTS := fake.counts(); newIMDS-imds != test.imds || newSTS-sts != test.sts {
+				t.Errorf("expected %d instance metadata and %d STS requests, got %d and %d", test.imds, test.sts, newIMDS-imds, newSTS-sts)
+			}
+		})
+	}
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if len(fake.signers) != 1 || fake.signers[0] != "imds-access" || fake.externalIDs[0] != "secret-id" {
+		t.Errorf("unexpected AssumeRole requests signed by %v with external IDs %v", fake.signers, fake.externalIDs)
+	}
+}
+
+// TestAssumeRoleRefresh checks that the credentials of the role are refreshed before they expire
+func TestAssumeRoleRefresh(t *testing.T) {
+	fake, endpoint := newFakeCredentials(t)
+	start := time.Now()
+	var now atomic.Value
+	clock := func() time.Time { return now.Load().(time.Time) }
+	fake.expiration = func() time.Time { return clock().Add(time.Hour) }
+
+	config := testConfig(t, "s3", "--s3.role-arn", "arn:aws:iam::1:role/walker").Options.(*S3WalkerConfig)
+	provider := &assumeRoleProvider{
+		client:   http.DefaultClient,
+		endpoint: endpoint,
+		region:   "us-east-1",
+		source:   credentials.NewStaticV4("access", "secret", ""),
+		options:  &config.S3Connection,
+	}
+	provider.CurrentTime = clock
+	creds := credentials.New(provider)
+
+	for _, step := range []struct {
+		elapsed time.Duration
+		sts     int
+	}{
+		{0, 1},
+		{30 * time.Minute, 1},
+		// Credentials are refreshed once 80% of their validity elapsed
+		{47 * time.Minute, 1},
+		{49 * time.Minute, 2},
+		{time.Hour, 2},
+		{time.Hour + 49*time.Minute, 3},
+	} {
+		now.Store(start.Add(step.elapsed))
+		value, err := creds.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, sts := fake.counts(); sts != step.sts || value.AccessKeyID != fmt.Sprintf("role-access-%d", step.sts) {
+			t.Errorf("after %s: expected %d AssumeRole requests, got %d (credentials %s)", step.elapsed, step.sts, sts, value.AccessKeyID)
+		}
+	}
+}
+
+func TestSTSRegion(t *testing.T) {
+	for _, test := range []struct {
+		endpoint string
+		region   string
+		expected string
+	}{
+		{"https://sts.amazonaws.com", "", "us-east-1"},
+		{"https://sts.eu-west-3.amazonaws.com", "", "eu-west-3"},
+		{"https://sts-fips.us-gov-west-1.amazonaws.com", "", "us-gov-west-1"},
+		{"https://sts.cn-north-1.amazonaws.com.cn", "", "cn-north-1"},
+		{"https://sts.amazonaws.com", "eu-central-1", "eu-central-1"},
+		{"https://minio.example.com:9000", "", "us-west"},
+	} {
+		config := &S3Connection{STSEndpoint: test.endpoint, STSRegion: test.region, Region: "us-west"}
+		if region := stsRegion(config); region != test.expected {
+			t.Errorf("%s: expected region %s, got %s", test.endpoint, test.expected, region)
+		}
+	}
+}
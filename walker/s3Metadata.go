@@ -0,0 +1,121 @@
+package walker
+
+import (
+	"context"
+	"github.com/minio/minio-go/v7"
+	log "github.com/sirupsen/logrus"
+	"github.com/willena/s3-exporter/stats"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Ways of reading objects metadata
+const (
+	metadataNone = "none"
+	// metadataList reads the metadata of all the objects from listings; this is a MinIO extension
+	metadataList = "list"
+	// metadataHead reads the metadata of sampled objects with HEAD requests
+	metadataHead = "head"
+)
+
+const userMetadataPrefix = "X-Amz-Meta-"
+
+// objectMetadata extracts the metadata of an object from its headers
+func objectMetadata(header http.Header, contentType string, replicationStatus string) stats.ObjectMetadata {
+	metadata := stats.ObjectMetadata{
+		ContentType:       contentType,
+		Encryption:        header.Get("X-Amz-Server-Side-Encryption"),
+		KMSKeyID:          header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"),
+		ReplicationStatus: replicationStatus,
+	}
+	if metadata.Encryption == "" {
+		metadata.Encryption = "none"
+	}
+	if metadata.ReplicationStatus == "" {
+		metadata.ReplicationStatus = "none"
+	}
+	for name := range header {
+		if strings.HasPrefix(name, userMetadataPrefix) {
+			metadata.MetadataKeys = append(metadata.MetadataKeys, strings.ToLower(strings.TrimPrefix(name, userMetadataPrefix)))
+		}
+	}
+	sort.Strings(metadata.MetadataKeys)
+	return metadata
+}
+
+// listedMetadata returns the metadata of an object listed with its metadata
+func listedMetadata(object minio.ObjectInfo) stats.ObjectMetadata {
+	header := make(http.Header, len(object.UserMetadata))
+	for name, value := range object.UserMetadata {
+		header.Set(name, value)
+	}
+	return objectMetadata(header, header.Get("Content-Type"), header.Get("X-Amz-Replication-Status"))
+}
+
+// objectSample returns the reads of the metadata and the tags of the object if it is sampled and the budget allows
+// it, and of its retention in object lock enabled buckets; it returns nil when nothing is read.
+func (s *S3Walker) objectSample(bucket *s3Bucket, key string, size int64, labels map[string]string) func(ctx context.Context) {
+	sampled := (s.config.Metadata == metadataHead || len(s.config.TagKeys) > 0) && s.sampled()
+	if !sampled && !bucket.objectLock {
+		return nil
+	}
+
+	return func(ctx context.Context) {
+		readMetadata := sampled && s.config.Metadata == metadataHead
+		if readMetadata || bucket.objectLock {
+			s.headObject(ctx, bucket, key, "", size, readMetadata, labels)
+		}
+		if sampled && len(s.config.TagKeys) > 0 {
+			s.readTags(ctx, bucket, key, size, labels)
+		}
+	}
+}
+
+// sampled draws whether an object is part of the sample, within the budget of the walk
+func (s *S3Walker) sampled() bool {
+	if s.config.MetadataSampleRatio < 1 && rand.Float64() >= s.config.MetadataSampleRatio {
+		return false
+	}
+	return s.config.MetadataBudget <= 0 || atomic.AddInt64(&s.sampledObjects, 1) <= s.config.MetadataBudget
+}
+
+// headObject reads an object version, the latest when versionID is empty, with a HEAD request. It accounts
+// its metadata when readMetadata is set, and its retention in object lock enabled buckets.
+func (s *S3Walker) headObject(ctx context.Context, bucket *s3Bucket, key string, versionID string, size int64, readMetadata bool, labels map[string]string) {
+	info, err := bucket.client.StatObject(ctx, bucket.Name, key, minio.StatObjectOptions{VersionID: versionID})
+	if err != nil {
+		feature := FeatureRetention
+		if readMetadata {
+			feature = FeatureMetadata
+		}
+		s.sampleError(ctx, bucket, key, feature, err)
+		return
+	}
+
+	metadata := objectMetadata(info.Metadata, info.ContentType, info.ReplicationStatus)
+	mode, retainUntil, legalHold := objectRetention(info.Metadata)
+	_ = s.accountBucket(bucket, func(processor stats.FileProcessor) error {
+		if readMetadata {
+			if err := s.processMetadataTo(ctx, processor, bucket.prefix, key, size, metadata, s.baseWalker.config.Depth, labels); err != nil {
+				return err
+			}
+		}
+		if !bucket.objectLock {
+			return nil
+		}
+		return s.processRetentionTo(ctx, processor, bucket.prefix, key, size, mode, retainUntil, legalHold, s.baseWalker.config.Depth, labels)
+	})
+}
+
+// sampleError accounts the failure to read feature of a sampled object
+func (s *S3Walker) sampleError(ctx context.Context, bucket *s3Bucket, key string, feature string, err error) {
+	if code := minio.ToErrorResponse(err).Code; ctx.Err() != nil || code == "NoSuchKey" || code == "NoSuchVersion" {
+		// Deleted since it was listed
+		return
+	}
+	log.Warningf("Could not read %s of %s/%s: %s", feature, bucket.Name, key, err.Error())
+	s.recordEnrichmentError(feature, err)
+}
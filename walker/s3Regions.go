@@ -0,0 +1,39 @@
+package walker
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+)
+
+// bucketRegion returns the region of bucket read with GetBucketLocation, or the configured region
+// when region discovery is disabled or the location can not be read; read failures are enrichment errors
+func (s *S3Walker) bucketRegion(ctx context.Context, bucket string) string {
+	if !s.config.DiscoverRegions {
+		return s.config.Region
+	}
+
+	locator, err := s.clientFor("")
+	if err != nil {
+		return s.config.Region
+	}
+	region, err := locator.GetBucketLocation(ctx, bucket)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warningf("Could not read location of bucket %s, using region %s: %s", bucket, s.config.Region, err.Error())
+			s.recordEnrichmentError(FeatureRegion, err)
+		}
+		return s.config.Region
+	}
+	return region
+}
+
+// openBucket routes the bucket of scope to the client of its region
+func (s *S3Walker) openBucket(ctx context.Context, scope s3Scope) (*s3Bucket, error) {
+	region := s.bucketRegion(ctx, scope.Name)
+	client, err := s.clientFor(region)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Bucket %s is in region %s", scope.Name, region)
+	return &s3Bucket{BucketInfo: scope.BucketInfo, prefix: scope.prefix, region: region, client: client}, nil
+}
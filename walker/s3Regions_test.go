@@ -0,0 +1,43 @@
+package walker
+
+import (
+	"context"
+	"github.com/willena/s3-exporter/stats"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestBucketRegions checks that buckets are walked in their region, and that buckets whose location can not be read
+// are walked in the configured region, the failure being an enrichment error
+func TestBucketRegions(t *testing.T) {
+	fake := &fakeS3{
+		buckets: map[string]map[string]int64{"data": fakeBucket(4), "logs": fakeBucket(2)},
+		regions: map[string]string{"data": "eu-west-3"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["location"]; ok && r.URL.Path == "/logs/" {
+			writeFakeError(w, http.StatusNotImplemented, "NotImplemented")
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	walker := newS3Walker(t, server.URL, "--s3.discover-regions")
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := walker.Stats.(*stats.PrometheusStats).Snapshot()
+	if snapshot.Partial {
+		t.Errorf("the location failure made the walk partial: %v", snapshot.Errors)
+	}
+	for bucket, region := range map[string]string{"data": "eu-west-3", "logs": "us-east-1"} {
+		if series := snapshot.Series[stats.SeriesKey(map[string]string{"bucket": bucket, "scope": "", "region": region, "storageClass": "STANDARD"})]; series == nil {
+			t.Errorf("%s was not walked in region %s", bucket, region)
+		}
+	}
+	if errors := walker.Stats.(*stats.PrometheusStats).Status().EnrichmentErrors[FeatureRegion]; errors[ErrorClassOther] != 1 || len(errors) != 1 {
+		t.Errorf("expected 1 region error, got %v", errors)
+	}
+}
@@ -0,0 +1,341 @@
+package walker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/willena/s3-exporter/utils"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3Operations maps the sub-resource of GET requests to their operation, checked in order
+var s3Operations = []struct {
+	subResource string
+	operation   string
+}{
+	{"location", "GetBucketLocation"},
+	{"versions", "ListObjectVersions"},
+	{"uploads", "ListMultipartUploads"},
+	{"uploadId", "ListParts"},
+	{"tagging", "GetObjectTagging"},
+	{"versioning", "GetBucketVersioning"},
+	{"object-lock", "GetObjectLockConfiguration"},
+	{"encryption", "GetBucketEncryption"},
+	{"lifecycle", "GetBucketLifecycleConfiguration"},
+	{"replication", "GetBucketReplication"},
+	{"notification", "GetBucketNotificationConfiguration"},
+	{"policy", "GetBucketPolicy"},
+	{"list-type", "ListObjectsV2"},
+}
+
+// retryableStatuses are the HTTP statuses of the responses retried by requestsTransport
+var retryableStatuses = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+type operationKey struct{}
+
+// withOperation names the operation of the requests sent with ctx, for requests not recognized by requestOperation
+func withOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, operationKey{}, operation)
+}
+
+// requestOperation returns the API operation of request
+func requestOperation(request *http.Request) string {
+	if operation, ok := request.Context().Value(operationKey{}).(string); ok {
+		return operation
+	}
+	query := request.URL.Query()
+	if action := query.Get("Action"); action != "" {
+		return action
+	}
+	switch request.Method {
+	case http.MethodHead:
+		return "HeadObject"
+	case http.MethodGet:
+		for _, candidate := range s3Operations {
+			if _, ok := query[candidate.subResource]; ok {
+				return candidate.operation
+			}
+		}
+		if request.URL.Path == "/" {
+			return "ListBuckets"
+		}
+		return "GetObject"
+	}
+	return request.Method
+}
+
+// requestsTransport limits the rate and the concurrency of the requests of a connection to its endpoints, retries
+// them, and accounts each attempt with its latency and estimated cost.
+type requestsTransport struct {
+	transport  http.RoundTripper
+	connection *s3Connection
+	limiter    *utils.RateLimiter
+	// slots holds a token per request in flight, until its response body is closed; nil without limit
+	slots chan struct{}
+
+	exhaustedMutex sync.Mutex
+	// exhausted holds the last failure of the requests which used all their attempts, answered to the retries of
+	// the minio client instead of sending them again
+	exhausted map[attemptsKey]*exhaustedRequest
+}
+
+// attemptsKey identifies the retries of a request by the minio client, which sends them with the same context,
+// method and URL
+type attemptsKey struct {
+	ctx    context.Context
+	method string
+	url    string
+}
+
+// exhaustedRequest is the last failure of a request which used all its attempts
+type exhaustedRequest struct {
+	at      time.Time
+	status  int
+	header  http.Header
+	body    []byte
+	err     error
+	retries int
+}
+
+func newRequestsTransport(c *s3Connection, transport http.RoundTripper) *requestsTransport {
+	t := &requestsTransport{
+		transport:  transport,
+		connection: c,
+		limiter:    utils.NewRateLimiter(c.config.RequestRate),
+		exhausted:  map[attemptsKey]*exhaustedRequest{},
+	}
+	if c.config.MaxRequests > 0 {
+		t.slots = make(chan struct{}, c.config.MaxRequests)
+	}
+	return t
+}
+
+// RoundTrip sends request, attempting it again after throttling, server or network errors with an exponential
+// backoff, up to the maximum number of attempts of the connection. Requests whose body can not be read again are
+// only attempted once.
+//
+// The minio client retries the same failures with its own process-wide policy, which also follows the region
+// redirects of buckets: once a request used all its attempts, its retries by the client get the same failure
+// without being sent again.
+func (t *requestsTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx := request.Context()
+	config := t.connection.config
+	key := attemptsKey{ctx: ctx, method: request.Method, url: request.URL.String()}
+	if response, err, ok := t.replay(key, request); ok {
+		return response, err
+	}
+	for attempt := 1; ; attempt++ {
+		response, err := t.send(request)
+		retryable := (err != nil && ctx.Err() == nil) || (err == nil && retryableStatuses[response.StatusCode])
+		if retryable && attempt >= config.MaxAttempts && (request.Body == nil || request.Body == http.NoBody) {
+			return t.exhaust(key, response, err)
+		}
+		if !retryable || attempt >= config.MaxAttempts || (request.Body != nil && request.GetBody == nil) {
+			return response, err
+		}
+		if response != nil {
+			_, _ = io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		select {
+		case <-time.After(retryDelay(attempt, config.RetryUnit, config.RetryCap)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			request = request.Clone(ctx)
+			request.Body = body
+		}
+	}
+}
+
+// exhaust keeps the failure of the request of key, which used all its attempts, to answer the retries of the
+// minio client; it returns the failure
+func (t *requestsTransport) exhaust(key attemptsKey, response *http.Response, err error) (*http.Response, error) {
+	failure := &exhaustedRequest{at: time.Now(), err: err}
+	if response != nil {
+		body, readErr := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if readErr != nil {
+			return nil, readErr
+		}
+		failure.status, failure.header, failure.body = response.StatusCode, response.Header, body
+		response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	t.exhaustedMutex.Lock()
+	defer t.exhaustedMutex.Unlock()
+	t.exhausted[key] = failure
+	return response, err
+}
+
+// replay returns the failure of request, of key, when it used all its attempts, and ok. The minio client makes
+// at most minio.MaxRetry attempts, waiting at most minio.DefaultRetryCap between them: failures are dropped once
+// they were all answered, or could no longer be retried.
+func (t *requestsTransport) replay(key attemptsKey, request *http.Request) (response *http.Response, err error, ok bool) {
+	t.exhaustedMutex.Lock()
+	defer t.exhaustedMutex.Unlock()
+
+	window := time.Duration(minio.MaxRetry) * minio.DefaultRetryCap
+	for other, failure := range t.exhausted {
+		if other.ctx.Err() != nil || time.Since(failure.at) > window {
+			delete(t.exhausted, other)
+		}
+	}
+	failure, ok := t.exhausted[key]
+	if !ok {
+		return nil, nil, false
+	}
+	failure.retries++
+	if failure.retries >= minio.MaxRetry-1 {
+		delete(t.exhausted, key)
+	}
+	if failure.err != nil {
+		return nil, failure.err, true
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", failure.status, http.StatusText(failure.status)),
+		StatusCode:    failure.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        failure.header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(failure.body)),
+		ContentLength: int64(len(failure.body)),
+		Request:       request,
+	}, nil, true
+}
+
+// retryDelay returns the delay before the attempt following attempt: a random duration up to unit doubled after
+// each attempt, at most limit
+func retryDelay(attempt int, unit time.Duration, limit time.Duration) time.Duration {
+	delay := limit
+	if attempt <= 30 && unit<<uint(attempt-1) < limit {
+		delay = unit << uint(attempt-1)
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// send sends one attempt of request within the limits of the connection, and accounts it
+func (t *requestsTransport) send(request *http.Request) (*http.Response, error) {
+	ctx := request.Context()
+	if t.slots != nil {
+		select {
+		case t.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err := t.limiter.Wait(ctx); err != nil {
+		t.release()
+		return nil, err
+	}
+
+	operation := requestOperation(request)
+	start := time.Now()
+	response, err := t.transport.RoundTrip(request)
+	latency := time.Since(start)
+	if err != nil {
+		t.release()
+		t.connection.stats.RecordRequest(operation, "error", latency, false, t.connection.requestCost(operation, request.Method))
+		return nil, err
+	}
+
+	throttled := isThrottled(response)
+	t.connection.stats.RecordRequest(operation, strconv.Itoa(response.StatusCode), latency, throttled, t.connection.requestCost(operation, request.Method))
+	if t.slots != nil {
+		response.Body = &releasingBody{ReadCloser: response.Body, release: t.release}
+	}
+	return response, nil
+}
+
+func (t *requestsTransport) release() {
+	if t.slots != nil {
+		<-t.slots
+	}
+}
+
+// releasingBody releases the slot of its request once closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// isThrottled reports whether response rejects its request because of the request rate. The error body of
+// 503 responses is read to find the code, then restored.
+func isThrottled(response *http.Response) bool {
+	switch response.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusServiceUnavailable:
+		body, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		response.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err != nil || len(body) == 0 {
+			// HEAD responses have no body; S3 answers 503 when slowing clients down
+			return true
+		}
+		for _, code := range ThrottlingCodes {
+			if bytes.Contains(body, []byte("<Code>"+code+"</Code>")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requestCost estimates the cost of a request: listings and writes are charged the list price, other S3
+// requests the get price, and STS requests are free
+func (c *s3Connection) requestCost(operation string, method string) float64 {
+	switch {
+	case strings.HasPrefix(operation, "AssumeRole"):
+		return 0
+	case strings.HasPrefix(operation, "List") || method == http.MethodPut || method == http.MethodPost:
+		return c.config.ListRequestCost / 1000
+	default:
+		return c.config.GetRequestCost / 1000
+	}
+}
+
+// validateRequests checks the options of the requests limits, retries and costs
+func validateRequests(config *S3Connection) error {
+	if config.RequestRate < 0 || config.MaxRequests < 0 {
+		return fmt.Errorf("request rate and maximum requests can not be negative")
+	}
+	if config.MaxAttempts < 1 || config.RetryUnit <= 0 || config.RetryCap < config.RetryUnit {
+		return fmt.Errorf("at least 1 attempt is required, with a positive retry unit not above the retry cap")
+	}
+	if config.PageSize < 1 || config.PageSize > 1000 {
+		return fmt.Errorf("page size must be between 1 and 1000")
+	}
+	if config.ListRequestCost < 0 || config.GetRequestCost < 0 {
+		return fmt.Errorf("request costs can not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package walker
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/willena/s3-exporter/stats"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRequestAttempts checks that failing requests are attempted up to the maximum number of attempts of the
+// connection, the retries of the minio client included, and that each attempt is accounted
+func TestRequestAttempts(t *testing.T) {
+	var attempts, failures int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			writeFakeError(w, http.StatusServiceUnavailable, "SlowDown")
+			return
+		}
+		(&fakeS3{buckets: map[string]map[string]int64{"data": {}}}).ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	for _, test := range []struct {
+		name     string
+		failures int32
+		attempts int32
+		// throttled counts the attempts answered SlowDown
+		throttled uint64
+		err       bool
+	}{
+		{"recovered", 2, 3, 2, false},
+		{"exhausted", 10, 3, 3, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&attempts, 0)
+			atomic.StoreInt32(&failures, test.failures)
+			walker := newS3Walker(t, server.URL, "--s3.max-attempts", "3", "--s3.retry-unit", "1ms", "--s3.retry-cap", "2ms")
+			_, err := walker.client.ListBuckets(context.Background())
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if test.err && minio.ToErrorResponse(err).Code != "SlowDown" {
+				t.Errorf("expected the SlowDown error, got %v", err)
+			}
+			if count := atomic.LoadInt32(&attempts); count != test.attempts {
+				t.Errorf("expected %d attempts, got %d", test.attempts, count)
+			}
+
+			status := walker.Stats.(*stats.PrometheusStats).Status()
+			if throttled := status.Throttled["ListBuckets"]; throttled != test.throttled {
+				t.Errorf("expected %d throttled requests, got %d", test.throttled, throttled)
+			}
+			var accounted uint64
+			for _, count := range status.Requests["ListBuckets"] {
+				accounted += count
+			}
+			if accounted != uint64(test.attempts) {
+				t.Errorf("expected %d accounted requests, got %v", test.attempts, status.Requests)
+			}
+		})
+	}
+}
+
+// TestRegionRedirect checks that the client discovering bucket regions follows the region of the error responses
+func TestRegionRedirect(t *testing.T) {
+	var regions []string
+	var mutex sync.Mutex
+	fake := &fakeS3{buckets: map[string]map[string]int64{"data": {}}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		authorization := r.Header.Get("Authorization")
+		regions = append(regions, strings.Split(authorization, "/")[2])
+		if !strings.Contains(authorization, "/eu-west-3/s3/") {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprint(w, `<Error><Code>AuthorizationHeaderMalformed</Code><Region>eu-west-3</Region></Error>`)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	locator, err := newS3Walker(t, server.URL).clientFor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locator.ListBuckets(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(regions) != 2 || regions[1] != "eu-west-3" {
+		t.Errorf("expected the request to be signed again for eu-west-3, got regions %v", regions)
+	}
+}
+
+// TestRequestOperations checks that the requests of walks are accounted per operation
+func TestRequestOperations(t *testing.T) {
+	fake, endpoint := newFakeS3(t, map[string]map[string]int64{"data": fakeBucket(20), "logs": fakeBucket(3)})
+	walker := newS3Walker(t, endpoint, "--s3.page-size", "5", "--s3.discover-regions")
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	status := walker.Stats.(*stats.PrometheusStats).Status()
+	for operation, expected := range map[string]uint64{
+		"ListBuckets":       1,
+		"GetBucketLocation": 2,
+		"ListObjectsV2":     uint64(len(fake.requests())),
+	} {
+		if count := status.Requests[operation]["200"]; count != expected {
+			t.Errorf("expected %d %s requests, got %d", expected, operation, count)
+		}
+	}
+	if len(status.Requests) != 3 {
+		t.Errorf("unexpected operations %v", status.Requests)
+	}
+	if snapshot := walker.Stats.(*stats.PrometheusStats).Snapshot(); snapshot.Requests["ListObjectsV2"] != uint64(len(fake.requests())) {
+		t.Errorf("unexpected requests of the walk %v", snapshot.Requests)
+	}
+}
+
+// TestRequestConcurrency checks that the requests in flight are capped by the maximum number of requests
+func TestRequestConcurrency(t *testing.T) {
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			observed := atomic.LoadInt32(&maxInFlight)
+			if current <= observed || atomic.CompareAndSwapInt32(&maxInFlight, observed, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		writeFakeError(w, http.StatusNotFound, "NoSuchKey")
+	}))
+	t.Cleanup(server.Close)
+
+	walker := newS3Walker(t, server.URL, "--s3.max-requests", "2")
+	var group sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		group.Add(1)
+		go func(i int) {
+			defer group.Done()
+			_, _ = walker.client.StatObject(context.Background(), "data", fmt.Sprintf("%d.txt", i), minio.StatObjectOptions{})
+		}(i)
+	}
+	group.Wait()
+
+	if observed := atomic.LoadInt32(&maxInFlight); observed != 2 {
+		t.Errorf("expected at most 2 requests in flight, got %d", observed)
+	}
+	if count := walker.Stats.(*stats.PrometheusStats).Status().Requests["HeadObject"]["404"]; count != 8 {
+		t.Errorf("expected 8 HeadObject requests, got %d", count)
+	}
+}
@@ -0,0 +1,46 @@
+package walker
+
+import (
+	"context"
+	"github.com/minio/minio-go/v7"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// objectLockEnabled tells whether object lock is enabled on bucket. When the configuration can not be read,
+// the bucket is considered enabled so that the retention of its objects is still read.
+func (s *S3Walker) objectLockEnabled(ctx context.Context, bucket *s3Bucket) bool {
+	enabled, _, _, _, err := bucket.client.GetObjectLockConfig(ctx, bucket.Name)
+	if err == nil {
+		return enabled == "Enabled"
+	}
+	code := minio.ToErrorResponse(err).Code
+	if notConfiguredCodes[code] || code == "NotImplemented" {
+		return false
+	}
+	if ctx.Err() == nil {
+		log.Warningf("Could not read object lock configuration of bucket %s, reading the retention of its objects anyway: %s", bucket.Name, err.Error())
+	}
+	return true
+}
+
+// objectRetention extracts the object lock retention and legal hold of an object from its headers
+func objectRetention(header http.Header) (mode string, retainUntil time.Time, legalHold bool) {
+	mode = header.Get("X-Amz-Object-Lock-Mode")
+	if mode != "" {
+		retainUntil, _ = time.Parse(time.RFC3339, header.Get("X-Amz-Object-Lock-Retain-Until-Date"))
+	}
+	return mode, retainUntil, header.Get("X-Amz-Object-Lock-Legal-Hold") == "ON"
+}
+
+// retentionSample returns the read of the retention of a noncurrent version, nil unless the bucket is object lock
+// enabled
+func (s *S3Walker) retentionSample(bucket *s3Bucket, version minio.ObjectInfo, labels map[string]string) func(ctx context.Context) {
+	if !bucket.objectLock {
+		return nil
+	}
+	return func(ctx context.Context) {
+		s.headObject(ctx, bucket, version.Key, version.VersionID, version.Size, false, labels)
+	}
+}
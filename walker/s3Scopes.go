@@ -0,0 +1,79 @@
+package walker
+
+import (
+	"context"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	log "github.com/sirupsen/logrus"
+	"github.com/willena/s3-exporter/utils"
+	"strings"
+	"time"
+)
+
+// s3Scope is a part of a bucket to walk: the objects under prefix, or the whole bucket when prefix is empty
+type s3Scope struct {
+	minio.BucketInfo
+	// prefix ends with a '/' unless empty
+	prefix string
+}
+
+// parseScopes reads the bucket and the bucket[/prefix] scopes of config
+func parseScopes(config *S3WalkerConfig) ([]s3Scope, error) {
+	values := config.Scopes
+	if config.Bucket != "" {
+		values = append([]string{config.Bucket}, values...)
+	}
+
+	scopes := make([]s3Scope, 0, len(values))
+	for _, value := range values {
+		parts := strings.SplitN(value, "/", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("invalid scope %q: the bucket is missing", value)
+		}
+		scope := s3Scope{BucketInfo: minio.BucketInfo{Name: parts[0]}}
+		if len(parts) == 2 && strings.Trim(parts[1], "/") != "" {
+			scope.prefix = strings.Trim(parts[1], "/") + "/"
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+// resolveScopes returns the scopes to walk: the configured ones or, without any, every bucket not excluded.
+// Configured scopes are walked without listing buckets, unless the creation date of their buckets is exported with
+// their configuration; it is then read from ListBuckets when it succeeds.
+func (s *S3Walker) resolveScopes(ctx context.Context) ([]s3Scope, error) {
+	if len(s.scopes) == 0 {
+		buckets, err := s.client.ListBuckets(ctx)
+		if err != nil {
+			log.Errorf("Could not list buckets: %s", err)
+			return nil, err
+		}
+		scopes := make([]s3Scope, 0, len(buckets))
+		for _, bucket := range buckets {
+			if utils.MatchExclude(s.bucketPatterns, bucket.Name) {
+				log.Infof("Bucket %s excluded !", bucket.Name)
+				continue
+			}
+			scopes = append(scopes, s3Scope{BucketInfo: bucket})
+		}
+		return scopes, nil
+	}
+
+	created := map[string]time.Time{}
+	if s.config.BucketConfig {
+		buckets, err := s.client.ListBuckets(ctx)
+		if err != nil {
+			log.Debugf("Could not list buckets, creation dates of the configured scopes are unknown: %s", err)
+		}
+		for _, bucket := range buckets {
+			created[bucket.Name] = bucket.CreationDate
+		}
+	}
+	scopes := make([]s3Scope, 0, len(s.scopes))
+	for _, scope := range s.scopes {
+		scope.CreationDate = created[scope.Name]
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
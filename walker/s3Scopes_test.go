@@ -0,0 +1,67 @@
+package walker
+
+import (
+	"context"
+	"github.com/willena/s3-exporter/stats"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// TestScopesWithoutListBuckets checks that configured scopes are walked when ListBuckets is denied, while walks of
+// all the buckets fail
+func TestScopesWithoutListBuckets(t *testing.T) {
+	var listBuckets int32
+	fake := &fakeS3{buckets: map[string]map[string]int64{"data": fakeBucket(40), "logs": fakeBucket(4)}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			atomic.AddInt32(&listBuckets, 1)
+			writeFakeError(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	for _, test := range []struct {
+		name        string
+		args        []string
+		listBuckets int32
+	}{
+		{"scopes", nil, 0},
+		// The creation dates of the buckets exported with their configuration are unknown
+		{"scopes with bucket configuration", []string{"--s3.bucket-config"}, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			atomic.StoreInt32(&listBuckets, 0)
+			walker := newS3Walker(t, server.URL, append(test.args, "--s3.scope", "data/docs", "--s3.scope", "logs")...)
+			if err := walker.Walk(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if count := atomic.LoadInt32(&listBuckets); count != test.listBuckets {
+				t.Errorf("expected %d ListBuckets requests, got %d", test.listBuckets, count)
+			}
+
+			snapshot := walker.Stats.(*stats.PrometheusStats).Snapshot()
+			if snapshot.Partial {
+				t.Errorf("the walk of the scopes is partial: %v", snapshot.Errors)
+			}
+			for scope, expected := range map[string]uint64{"docs/": 20, "": 4} {
+				bucket := "data"
+				if scope == "" {
+					bucket = "logs"
+				}
+				series := snapshot.Series[stats.SeriesKey(map[string]string{"bucket": bucket, "scope": scope, "region": "us-east-1", "storageClass": "STANDARD"})]
+				if series == nil || series.Objects != expected {
+					t.Errorf("expected %d objects in scope %s/%s, got %+v", expected, bucket, scope, series)
+				}
+			}
+		})
+	}
+
+	walker := newS3Walker(t, server.URL)
+	if err := walker.Walk(context.Background()); err == nil {
+		t.Error("expected the walk of all the buckets to fail without ListBuckets")
+	}
+}
@@ -0,0 +1,29 @@
+package walker
+
+import (
+	"context"
+	"github.com/minio/minio-go/v7"
+	"github.com/willena/s3-exporter/stats"
+)
+
+// readTags reads the tags of the object and accounts the values of the allowed tag keys, empty when the object
+// does not have the tag
+func (s *S3Walker) readTags(ctx context.Context, bucket *s3Bucket, key string, size int64, labels map[string]string) {
+	if err := s.tagsLimiter.Wait(ctx); err != nil {
+		return
+	}
+	objectTags, err := bucket.client.GetObjectTagging(ctx, bucket.Name, key, minio.GetObjectTaggingOptions{})
+	if err != nil {
+		s.sampleError(ctx, bucket, key, FeatureTags, err)
+		return
+	}
+
+	all := objectTags.ToMap()
+	tags := make(map[string]string, len(s.config.TagKeys))
+	for _, tagKey := range s.config.TagKeys {
+		tags[tagKey] = all[tagKey]
+	}
+	_ = s.accountBucket(bucket, func(processor stats.FileProcessor) error {
+		return s.processTagsTo(ctx, processor, bucket.prefix, key, size, tags, s.baseWalker.config.Depth, labels)
+	})
+}
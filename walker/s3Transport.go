@@ -0,0 +1,103 @@
+package walker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// createTransport builds the HTTP transport of the S3 clients from the TLS, proxy, timeouts and pool options.
+// Unset options keep the values of the minio client default transport.
+func (c *s3Connection) createTransport() (*http.Transport, error) {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if c.config.Proxy != "" {
+		proxyURL, err := url.Parse(c.config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   c.config.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          c.config.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       c.config.MaxConnsPerHost,
+		ResponseHeaderTimeout: c.config.ReadTimeout,
+		IdleConnTimeout:       time.Minute,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 10 * time.Second,
+		// Objects are never read, but keep listings and errors as sent by the server
+		DisableCompression: true,
+	}, nil
+}
+
+// tlsConfig trusts the CA bundle in addition to the system authorities and presents the client certificate, if any
+func (c *s3Connection) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tlsVersions[c.config.TLSMinVersion],
+		InsecureSkipVerify: c.config.TLSSkipVerify,
+	}
+
+	if c.config.CACert != "" {
+		content, err := ioutil.ReadFile(c.config.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle: %w", err)
+		}
+		config.RootCAs, err = x509.SystemCertPool()
+		if err != nil {
+			config.RootCAs = x509.NewCertPool()
+		}
+		if !config.RootCAs.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificate found in CA bundle %s", c.config.CACert)
+		}
+	}
+
+	if c.config.ClientCert != "" {
+		certificate, err := tls.LoadX509KeyPair(c.config.ClientCert, c.config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// validateTransport checks the options of the transport
+func validateTransport(config *S3Connection) error {
+	if _, ok := tlsVersions[config.TLSMinVersion]; !ok {
+		return fmt.Errorf("unknown TLS version %q", config.TLSMinVersion)
+	}
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		return fmt.Errorf("client certificate and key must be given together")
+	}
+	if config.ConnectTimeout < 0 || config.ReadTimeout < 0 {
+		return fmt.Errorf("timeouts can not be negative")
+	}
+	if config.MaxIdleConns < 0 || config.MaxIdleConnsPerHost < 0 || config.MaxConnsPerHost < 0 {
+		return fmt.Errorf("connection pool sizes can not be negative")
+	}
+	return nil
+}
@@ -2,12 +2,15 @@ package walker
 
 import (
 	"context"
+	"fmt"
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	log "github.com/sirupsen/logrus"
+	"github.com/willena/s3-exporter/stats"
 	"github.com/willena/s3-exporter/utils"
-	"net/url"
 	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type S3WalkerConfig struct {
@@ -16,19 +19,75 @@ type S3WalkerConfig struct {
 }
 
 type S3Configuration struct {
-	Endpoint        string `long:"endpoint" description:"URL to the S3" required:"false" env:"ENDPOINT"`
-	Bucket          string `long:"bucket" description:"S3 bucket" required:"false" env:"BUCKET"`
-	AccessKey       string `long:"access-key" description:"S3 Storage Access Key" required:"false" env:"ACCESS_KEY"`
-	SecretKey       string `long:"secret-key" description:"S3 Storage Secret Key" required:"false" env:"SECRET_KEY"`
-	Region          string `long:"region" description:"S3 Storage Region" required:"false" env:"REGION" default:"us-west"`
-	BucketPathStyle bool   `long:"bucket-path-style" description:"Bucket type" required:"false" env:"BUCKET_PATH_STYLE"`
+	S3Connection
+
+	Bucket          string   `long:"bucket" description:"S3 bucket" required:"false" env:"BUCKET"`
+	DiscoverRegions bool     `long:"discover-regions" description:"Read the region of each bucket with GetBucketLocation and sign its requests for that region; the configured region remains used for ListBuckets and as fallback" required:"false" env:"DISCOVER_REGIONS"`
+	Scopes          []string `long:"scope" description:"Bucket or bucket/prefix to walk instead of all the buckets; prefixes are grouped from the scope prefix" required:"false" env:"SCOPES" env-delim:","`
+
+	BucketConcurrency int `long:"bucket-concurrency" description:"Number of buckets listed in parallel" required:"false" env:"BUCKET_CONCURRENCY" default:"1"`
+	ListWorkers       int `long:"list-workers" description:"Number of parallel listers per bucket; above 1 buckets are split in shards by prefix" required:"false" env:"LIST_WORKERS" default:"1"`
+	ShardDepth        int `long:"shard-depth" description:"Number of '/' levels discovered with delimiter listings to split buckets in shards" required:"false" env:"SHARD_DEPTH" default:"1"`
+
+	CheckpointFile     string        `long:"checkpoint-file" description:"State file used to resume interrupted walks; each target needs its own file" required:"false" env:"CHECKPOINT_FILE"`
+	CheckpointInterval time.Duration `long:"checkpoint-interval" description:"Minimum delay between checkpoint saves" required:"false" env:"CHECKPOINT_INTERVAL" default:"1m"`
+
+	BucketConfig      bool `long:"bucket-config" description:"Export the configuration of buckets: versioning, object lock, encryption, lifecycle, replication, notifications and policy" required:"false" env:"BUCKET_CONFIG"`
+	Versions          bool `long:"versions" description:"List all object versions to account noncurrent versions and delete markers" required:"false" env:"VERSIONS"`
+	IncompleteUploads bool `long:"incomplete-uploads" description:"Account incomplete multipart uploads; their parts are listed to compute the uploaded size" required:"false" env:"INCOMPLETE_UPLOADS"`
+	ObjectLock        bool `long:"object-lock" description:"Read the retention and legal hold of all the objects of object lock enabled buckets, with HEAD requests" required:"false" env:"OBJECT_LOCK"`
+
+	Metadata            string   `long:"metadata" description:"Read objects metadata (content type, encryption, replication status, user metadata) from listings (MinIO only) or from HEAD requests" required:"false" env:"METADATA" default:"none" choice:"none" choice:"list" choice:"head"`
+	MetadataWorkers     int      `long:"metadata-workers" description:"Number of objects whose metadata or tags are read in parallel per bucket" required:"false" env:"METADATA_WORKERS" default:"8"`
+	MetadataSampleRatio float64  `long:"metadata-sample-ratio" description:"Ratio of objects whose metadata (with HEAD requests) and tags are read; breakdowns are estimated from the sample" required:"false" env:"METADATA_SAMPLE_RATIO" default:"1"`
+	MetadataBudget      int64    `long:"metadata-budget" description:"Maximum number of objects whose metadata (with HEAD requests) and tags are read per walk. 0 disables the limit" required:"false" env:"METADATA_BUDGET" default:"0"`
+	TagKeys             []string `long:"tag-keys" description:"Read the tags of the sampled objects and aggregate the values of these tag keys; other tags are dropped" required:"false" env:"TAG_KEYS" env-delim:","`
+	TagsRate            float64  `long:"tags-rate" description:"Maximum number of object tagging requests per second. 0 disables the limit" required:"false" env:"TAGS_RATE" default:"0"`
+}
+
+func init() {
+	Register(Registration{
+		Name:        "s3",
+		Description: "S3 walker configuration",
+		NewConfig:   func() interface{} { return &S3WalkerConfig{} },
+		New:         func() Walker { return &S3Walker{} },
+	})
 }
 
 type S3Walker struct {
 	baseWalker
+	*s3Connection
 	config         *S3WalkerConfig
-	client         *minio.Client
 	bucketPatterns []*regexp.Regexp
+	scopes         []s3Scope
+	checkpoint     *s3Checkpointer
+	tagsLimiter    *utils.RateLimiter
+	// sampledObjects counts the objects whose metadata or tags were read during the current walk
+	sampledObjects int64
+}
+
+// s3Bucket is a bucket being walked, or the scope of a bucket
+type s3Bucket struct {
+	minio.BucketInfo
+	// prefix is the base of the keys walked, ending with a '/' unless the whole bucket is walked
+	prefix string
+	region string
+	// client signs requests for the region of the bucket
+	client *minio.Client
+	// samples runs the requests reading the metadata, tags and retention of objects
+	samples *utils.TaskPool
+	// objectLock is set when the retention of the objects is read
+	objectLock bool
+}
+
+// labels returns the labels of the series of the objects of the bucket stored in storageClass
+func (b *s3Bucket) labels(storageClass string) map[string]string {
+	return map[string]string{"bucket": b.Name, "scope": b.prefix, "region": b.region, "storageClass": storageClass}
+}
+
+// id identifies the walked scope of the bucket in checkpoints
+func (b *s3Bucket) id() string {
+	return b.Name + "/" + b.prefix
 }
 
 func (s *S3Walker) Init(config Config, labels map[string]string, _ []string) error {
@@ -36,125 +95,452 @@ func (s *S3Walker) Init(config Config, labels map[string]string, _ []string) err
 	if err != nil {
 		return err
 	}
-	s.config = config.S3WalkerConfig
-	s.client = s.createClient()
-
-	s.bucketPatterns = utils.BuildPatternsFromStrings(s.config.BucketFilters)
+	s.config = config.Options.(*S3WalkerConfig)
+	s.bucketPatterns, err = utils.BuildPatternsFromStrings(s.config.BucketFilters)
+	if err != nil {
+		return err
+	}
+	s.tagsLimiter = utils.NewRateLimiter(s.config.TagsRate)
+	s.scopes, err = parseScopes(s.config)
+	if err != nil {
+		return err
+	}
 
-	return s.baseWalker.Init(config,
+	err = s.baseWalker.Init(config,
 		utils.MergeMapsRight(map[string]string{
 			"type":       "s3Walker",
 			"s3Endpoint": s.config.Endpoint,
-		}, labels), []string{"bucket", "storageClass"})
+		}, labels), []string{"bucket", "scope", "region", "storageClass"})
+	if err != nil {
+		return err
+	}
+	s.s3Connection, err = newS3Connection(&s.config.S3Connection, s.Stats)
+	return err
+}
+
+func (s *S3Walker) Walk(ctx context.Context) error {
+	return s.runWalk(ctx, s.walkBuckets)
+}
+
+func (s *S3Walker) walkBuckets(ctx context.Context) error {
+	atomic.StoreInt64(&s.sampledObjects, 0)
+	if s.config.CheckpointFile == "" {
+		return s.walkAllBuckets(ctx)
+	}
+
+	s.checkpoint = loadCheckpoint(s.config.CheckpointFile, s.config.CheckpointInterval, s.checkpointFingerprint(), s.newSnapshot)
+	defer func() {
+		s.checkpoint = nil
+	}()
+	// Errors met before the walk was interrupted keep it partial
+	s.Stats.Merge(s.checkpoint.errorsSnapshot())
+
+	err := s.walkAllBuckets(ctx)
+	if err == nil && ctx.Err() == nil {
+		s.checkpoint.clear()
+	} else {
+		s.checkpoint.flush()
+	}
+	return err
 }
 
-func (s *S3Walker) createClient() *minio.Client {
-	// Initialize minio client object.
-	uri, err := url.ParseRequestURI(s.config.Endpoint)
+// checkpointFingerprint identifies the options a checkpoint must have been built with to be resumed
+func (s *S3Walker) checkpointFingerprint() string {
+	base := s.baseWalker.config
+	return checkpointFingerprint(
+		base.Depth, base.BinNumber, base.BinStart, base.BinIncrementFactor, base.PrefixFilters, base.AgeBuckets,
+		s.config.Endpoint, s.config.Region, s.config.DiscoverRegions, s.config.Bucket, s.config.Scopes, s.config.BucketFilters, s.config.ListWorkers > 1, s.config.ShardDepth,
+		s.config.Versions, s.config.IncompleteUploads, s.config.Metadata != metadataNone, s.config.TagKeys, s.config.ObjectLock,
+	)
+}
+
+func (s *S3Walker) walkAllBuckets(ctx context.Context) error {
+	scopes, err := s.resolveScopes(ctx)
 	if err != nil {
-		log.Fatalln("Could not read S3 url")
+		return err
 	}
 
-	bucketType := minio.BucketLookupDNS
-	if s.config.BucketPathStyle {
-		bucketType = minio.BucketLookupPath
+	pool := utils.NewTaskPool(ctx, s.config.BucketConcurrency)
+	for i := range scopes {
+		scope := scopes[i]
+		pool.Submit(func(ctx context.Context) {
+			_ = s.walkBucket(ctx, scope)
+		})
 	}
+	pool.Wait()
+	return ctx.Err()
+}
 
-	minioClient, err := minio.New(uri.Host, &minio.Options{
-		Region:       s.config.Region,
-		Creds:        credentials.NewStaticV4(s.config.AccessKey, s.config.SecretKey, ""),
-		Secure:       uri.Scheme == "https",
-		BucketLookup: bucketType,
-	})
+func (s *S3Walker) ValidateConfig(config Config) error {
+	s3Config, ok := config.Options.(*S3WalkerConfig)
+	if !ok {
+		return fmt.Errorf("S3 walker options are missing")
+	}
+	if s3Config.BucketConcurrency < 1 || s3Config.ListWorkers < 1 || s3Config.ShardDepth < 1 || s3Config.MetadataWorkers < 1 {
+		return fmt.Errorf("bucket concurrency, list workers, shard depth and metadata workers must be at least 1")
+	}
+	if s3Config.MetadataSampleRatio <= 0 || s3Config.MetadataSampleRatio > 1 || s3Config.TagsRate < 0 {
+		return fmt.Errorf("metadata sample ratio must be in ]0, 1] and tags rate can not be negative")
+	}
+	if err := validateConnection(&s3Config.S3Connection); err != nil {
+		return err
+	}
+	if _, err := parseScopes(s3Config); err != nil {
+		return err
+	}
+	if s3Config.Metadata == metadataList && s3Config.Versions {
+		return fmt.Errorf("metadata can not be listed along with versions, use HEAD requests instead")
+	}
+	return nil
+}
 
+func (s *S3Walker) walkBucket(ctx context.Context, scope s3Scope) error {
+	bucket, err := s.openBucket(ctx, scope)
 	if err != nil {
-		log.Fatalln(err)
+		log.Warningf("Could not walk bucket %s: %s", scope.Name, err.Error())
+		s.recordBucketError(scope.Name, err)
+		return err
+	}
+	bucket.samples = utils.NewTaskPool(ctx, s.config.MetadataWorkers)
+	if s.config.BucketConfig {
+		// Always read again, even for buckets resumed from a checkpoint
+		s.Stats.RecordBucket(bucket.Name, s.bucketConfig(ctx, bucket))
+	}
+
+	if s.checkpoint != nil {
+		if snapshot, done := s.checkpoint.bucketDone(bucket.id()); done {
+			log.Infof("Bucket %s was already walked, using checkpoint", bucket.id())
+			s.Stats.Merge(snapshot)
+			return nil
+		}
+	}
+	bucket.objectLock = s.config.ObjectLock && s.objectLockEnabled(ctx, bucket)
+
+	err = s.findObjects(ctx, bucket)
+	bucket.samples.Wait()
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err == nil && s.config.IncompleteUploads {
+		err = s.findIncompleteUploads(ctx, bucket)
+	}
+
+	if s.checkpoint != nil && err == nil {
+		s.Stats.Merge(s.checkpoint.completeBucket(bucket.id()))
 	}
 
-	return minioClient
+	log.Debug("Done listing objects for bucket ", bucket.id())
+	return err
 }
 
-func (s *S3Walker) Walk() error {
-	if s.blockFlag {
-		return nil
+// findObjects lists all the objects of the scope of bucket, either with a single recursive listing or, with several
+// list workers, by splitting the scope in shards discovered with delimiter listings
+func (s *S3Walker) findObjects(ctx context.Context, bucket *s3Bucket) error {
+	if s.config.ListWorkers <= 1 {
+		return s.listObjects(ctx, bucket, bucket.prefix, true, nil)
 	}
-	s.blockFlag = true
 
-	s.Stats.Reset()
-	s.startProcessing()
-	buckets, err := s.client.ListBuckets(context.Background())
+	pool := utils.NewTaskPool(ctx, s.config.ListWorkers)
+	pool.Submit(func(ctx context.Context) {
+		s.discoverShards(ctx, pool, bucket, bucket.prefix, 0)
+	})
+	pool.Wait()
+	return ctx.Err()
+}
+
+// discoverShards processes the objects directly under prefix and submits its sub-prefixes to pool:
+// they are discovered further until the shard depth is reached, then listed recursively
+func (s *S3Walker) discoverShards(ctx context.Context, pool *utils.TaskPool, bucket *s3Bucket, prefix string, level int) {
+	_ = s.listObjects(ctx, bucket, prefix, false, func(subPrefix string) {
+		if level+1 < s.config.ShardDepth {
+			pool.Submit(func(ctx context.Context) {
+				s.discoverShards(ctx, pool, bucket, subPrefix, level+1)
+			})
+			return
+		}
+		pool.Submit(func(ctx context.Context) {
+			log.Debugf("Listing shard %s of bucket %s", subPrefix, bucket.Name)
+			_ = s.listObjects(ctx, bucket, subPrefix, true, nil)
+		})
+	})
+}
+
+// listObjects processes the objects under prefix. Non recursive listings hand the common prefixes over to onPrefix.
+// With checkpoints, listings resume after the last key they processed.
+func (s *S3Walker) listObjects(ctx context.Context, bucket *s3Bucket, prefix string, recursive bool, onPrefix func(prefix string)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	options := minio.ListObjectsOptions{
+		Prefix:       prefix,
+		Recursive:    recursive,
+		WithVersions: s.config.Versions,
+		WithMetadata: s.config.Metadata == metadataList,
+		MaxKeys:      s.config.PageSize,
+	}
+	listing := listingID(prefix, recursive)
+	var progress listingCheckpoint
+	if s.checkpoint != nil {
+		progress = s.checkpoint.listing(bucket.id(), listing)
+		if progress.Done && recursive {
+			return nil
+		}
+		if recursive {
+			// Ignored by versions listings, which start over and skip the keys already processed
+			options.StartAfter = progress.LastKey
+		}
+	}
 
-	if s.config.Bucket == "" {
+	// versions holds the versions of the key being listed, newest first; they are processed together
+	// so that checkpoints never stop in the middle of a key
+	var versions []minio.ObjectInfo
+	objectCh := bucket.client.ListObjects(ctx, bucket.Name, options)
+	for object := range objectCh {
+		if object.Err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warningf("Could not list objects of bucket %s: %s", bucket.Name, object.Err.Error())
+			s.recordBucketError(bucket.Name, object.Err)
+			continue
+		}
+		if !recursive && isCommonPrefix(object) {
+			onPrefix(object.Key)
+			continue
+		}
+		if progress.Done || (progress.LastKey != "" && object.Key <= progress.LastKey) {
+			// Already processed before the walk was interrupted
+			continue
+		}
+
+		if !s.config.Versions {
+			if err := s.processObject(ctx, bucket, listing, object); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(versions) > 0 && versions[0].Key != object.Key {
+			if err := s.processVersions(ctx, bucket, listing, versions); err != nil {
+				return err
+			}
+			versions = versions[:0]
+		}
+		versions = append(versions, object)
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(versions) > 0 {
+		if err := s.processVersions(ctx, bucket, listing, versions); err != nil {
+			return err
+		}
+	}
+	if s.checkpoint != nil {
+		s.checkpoint.completeListing(bucket.id(), listing)
+	}
+	return nil
+}
+
+func (s *S3Walker) processObject(ctx context.Context, bucket *s3Bucket, listing string, object minio.ObjectInfo) error {
+	labels := bucket.labels(object.StorageClass)
+	samples := withSample(nil, s.objectSample(bucket, object.Key, object.Size, labels))
+	if s.config.Metadata != metadataList {
+		return s.account(bucket, listing, object.Key, samples, func(processor stats.FileProcessor) error {
+			return s.processFileTo(ctx, processor, bucket.prefix, object.Key, object.Size, s.baseWalker.config.Depth, object.ContentType, object.LastModified, labels)
+		})
+	}
+
+	metadata := listedMetadata(object)
+	return s.account(bucket, listing, object.Key, samples, func(processor stats.FileProcessor) error {
+		err := s.processFileTo(ctx, processor, bucket.prefix, object.Key, object.Size, s.baseWalker.config.Depth, metadata.ContentType, object.LastModified, labels)
 		if err != nil {
-			log.Errorf("Could not list buckets: %s", err)
+			return err
+		}
+		return s.processMetadataTo(ctx, processor, bucket.prefix, object.Key, object.Size, metadata, s.baseWalker.config.Depth, labels)
+	})
+}
+
+// processVersions accounts all the versions of a key, newest first. The current version is also accounted as an object.
+func (s *S3Walker) processVersions(ctx context.Context, bucket *s3Bucket, listing string, versions []minio.ObjectInfo) error {
+	key := versions[0].Key
+	var samples []func(ctx context.Context)
+	for _, version := range versions {
+		labels := bucket.labels(version.StorageClass)
+		switch {
+		case version.IsDeleteMarker:
+		case version.IsLatest:
+			samples = withSample(samples, s.objectSample(bucket, key, version.Size, labels))
+		default:
+			samples = withSample(samples, s.retentionSample(bucket, version, labels))
 		}
+	}
+
+	return s.account(bucket, listing, key, samples, func(processor stats.FileProcessor) error {
+		for i, version := range versions {
+			labels := bucket.labels(version.StorageClass)
 
-		for i := range buckets {
-			if utils.MatchExclude(s.bucketPatterns, buckets[i].Name) {
-				log.Infof("Bucket %s excluded !", buckets[i].Name)
-				continue
+			var replaced time.Time
+			state := stats.VersionNoncurrent
+			switch {
+			case version.IsDeleteMarker:
+				state = stats.VersionDeleteMarker
+			case version.IsLatest:
+				state = stats.VersionCurrent
+			case i > 0:
+				replaced = versions[i-1].LastModified
+			}
+
+			if err := s.processVersionTo(ctx, processor, bucket.prefix, key, state, version.Size, replaced, s.baseWalker.config.Depth, labels); err != nil {
+				return err
+			}
+			if state == stats.VersionCurrent {
+				if err := s.processFileTo(ctx, processor, bucket.prefix, key, version.Size, s.baseWalker.config.Depth, version.ContentType, version.LastModified, labels); err != nil {
+					return err
+				}
 			}
-			s.walkBucket(context.Background(), buckets[i])
 		}
-	} else {
-		s.walkBucket(context.Background(), minio.BucketInfo{Name: s.config.Bucket})
+		return nil
+	})
+}
+
+// withSample appends sample to samples unless it is nil
+func withSample(samples []func(ctx context.Context), sample func(ctx context.Context)) []func(ctx context.Context) {
+	if sample == nil {
+		return samples
 	}
+	return append(samples, sample)
+}
 
-	s.endProcessing()
-	s.blockFlag = false
+// recordBucketError accounts err in the walk errors and in the errors of bucket. With checkpoints, the error is
+// saved along with the walk state so that the resumed walk is partial too.
+func (s *S3Walker) recordBucketError(bucket string, err error) {
+	class := classifyError(err)
+	s.Stats.RecordBucketError(bucket, class)
+	if s.checkpoint != nil {
+		s.checkpoint.recordError(class)
+	}
+}
 
-	return err
+// accountBucket runs process on the stats, or on the checkpoint of bucket without changing the progress of its listings
+func (s *S3Walker) accountBucket(bucket *s3Bucket, process func(processor stats.FileProcessor) error) error {
+	if s.checkpoint != nil {
+		return s.checkpoint.account(bucket.id(), process)
+	}
+	return process(s.Stats)
 }
 
-func (s *S3Walker) ValidateConfig(Config) error {
+// account runs process on the stats, or through the checkpoint which records key as the last one processed by listing,
+// then runs the samples of key in the pool of the bucket, blocking the listing when all the workers are busy.
+// Checkpoints are only saved once the samples of the keys they record were accounted.
+func (s *S3Walker) account(bucket *s3Bucket, listing string, key string, samples []func(ctx context.Context), process func(processor stats.FileProcessor) error) error {
+	checkpoint := s.checkpoint
+	if checkpoint == nil {
+		if err := process(s.Stats); err != nil {
+			return err
+		}
+		for _, sample := range samples {
+			bucket.samples.SubmitWait(sample)
+		}
+		return nil
+	}
+
+	if err := checkpoint.process(bucket.id(), listing, key, len(samples), process); err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		sample := sample
+		if !bucket.samples.SubmitWait(func(ctx context.Context) {
+			sample(ctx)
+			// Samples interrupted by the end of the walk may not have been accounted
+			checkpoint.sampleDone(ctx.Err() != nil)
+		}) {
+			checkpoint.sampleDone(true)
+		}
+	}
 	return nil
 }
 
-func (s *S3Walker) walkBucket(context_bg context.Context, bucket minio.BucketInfo) {
+// uploadsListingID identifies the incomplete uploads listing in checkpoints
+const uploadsListingID = "uploads"
 
-	//ctxWithWait := context.WithValue(context_bg, "group", wait)
-	s.findObjects(context_bg, bucket)
-	//s.findIncompleteupload(ctxWithWait, bucket)
+// listingID identifies a listing in checkpoints
+func listingID(prefix string, recursive bool) string {
+	if recursive {
+		return "list:" + prefix
+	}
+	return "discover:" + prefix
+}
 
-	log.Debug("Done listing objects for bucket ", bucket.Name)
+// isCommonPrefix tells whether object is a common prefix returned by a delimiter listing, rather than an actual object
+func isCommonPrefix(object minio.ObjectInfo) bool {
+	return strings.HasSuffix(object.Key, "/") && object.ETag == "" && object.LastModified.IsZero()
 }
 
-func (s *S3Walker) findObjects(context_bg context.Context, bucket minio.BucketInfo) {
-	//wait := context_bg.Value("group").(*sync.WaitGroup)
-	ctx, cancel := context.WithCancel(context_bg)
-	//defer wait.Done()
+// findIncompleteUploads accounts the incomplete multipart uploads of bucket. Uploads are aggregated apart and
+// only added to the results once the listing completed, so that an interrupted listing is simply started over.
+func (s *S3Walker) findIncompleteUploads(ctx context.Context, bucket *s3Bucket) error {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	objectCh := s.client.ListObjects(ctx, bucket.Name, minio.ListObjectsOptions{
-		Recursive: true,
-	})
-	for object := range objectCh {
-		if object.Err != nil {
-			log.Warning("Object warning", object.Err.Error())
-			continue
+	if s.checkpoint != nil && s.checkpoint.listing(bucket.id(), uploadsListingID).Done {
+		return nil
+	}
+
+	snapshot := s.newSnapshot()
+	uploadCh := bucket.client.ListIncompleteUploads(ctx, bucket.Name, bucket.prefix, true)
+	for upload := range uploadCh {
+		if upload.Err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warningf("Could not list incomplete uploads of bucket %s: %s", bucket.Name, upload.Err.Error())
+			s.recordBucketError(bucket.Name, upload.Err)
+			return nil
 		}
-		s.ProcessFile(bucket.Name,
-			object.Key, object.Size,
-			s.baseWalker.config.Depth,
-			object.ContentType,
-			map[string]string{"bucket": bucket.Name, "storageClass": object.StorageClass})
-	}
-}
-
-//
-//func (s *S3Walker) findIncompleteupload(context_bg context.Context, bucket minio.BucketInfo) {
-//	wait := context_bg.Value("group").(*sync.WaitGroup)
-//	ctx, cancel := context.WithCancel(context_bg)
-//	defer wait.Done()
-//	defer cancel()
-//
-//	objectCh := s.client.ListIncompleteUploads(ctx, bucket.Name, "", true)
-//	for object := range objectCh {
-//		if object.Err != nil {
-//			fmt.Println(object.Err)
-//			return
-//		}
-//		s.ProcessFile("", "/"+object.Key, object.Size, s.config.Depth, "")
-//	}
-//
-//}
+
+		size, err := s.uploadedSize(ctx, bucket, upload)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warningf("Could not list parts of upload %s of %s/%s: %s", upload.UploadID, bucket.Name, upload.Key, err.Error())
+			s.recordBucketError(bucket.Name, err)
+		}
+
+		labels := bucket.labels(upload.StorageClass)
+		if err := s.processUploadTo(ctx, snapshot, bucket.prefix, upload.Key, size, upload.Initiated, s.baseWalker.config.Depth, labels); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if s.checkpoint != nil {
+		s.checkpoint.mergeListing(bucket.id(), uploadsListingID, snapshot)
+	} else {
+		s.Stats.Merge(snapshot)
+	}
+	return nil
+}
+
+// uploadedSize returns the total size of the parts uploaded so far
+func (s *S3Walker) uploadedSize(ctx context.Context, bucket *s3Bucket, upload minio.ObjectMultipartInfo) (int64, error) {
+	core := minio.Core{Client: bucket.client}
+	var size int64
+	marker := 0
+	for {
+		result, err := core.ListObjectParts(ctx, bucket.Name, upload.Key, upload.UploadID, marker, s.config.PageSize)
+		if err != nil {
+			return size, err
+		}
+		for _, part := range result.ObjectParts {
+			size += part.Size
+		}
+		if !result.IsTruncated {
+			return size, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
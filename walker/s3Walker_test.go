@@ -0,0 +1,1031 @@
+package walker
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/jessevdk/go-flags"
+	"github.com/willena/s3-exporter/stats"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeS3 serves the ListBuckets, GetBucketLocation, ListObjectsV2, ListObjectVersions, ListMultipartUploads,
+// ListParts, HeadObject, GetObjectTagging and bucket configuration requests of path style clients from in-memory buckets
+type fakeS3 struct {
+	// buckets holds the sizes of the objects of each bucket, by key
+	buckets map[string]map[string]int64
+	// modified holds the modification dates of the objects of each bucket, by key, 2021-01-01 when missing
+	modified map[string]map[string]time.Time
+	// headers holds the headers answered to the HEAD requests of the objects of each bucket, by key, or by key and
+	// version ID joined by "?versionId=" for the requests of a version
+	headers map[string]map[string]http.Header
+	// tags holds the tags of the objects of each bucket, by key
+	tags map[string]map[string]map[string]string
+	// objectErrors holds the error codes answered to the requests of the objects of each bucket, by key
+	objectErrors map[string]map[string]string
+	// regions holds the location constraint of buckets, empty for us-east-1
+	regions map[string]string
+	// versions holds the versions listed in each bucket, by key then newest first
+	versions map[string][]fakeVersion
+	// uploads holds the incomplete multipart uploads of each bucket
+	uploads map[string][]fakeUpload
+	// configs holds the configuration documents of each bucket, by sub-resource
+	configs map[string]map[string]string
+	// configErrors holds the error codes answered to the configuration requests of each bucket, by sub-resource
+	configErrors map[string]map[string]string
+	// blocked is signaled when a listing request is held
+	blocked chan string
+
+	mutex sync.Mutex
+	// listings records the queries of the listing requests
+	listings []url.Values
+	// blockAfter holds the listing requests continuing after it, until they are cancelled
+	blockAfter string
+}
+
+// fakeUnconfigured holds the responses to the configuration requests of buckets without that configuration, by
+// sub-resource: an empty configuration document or an error code
+var fakeUnconfigured = map[string]string{
+	"versioning":   "<VersioningConfiguration/>",
+	"notification": "<NotificationConfiguration/>",
+	"object-lock":  "ObjectLockConfigurationNotFoundError",
+	"encryption":   "ServerSideEncryptionConfigurationNotFoundError",
+	"lifecycle":    "NoSuchLifecycleConfiguration",
+	"replication":  "ReplicationConfigurationNotFoundError",
+	"policy":       "NoSuchBucketPolicy",
+}
+
+type fakeListing struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Delimiter             string
+	MaxKeys               int
+	KeyCount              int
+	IsTruncated           bool
+	NextContinuationToken string        `xml:",omitempty"`
+	Contents              []fakeContent `xml:"Contents"`
+	CommonPrefixes        []fakePrefix  `xml:"CommonPrefixes"`
+}
+
+type fakeContent struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type fakePrefix struct {
+	Prefix string
+}
+
+// fakeVersion is a version of a key, or a delete marker
+type fakeVersion struct {
+	// XMLName is Version or DeleteMarker
+	XMLName      xml.Name
+	Key          string
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool
+	LastModified string
+	ETag         string `xml:",omitempty"`
+	Size         int64  `xml:",omitempty"`
+	StorageClass string `xml:",omitempty"`
+}
+
+type fakeVersionsListing struct {
+	XMLName             xml.Name `xml:"ListVersionsResult"`
+	Name                string
+	Prefix              string
+	KeyMarker           string
+	VersionIDMarker     string `xml:"VersionIdMarker"`
+	MaxKeys             int
+	IsTruncated         bool
+	NextKeyMarker       string `xml:",omitempty"`
+	NextVersionIDMarker string `xml:"NextVersionIdMarker,omitempty"`
+	Versions            []fakeVersion
+}
+
+// fakeUpload is an incomplete multipart upload, with the sizes of its parts
+type fakeUpload struct {
+	Key          string
+	UploadID     string `xml:"UploadId"`
+	Initiated    string
+	StorageClass string
+	parts        []int64
+}
+
+type fakeUploadsListing struct {
+	XMLName     xml.Name `xml:"ListMultipartUploadsResult"`
+	Bucket      string
+	Prefix      string
+	IsTruncated bool
+	Uploads     []fakeUpload `xml:"Upload"`
+}
+
+type fakePartsListing struct {
+	XMLName              xml.Name `xml:"ListPartsResult"`
+	Bucket               string
+	Key                  string
+	UploadID             string `xml:"UploadId"`
+	PartNumberMarker     int
+	NextPartNumberMarker int
+	MaxParts             int
+	IsTruncated          bool
+	Parts                []fakePart `xml:"Part"`
+}
+
+type fakePart struct {
+	PartNumber   int
+	LastModified string
+	ETag         string
+	Size         int64
+}
+
+func newFakeS3(t *testing.T, buckets map[string]map[string]int64) (*fakeS3, string) {
+	fake := &fakeS3{buckets: buckets, blocked: make(chan string, 1)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server.URL
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+	key := ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	query := r.URL.Query()
+	switch {
+	case bucket == "":
+		f.listBuckets(w)
+	case f.buckets[bucket] == nil:
+		writeFakeError(w, http.StatusNotFound, "NoSuchBucket")
+	case key != "" && query["uploadId"] != nil:
+		f.listParts(w, bucket, key, query)
+	case key != "" && f.objectErrors[bucket][key] != "":
+		writeFakeError(w, fakeErrorStatus(f.objectErrors[bucket][key]), f.objectErrors[bucket][key])
+	case key != "" && r.Method == http.MethodHead:
+		f.headObject(w, bucket, key, query.Get("versionId"))
+	case key != "" && query["tagging"] != nil:
+		f.getTagging(w, bucket, key)
+	case key != "":
+		writeFakeError(w, http.StatusNotImplemented, "NotImplemented")
+	case query["location"] != nil:
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = fmt.Fprintf(w, `<LocationConstraint>%s</LocationConstraint>`, f.regions[bucket])
+	case query["versions"] != nil:
+		f.listVersions(w, bucket, query)
+	case query["uploads"] != nil:
+		f.listUploads(w, bucket, query)
+	case fakeSubResource(query) != "":
+		f.getConfig(w, bucket, fakeSubResource(query))
+	case query.Get("list-type") == "2":
+		f.mutex.Lock()
+		f.listings = append(f.listings, query)
+		blockAfter := f.blockAfter
+		f.mutex.Unlock()
+		if token := query.Get("continuation-token"); blockAfter != "" && token > blockAfter {
+			select {
+			case f.blocked <- token:
+			default:
+			}
+			<-r.Context().Done()
+			return
+		}
+		f.listObjects(w, bucket, query)
+	default:
+		writeFakeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// fakeSubResource returns the bucket configuration sub-resource of query, if any
+func fakeSubResource(query url.Values) string {
+	for subResource := range fakeUnconfigured {
+		if query[subResource] != nil {
+			return subResource
+		}
+	}
+	return ""
+}
+
+// getConfig answers the configuration of bucket for subResource, or its error
+func (f *fakeS3) getConfig(w http.ResponseWriter, bucket string, subResource string) {
+	if code := f.configErrors[bucket][subResource]; code != "" {
+		writeFakeError(w, fakeErrorStatus(code), code)
+		return
+	}
+	config, ok := f.configs[bucket][subResource]
+	if !ok {
+		config = fakeUnconfigured[subResource]
+		if !strings.HasPrefix(config, "<") {
+			writeFakeError(w, http.StatusNotFound, config)
+			return
+		}
+	}
+	if subResource != "policy" {
+		w.Header().Set("Content-Type", "application/xml")
+	}
+	_, _ = w.Write([]byte(config))
+}
+
+func (f *fakeS3) listBuckets(w http.ResponseWriter) {
+	var names []string
+	for name := range f.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	content := `<ListAllMyBucketsResult><Owner><ID>owner</ID></Owner><Buckets>`
+	for _, name := range names {
+		content += fmt.Sprintf(`<Bucket><Name>%s</Name><CreationDate>2021-01-01T00:00:00.000Z</CreationDate></Bucket>`, name)
+	}
+	content += `</Buckets></ListAllMyBucketsResult>`
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write([]byte(content))
+}
+
+// listObjects returns the page of the objects and common prefixes after the start key or continuation token
+func (f *fakeS3) listObjects(w http.ResponseWriter, bucket string, query url.Values) {
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	after := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		after = token
+	}
+	maxKeys := 1000
+	if value, err := strconv.Atoi(query.Get("max-keys")); err == nil && value > 0 {
+		maxKeys = value
+	}
+
+	keys := make([]string, 0, len(f.buckets[bucket]))
+	for key := range f.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := fakeListing{Name: bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: maxKeys}
+	last := ""
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		entry := key
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			entry = key[:len(prefix)+i+len(delimiter)]
+		}
+		if entry <= after || entry == last {
+			continue
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = last
+			break
+		}
+		if entry == key {
+			modified, ok := f.modified[bucket][key]
+			if !ok {
+				modified = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+			}
+			result.Contents = append(result.Contents, fakeContent{
+				Key:          key,
+				LastModified: modified.Format(time.RFC3339),
+				ETag:         `"etag"`,
+				Size:         f.buckets[bucket][key],
+				StorageClass: "STANDARD",
+			})
+		} else {
+			result.CommonPrefixes = append(result.CommonPrefixes, fakePrefix{Prefix: entry})
+		}
+		result.KeyCount++
+		last = entry
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// listVersions returns the page of the versions after the key and version markers
+func (f *fakeS3) listVersions(w http.ResponseWriter, bucket string, query url.Values) {
+	maxKeys := 1000
+	if value, err := strconv.Atoi(query.Get("max-keys")); err == nil && value > 0 {
+		maxKeys = value
+	}
+	result := fakeVersionsListing{Name: bucket, Prefix: query.Get("prefix"), KeyMarker: query.Get("key-marker"), VersionIDMarker: query.Get("version-id-marker"), MaxKeys: maxKeys}
+
+	versions := append([]fakeVersion(nil), f.versions[bucket]...)
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].Key < versions[j].Key })
+	// Versions up to the markers were returned by the previous pages
+	skipping := result.KeyMarker != ""
+	for _, version := range versions {
+		if skipping {
+			switch {
+			case version.Key < result.KeyMarker:
+				continue
+			case version.Key == result.KeyMarker:
+				// Without version marker, all the versions of the marker key were returned
+				if result.VersionIDMarker != "" {
+					skipping = version.VersionID != result.VersionIDMarker
+				}
+				continue
+			}
+			skipping = false
+		}
+		if !strings.HasPrefix(version.Key, result.Prefix) {
+			continue
+		}
+		if len(result.Versions) == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		result.Versions = append(result.Versions, version)
+		result.NextKeyMarker, result.NextVersionIDMarker = version.Key, version.VersionID
+	}
+	if !result.IsTruncated {
+		result.NextKeyMarker, result.NextVersionIDMarker = "", ""
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// headObject answers the headers of an object, or of one of its versions when versionID is set
+func (f *fakeS3) headObject(w http.ResponseWriter, bucket string, key string, versionID string) {
+	name := key
+	size, ok := f.buckets[bucket][key]
+	modified, known := f.modified[bucket][key]
+	if !known {
+		modified = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if versionID != "" {
+		name += "?versionId=" + versionID
+		ok = false
+		for _, version := range f.versions[bucket] {
+			if version.Key == key && version.VersionID == versionID && version.XMLName.Local == "Version" {
+				size, ok = version.Size, true
+				modified, _ = time.Parse(time.RFC3339, version.LastModified)
+			}
+		}
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	for header, values := range f.headers[bucket][name] {
+		w.Header()[header] = values
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	w.Header().Set("ETag", `"etag"`)
+}
+
+// getTagging answers the tags of an object, sorted by key
+func (f *fakeS3) getTagging(w http.ResponseWriter, bucket string, key string) {
+	if _, ok := f.buckets[bucket][key]; !ok {
+		writeFakeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	tags := f.tags[bucket][key]
+	tagKeys := make([]string, 0, len(tags))
+	for tagKey := range tags {
+		tagKeys = append(tagKeys, tagKey)
+	}
+	sort.Strings(tagKeys)
+
+	content := `<Tagging><TagSet>`
+	for _, tagKey := range tagKeys {
+		content += fmt.Sprintf(`<Tag><Key>%s</Key><Value>%s</Value></Tag>`, tagKey, tags[tagKey])
+	}
+	content += `</TagSet></Tagging>`
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write([]byte(content))
+}
+
+// listUploads returns all the incomplete uploads of bucket under the prefix
+func (f *fakeS3) listUploads(w http.ResponseWriter, bucket string, query url.Values) {
+	result := fakeUploadsListing{Bucket: bucket, Prefix: query.Get("prefix")}
+	for _, upload := range f.uploads[bucket] {
+		if strings.HasPrefix(upload.Key, result.Prefix) {
+			result.Uploads = append(result.Uploads, upload)
+		}
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// listParts returns the page of the parts of an upload after the part number marker
+func (f *fakeS3) listParts(w http.ResponseWriter, bucket string, key string, query url.Values) {
+	var upload *fakeUpload
+	for i := range f.uploads[bucket] {
+		if f.uploads[bucket][i].Key == key && f.uploads[bucket][i].UploadID == query.Get("uploadId") {
+			upload = &f.uploads[bucket][i]
+		}
+	}
+	if upload == nil {
+		writeFakeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	marker, _ := strconv.Atoi(query.Get("part-number-marker"))
+	maxParts := 1000
+	if value, err := strconv.Atoi(query.Get("max-parts")); err == nil && value > 0 {
+		maxParts = value
+	}
+	result := fakePartsListing{Bucket: bucket, Key: key, UploadID: upload.UploadID, PartNumberMarker: marker, MaxParts: maxParts}
+	for i, size := range upload.parts {
+		number := i + 1
+		if number <= marker {
+			continue
+		}
+		if len(result.Parts) == maxParts {
+			result.IsTruncated = true
+			break
+		}
+		result.Parts = append(result.Parts, fakePart{PartNumber: number, LastModified: upload.Initiated, ETag: `"etag"`, Size: size})
+		result.NextPartNumberMarker = number
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// block holds the listing requests continuing after key; an empty key releases the next requests
+func (f *fakeS3) block(key string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.blockAfter = key
+}
+
+// requests returns the listing requests received so far
+func (f *fakeS3) requests() []url.Values {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]url.Values(nil), f.listings...)
+}
+
+// fakeErrorStatus returns the HTTP status of the error code
+func fakeErrorStatus(code string) int {
+	switch code {
+	case "AccessDenied":
+		return http.StatusForbidden
+	case "NoSuchKey", "NoSuchVersion":
+		return http.StatusNotFound
+	case "NotImplemented":
+		return http.StatusNotImplemented
+	}
+	return http.StatusBadRequest
+}
+
+func writeFakeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+// testConfig parses the options of a walker of type walkerType from args, unset options taking their default value
+func testConfig(t *testing.T, walkerType string, args ...string) Config {
+	registration, err := Lookup(walkerType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{BaseWalkerConfig: &BaseWalkerConfig{}, Options: registration.NewConfig()}
+	parser := flags.NewParser(config.BaseWalkerConfig, flags.None)
+	if _, err := parser.AddGroup(registration.Description, "", config.Options); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseArgs(args); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// newS3Walker returns an S3 walker of endpoint configured with args
+func newS3Walker(t *testing.T, endpoint string, args ...string) *S3Walker {
+	config := testConfig(t, "s3", append([]string{
+		"--s3.endpoint", endpoint, "--s3.access-key", "access", "--s3.secret-key", "secret",
+		"--s3.bucket-path-style", "--s3.region", "us-east-1", "--s3.max-attempts", "1",
+	}, args...)...)
+	walker := &S3Walker{}
+	if err := walker.Init(config, map[string]string{"target": "test"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	return walker
+}
+
+// walkS3 walks endpoint with a new S3 walker configured with args and returns the published snapshot
+func walkS3(t *testing.T, endpoint string, args ...string) *stats.Snapshot {
+	walker := newS3Walker(t, endpoint, args...)
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return walker.Stats.(*stats.PrometheusStats).Snapshot()
+}
+
+// walkedSeries returns the series of snapshot without the sums of the ages of objects, which depend on the walk date
+func walkedSeries(snapshot *stats.Snapshot) map[string]*stats.Series {
+	for _, series := range snapshot.Series {
+		for _, prefix := range series.Prefixes {
+			prefix.AgeHistogram.Sum = 0
+		}
+	}
+	return snapshot.Series
+}
+
+// fakeBucket returns a bucket of count objects spread over nested prefixes
+func fakeBucket(count int) map[string]int64 {
+	objects := map[string]int64{}
+	for i := 0; i < count; i++ {
+		var key string
+		switch i % 4 {
+		case 0:
+			key = fmt.Sprintf("file-%03d.txt", i)
+		case 1:
+			key = fmt.Sprintf("docs/%03d.pdf", i)
+		case 2:
+			key = fmt.Sprintf("docs/%d/%03d.pdf", 2020+i%3, i)
+		default:
+			key = fmt.Sprintf("images/%d/raw/%03d.jpg", i%5, i)
+		}
+		objects[key] = int64(i * 1000)
+	}
+	return objects
+}
+
+// TestShardedListing checks that listing buckets in shards gives the same aggregates as a single listing
+func TestShardedListing(t *testing.T) {
+	fake, endpoint := newFakeS3(t, map[string]map[string]int64{"data": fakeBucket(200), "empty": {}})
+	serial := walkS3(t, endpoint, "--maxDepth", "2", "--s3.page-size", "7")
+	if serial.Series[stats.SeriesKey(map[string]string{"bucket": "data", "scope": "", "region": "us-east-1", "storageClass": "STANDARD"})].Objects != 200 {
+		t.Fatalf("unexpected serial listing results: %+v", serial.Series)
+	}
+
+	for _, depth := range []string{"1", "2", "3"} {
+		before := len(fake.requests())
+		sharded := walkS3(t, endpoint, "--maxDepth", "2", "--s3.page-size", "7", "--s3.list-workers", "4", "--s3.shard-depth", depth)
+		if !reflect.DeepEqual(walkedSeries(sharded), walkedSeries(serial)) {
+			t.Errorf("shard depth %s: sharded listing aggregates differ from the serial listing ones", depth)
+		}
+
+		delimited := 0
+		for _, query := range fake.requests()[before:] {
+			if query.Get("delimiter") == "/" {
+				delimited++
+			}
+		}
+		if delimited == 0 {
+			t.Errorf("shard depth %s: no shard was discovered", depth)
+		}
+	}
+}
+
+// s3Series returns the series of the objects of bucket in storageClass, walked in us-east-1
+func s3Series(snapshot *stats.Snapshot, bucket string, storageClass string) *stats.Series {
+	return snapshot.Series[stats.SeriesKey(map[string]string{"bucket": bucket, "scope": "", "region": "us-east-1", "storageClass": storageClass})]
+}
+
+// TestIncompleteUploads checks that the parts of incomplete uploads are accounted per prefix with the age of the
+// uploads, and that uploads whose parts can not be listed are still counted in partial walks
+func TestIncompleteUploads(t *testing.T) {
+	now := time.Now().UTC()
+	initiated := func(age time.Duration) string {
+		return now.Add(-age).Format(time.RFC3339)
+	}
+	fake := &fakeS3{
+		buckets: map[string]map[string]int64{"data": fakeBucket(4)},
+		uploads: map[string][]fakeUpload{"data": {
+			{Key: "docs/big.iso", UploadID: "1", Initiated: initiated(12 * time.Hour), StorageClass: "STANDARD", parts: []int64{5 << 20, 5 << 20, 1 << 20}},
+			{Key: "docs/2021/old.iso", UploadID: "2", Initiated: initiated(40 * 24 * time.Hour), StorageClass: "STANDARD", parts: []int64{100}},
+			{Key: "file.bin", UploadID: "3", Initiated: initiated(3 * 24 * time.Hour), StorageClass: "GLACIER"},
+		}},
+	}
+	var denied int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&denied) != 0 && r.URL.Query().Get("uploadId") == "2" {
+			writeFakeError(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	args := []string{"--maxDepth", "0", "--s3.incomplete-uploads", "--s3.page-size", "2", "--on-error", "partial"}
+
+	walker := newS3Walker(t, server.URL, args...)
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := walker.Stats.(*stats.PrometheusStats).Snapshot()
+	for _, test := range []struct {
+		storageClass string
+		prefix       string
+		count        uint64
+		size         uint64
+		ages         []uint64
+	}{
+		{"STANDARD", "docs", 2, 11<<20 + 100, []uint64{1, 0, 0, 1, 0, 0, 0}},
+		// Uploads without parts have no size
+		{"GLACIER", "ROOT", 1, 0, []uint64{0, 1, 0, 0, 0, 0, 0}},
+	} {
+		series := s3Series(snapshot, "data", test.storageClass)
+		if series == nil || series.Uploads[test.prefix] == nil {
+			t.Errorf("%s: uploads under %s were not accounted", test.storageClass, test.prefix)
+			continue
+		}
+		uploads := series.Uploads[test.prefix]
+		if uploads.Count != test.count || uploads.Size != test.size || !reflect.DeepEqual(uploads.AgeHistogram.Counts, test.ages) {
+			t.Errorf("%s: expected %d uploads of %d bytes aged %v, got %d of %d bytes aged %v", test.storageClass,
+				test.count, test.size, test.ages, uploads.Count, uploads.Size, uploads.AgeHistogram.Counts)
+		}
+	}
+	// The parts of the first upload are listed in 2 pages
+	if count := walker.Stats.(*stats.PrometheusStats).Status().Requests["ListParts"]["200"]; count != 4 {
+		t.Errorf("expected 4 ListParts requests, got %d", count)
+	}
+
+	atomic.StoreInt32(&denied, 1)
+	walker = newS3Walker(t, server.URL, args...)
+	if err := walker.Walk(context.Background()); err != ErrPartialWalk {
+		t.Fatalf("expected a partial walk, got %v", err)
+	}
+	snapshot = walker.Stats.(*stats.PrometheusStats).Snapshot()
+	if uploads := s3Series(snapshot, "data", "STANDARD").Uploads["docs"]; uploads.Count != 2 || uploads.Size != 11<<20 {
+		t.Errorf("expected the upload whose parts can not be listed to be counted without size, got %d uploads of %d bytes", uploads.Count, uploads.Size)
+	}
+	if errors := walker.Stats.(*stats.PrometheusStats).Status().BucketErrors; errors["data"] != 1 {
+		t.Errorf("expected 1 error of the bucket, got %v", errors)
+	}
+}
+
+// TestVersions checks that noncurrent versions and delete markers are accounted with the age since they became
+// noncurrent, the versions of a key being listed across pages
+func TestVersions(t *testing.T) {
+	now := time.Now().UTC()
+	version := func(key string, id string, latest bool, age time.Duration, size int64) fakeVersion {
+		return fakeVersion{XMLName: xml.Name{Local: "Version"}, Key: key, VersionID: id, IsLatest: latest,
+			LastModified: now.Add(-age).Format(time.RFC3339), ETag: `"etag"`, Size: size, StorageClass: "STANDARD"}
+	}
+	deleteMarker := func(key string, id string, age time.Duration) fakeVersion {
+		return fakeVersion{XMLName: xml.Name{Local: "DeleteMarker"}, Key: key, VersionID: id, IsLatest: true, LastModified: now.Add(-age).Format(time.RFC3339)}
+	}
+	const day = 24 * time.Hour
+	fake, endpoint := newFakeS3(t, map[string]map[string]int64{"data": {}})
+	fake.versions = map[string][]fakeVersion{"data": {
+		version("a.txt", "a3", true, time.Hour, 10),
+		version("a.txt", "a2", false, 10*day, 20),
+		version("a.txt", "a1", false, 20*day, 30),
+		deleteMarker("b.txt", "b2", 2*day),
+		version("b.txt", "b1", false, 30*day, 5),
+		version("docs/c.txt", "c1", true, 40*day, 7),
+	}}
+
+	walker := newS3Walker(t, endpoint, "--maxDepth", "0", "--s3.versions", "--s3.page-size", "2")
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := walker.Stats.(*stats.PrometheusStats).Snapshot()
+
+	series := s3Series(snapshot, "data", "STANDARD")
+	if series == nil || series.Objects != 2 || series.Size != 17 {
+		t.Fatalf("expected the 2 current versions as objects, got %+v", series)
+	}
+	for prefix, expected := range map[string]map[string]stats.Usage{
+		"ROOT": {stats.VersionCurrent: {Objects: 1, Size: 10}, stats.VersionNoncurrent: {Objects: 3, Size: 55}},
+		"docs": {stats.VersionCurrent: {Objects: 1, Size: 7}},
+	} {
+		versions := series.Versions[prefix]
+		if versions == nil || len(versions.States) != len(expected) {
+			t.Errorf("%s: expected versions %v, got %+v", prefix, expected, versions)
+			continue
+		}
+		for state, usage := range expected {
+			if versions.States[state] == nil || *versions.States[state] != usage {
+				t.Errorf("%s: expected %+v %s versions, got %+v", prefix, usage, state, versions.States[state])
+			}
+		}
+	}
+	// a2, a1 and b1 became noncurrent when a3, a2 and the delete marker of b.txt were written
+	if counts := series.Versions["ROOT"].NoncurrentAgeHistogram.Counts; !reflect.DeepEqual(counts, []uint64{1, 1, 1, 0, 0, 0, 0}) {
+		t.Errorf("unexpected noncurrent age histogram %v", counts)
+	}
+
+	// Delete markers have no storage class
+	markers := s3Series(snapshot, "data", "")
+	if markers == nil || markers.Objects != 0 || markers.Versions["ROOT"] == nil || *markers.Versions["ROOT"].States[stats.VersionDeleteMarker] != (stats.Usage{Objects: 1}) {
+		t.Errorf("expected 1 delete marker, got %+v", markers)
+	}
+	if count := walker.Stats.(*stats.PrometheusStats).Status().Requests["ListObjectVersions"]["200"]; count != 3 {
+		t.Errorf("expected 3 pages of versions, got %d", count)
+	}
+}
+
+// TestObjectAges checks that the ages of objects are accounted in the configured buckets, with the modification
+// dates of the oldest and newest objects of each prefix
+func TestObjectAges(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	const day = 24 * time.Hour
+	fake, endpoint := newFakeS3(t, map[string]map[string]int64{"data": {"a.txt": 1, "b.txt": 2, "docs/c.pdf": 3, "docs/2021/d.pdf": 4}})
+	fake.modified = map[string]map[string]time.Time{"data": {
+		"a.txt":           now.Add(-time.Hour),
+		"b.txt":           now.Add(-100 * day),
+		"docs/c.pdf":      now.Add(-10 * day),
+		"docs/2021/d.pdf": now.Add(-400 * day),
+	}}
+
+	snapshot := walkS3(t, endpoint, "--maxDepth", "0", "--age-buckets", "1d", "--age-buckets", "1M", "--age-buckets", "1y")
+	if !reflect.DeepEqual(snapshot.AgeBuckets, []float64{86400, 30 * 86400, 365 * 86400}) {
+		t.Errorf("unexpected age buckets %v", snapshot.AgeBuckets)
+	}
+	series := s3Series(snapshot, "data", "STANDARD")
+	if series == nil {
+		t.Fatal("the objects were not accounted")
+	}
+	for prefix, expected := range map[string]struct {
+		counts []uint64
+		// count includes the objects older than the last bucket
+		count  uint64
+		oldest time.Time
+		newest time.Time
+	}{
+		"ROOT": {[]uint64{1, 0, 1}, 2, now.Add(-100 * day), now.Add(-time.Hour)},
+		"docs": {[]uint64{0, 1, 0}, 2, now.Add(-400 * day), now.Add(-10 * day)},
+	} {
+		prefixStats := series.Prefixes[prefix]
+		if prefixStats == nil {
+			t.Errorf("%s: the prefix was not accounted", prefix)
+			continue
+		}
+		if !reflect.DeepEqual(prefixStats.AgeHistogram.Counts, expected.counts) || prefixStats.AgeHistogram.Count != expected.count {
+			t.Errorf("%s: expected ages %v out of %d, got %v out of %d", prefix, expected.counts, expected.count, prefixStats.AgeHistogram.Counts, prefixStats.AgeHistogram.Count)
+		}
+		if !prefixStats.Oldest.Equal(expected.oldest) || !prefixStats.Newest.Equal(expected.newest) {
+			t.Errorf("%s: expected objects modified from %s to %s, got %s to %s", prefix, expected.oldest, expected.newest, prefixStats.Oldest, prefixStats.Newest)
+		}
+	}
+}
+
+// TestBucketConfig checks that the configuration of buckets is read once per walk, settings that are not configured
+// being known and settings that could not be read being unknown and accounted as enrichment errors when the backend
+// implements them
+func TestBucketConfig(t *testing.T) {
+	fake, endpoint := newFakeS3(t, map[string]map[string]int64{"data": {"a.txt": 1}, "logs": {}})
+	fake.configs = map[string]map[string]string{"data": {
+		"versioning":   `<VersioningConfiguration><Status>Enabled</Status></VersioningConfiguration>`,
+		"object-lock":  `<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled><Rule><DefaultRetention><Mode>GOVERNANCE</Mode><Days>30</Days></DefaultRetention></Rule></ObjectLockConfiguration>`,
+		"encryption":   `<ServerSideEncryptionConfiguration><Rule><ApplyServerSideEncryptionByDefault><SSEAlgorithm>AES256</SSEAlgorithm></ApplyServerSideEncryptionByDefault></Rule></ServerSideEncryptionConfiguration>`,
+		"lifecycle":    `<LifecycleConfiguration><Rule><ID>tmp</ID><Status>Enabled</Status><Filter><Prefix>tmp/</Prefix></Filter><Expiration><Days>1</Days></Expiration></Rule><Rule><ID>logs</ID><Status>Enabled</Status><Filter><Prefix>logs/</Prefix></Filter><Expiration><Days>30</Days></Expiration></Rule></LifecycleConfiguration>`,
+		"replication":  `<ReplicationConfiguration><Role>arn:aws:iam::1:role/replication</Role><Rule><ID>backup</ID><Status>Enabled</Status><Priority>1</Priority><Destination><Bucket>arn:aws:s3:::backup</Bucket></Destination></Rule></ReplicationConfiguration>`,
+		"notification": `<NotificationConfiguration><QueueConfiguration><Id>created</Id><Queue>arn:aws:sqs:us-east-1:1:created</Queue><Event>s3:ObjectCreated:*</Event></QueueConfiguration></NotificationConfiguration>`,
+		"policy":       `{"Version": "2012-10-17", "Statement": []}`,
+	}}
+	fake.configErrors = map[string]map[string]string{"logs": {"policy": "AccessDenied", "replication": "NotImplemented"}}
+
+	walker := newS3Walker(t, endpoint, "--s3.bucket-config")
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	buckets := walker.Stats.(*stats.PrometheusStats).Snapshot().Buckets
+	for bucket, expected := range map[string]stats.BucketConfig{
+		"data": {CreationDate: created, Region: "us-east-1", Versioning: "Enabled", MFADelete: "Disabled", ObjectLock: "Enabled", ObjectLockMode: "GOVERNANCE",
+			Encryption: "AES256", LifecycleRules: 2, ReplicationRules: 1, NotificationRules: 1, Policy: true},
+		"logs": {CreationDate: created, Region: "us-east-1", Versioning: "Disabled", MFADelete: "Disabled", ObjectLock: "Disabled", Encryption: "none",
+			Unknown: []string{stats.BucketSettingReplication, stats.BucketSettingPolicy}},
+	} {
+		config := buckets[bucket]
+		if config == nil {
+			t.Errorf("%s: the configuration was not read", bucket)
+			continue
+		}
+		if !reflect.DeepEqual(*config, expected) {
+			t.Errorf("%s: expected configuration %+v, got %+v", bucket, expected, *config)
+		}
+	}
+
+	status := walker.Stats.(*stats.PrometheusStats).Status()
+	if errors := status.EnrichmentErrors; !reflect.DeepEqual(errors, map[string]map[string]uint64{FeatureBucketConfig: {ErrorClassAccessDenied: 1}}) {
+		t.Errorf("expected the denied policy as only enrichment error, got %v", errors)
+	}
+	if len(status.Errors) != 0 {
+		t.Errorf("configuration read failures were accounted as walk errors: %v", status.Errors)
+	}
+	if values := gatherValues(t, walker.Collector(), "bucket_replication_rules", "bucket"); !reflect.DeepEqual(values, map[string]float64{"data": 1}) {
+		t.Errorf("expected the replication rules of data only, got %v", values)
+	}
+}
+
+// TestSampledMetadata checks that the metadata of objects read with HEAD requests is accounted per prefix within the
+// budget of the walk, objects deleted since they were listed being skipped and other failures being accounted as
+// enrichment errors
+func TestSampledMetadata(t *testing.T) {
+	fake, endpoint := newFakeS3(t, map[string]map[string]int64{"data": {
+		"a.txt": 10, "b.bin": 20, "docs/c.pdf": 30, "docs/gone.txt": 40, "docs/denied.txt": 50,
+	}})
+	fake.headers = map[string]map[string]http.Header{"data": {
+		"a.txt": {"Content-Type": {"text/plain"}, "X-Amz-Meta-Owner": {"alice"}},
+		"b.bin": {"Content-Type": {"application/octet-stream"}, "X-Amz-Server-Side-Encryption": {"aws:kms"},
+			"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": {"key-1"}, "X-Amz-Replication-Status": {"COMPLETED"}},
+		"docs/c.pdf": {"Content-Type": {"application/pdf"}, "X-Amz-Server-Side-Encryption": {"AES256"}},
+	}}
+	fake.objectErrors = map[string]map[string]string{"data": {"docs/gone.txt": "NoSuchKey", "docs/denied.txt": "AccessDenied"}}
+
+	walker := newS3Walker(t, endpoint, "--maxDepth", "0", "--s3.metadata", "head")
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	series := s3Series(walker.Stats.(*stats.PrometheusStats).Snapshot(), "data", "STANDARD")
+	if series == nil {
+		t.Fatal("the objects were not accounted")
+	}
+	for prefix, expected := range map[string]*stats.MetadataStats{
+		"ROOT": {
+			Sampled:             stats.Usage{Objects: 2, Size: 30},
+			ContentTypes:        map[string]*stats.Usage{"text/plain": {Objects: 1, Size: 10}, "application/octet-stream": {Objects: 1, Size: 20}},
+			Encryptions:         map[string]*stats.Usage{"none": {Objects: 1, Size: 10}, "aws:kms": {Objects: 1, Size: 20}},
+			KMSKeys:             map[string]*stats.Usage{"key-1": {Objects: 1, Size: 20}},
+			ReplicationStatuses: map[string]*stats.Usage{"none": {Objects: 1, Size: 10}, "COMPLETED": {Objects: 1, Size: 20}},
+			MetadataKeys:        map[string]*stats.Usage{"owner": {Objects: 1, Size: 10}},
+		},
+		"docs": {
+			Sampled:             stats.Usage{Objects: 1, Size: 30},
+			ContentTypes:        map[string]*stats.Usage{"application/pdf": {Objects: 1, Size: 30}},
+			Encryptions:         map[string]*stats.Usage{"AES256": {Objects: 1, Size: 30}},
+			KMSKeys:             map[string]*stats.Usage{},
+			ReplicationStatuses: map[string]*stats.Usage{"none": {Objects: 1, Size: 30}},
+			MetadataKeys:        map[string]*stats.Usage{},
+		},
+	} {
+		if !reflect.DeepEqual(series.Metadata[prefix], expected) {
+			t.Errorf("%s: expected metadata %+v, got %+v", prefix, expected, series.Metadata[prefix])
+		}
+	}
+	// Objects keep being accounted from the listing whatever their metadata
+	if series.Objects != 5 {
+		t.Errorf("expected 5 objects, got %d", series.Objects)
+	}
+
+	status := walker.Stats.(*stats.PrometheusStats).Status()
+	if errors := status.EnrichmentErrors; !reflect.DeepEqual(errors, map[string]map[string]uint64{FeatureMetadata: {ErrorClassAccessDenied: 1}}) {
+		t.Errorf("expected the denied object as only enrichment error, got %v", errors)
+	}
+	if len(status.Errors) != 0 {
+		t.Errorf("metadata read failures were accounted as walk errors: %v", status.Errors)
+	}
+
+	// The budget caps the objects read per walk
+	walker = newS3Walker(t, endpoint, "--maxDepth", "0", "--s3.metadata", "head", "--s3.metadata-budget", "2")
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var requests uint64
+	for _, count := range walker.Stats.(*stats.PrometheusStats).Status().Requests["HeadObject"] {
+		requests += count
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 HeadObject requests within the budget, got %d", requests)
+	}
+}
+
+// TestTags checks that the values of the allowed tag keys of sampled objects are accounted per prefix, objects
+// without a tag having an empty value and other tag keys being dropped
+func TestTags(t *testing.T) {
+	fake, endpoint := newFakeS3(t, map[string]map[string]int64{"data": {"a.txt": 10, "b.txt": 20, "docs/c.txt": 30, "docs/denied.txt": 40}})
+	fake.tags = map[string]map[string]map[string]string{"data": {
+		"a.txt": {"team": "data", "dataset": "logs", "secret": "x"},
+		"b.txt": {"team": "web"},
+	}}
+	fake.objectErrors = map[string]map[string]string{"data": {"docs/denied.txt": "AccessDenied"}}
+
+	walker := newS3Walker(t, endpoint, "--maxDepth", "0", "--s3.tag-keys", "team", "--s3.tag-keys", "dataset")
+	if err := walker.Walk(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	series := s3Series(walker.Stats.(*stats.PrometheusStats).Snapshot(), "data", "STANDARD")
+	if series == nil {
+		t.Fatal("the objects were not accounted")
+	}
+	for prefix, expected := range map[string]*stats.TagStats{
+		"ROOT": {Sampled: stats.Usage{Objects: 2, Size: 30}, Values: map[string]map[string]*stats.Usage{
+			"team":    {"data": {Objects: 1, Size: 10}, "web": {Objects: 1, Size: 20}},
+			"dataset": {"logs": {Objects: 1, Size: 10}, "": {Objects: 1, Size: 20}},
+		}},
+		"docs": {Sampled: stats.Usage{Objects: 1, Size: 30}, Values: map[string]map[string]*stats.Usage{
+			"team":    {"": {Objects: 1, Size: 30}},
+			"dataset": {"": {Objects: 1, Size: 30}},
+		}},
+	} {
+		if !reflect.DeepEqual(series.Tags[prefix], expected) {
+			t.Errorf("%s: expected tags %+v, got %+v", prefix, expected, series.Tags[prefix])
+		}
+	}
+	// Tags are read without metadata
+	if len(series.Metadata) != 0 {
+		t.Errorf("unexpected metadata %v", series.Metadata)
+	}
+
+	status := walker.Stats.(*stats.PrometheusStats).Status()
+	if errors := status.EnrichmentErrors; !reflect.DeepEqual(errors, map[string]map[string]uint64{FeatureTags: {ErrorClassAccessDenied: 1}}) {
+		t.Errorf("expected the denied object as only enrichment error, got %v", errors)
+	}
+	if count := status.Requests["GetObjectTagging"]["200"]; count != 3 {
+		t.Errorf("expected 3 GetObjectTagging requests, got %d", count)
+	}
+}
+
+// TestRetention checks that the retention and legal hold of the objects of object lock enabled buckets are accounted
+// per prefix, for discovered and configured buckets, noncurrent versions included
+func TestRetention(t *testing.T) {
+	now := time.Now().UTC()
+	const day = 24 * time.Hour
+	retention := func(mode string, remaining time.Duration, legalHold string) http.Header {
+		header := http.Header{"X-Amz-Object-Lock-Mode": {mode}, "X-Amz-Object-Lock-Retain-Until-Date": {now.Add(remaining).Format(time.RFC3339)}}
+		if legalHold != "" {
+			header.Set("X-Amz-Object-Lock-Legal-Hold", legalHold)
+		}
+		return header
+	}
+	version := func(key string, id string, latest bool, size int64) fakeVersion {
+		return fakeVersion{XMLName: xml.Name{Local: "Version"}, Key: key, VersionID: id, IsLatest: latest,
+			LastModified: now.Add(-time.Hour).Format(time.RFC3339), ETag: `"etag"`, Size: size, StorageClass: "STANDARD"}
+	}
+	fake, endpoint := newFakeS3(t, map[string]map[string]int64{
+		"vault": {"a.txt": 10, "b.txt": 20, "docs/c.txt": 30, "docs/denied.txt": 40},
+		"plain": {"x.txt": 5},
+	})
+	fake.configs = map[string]map[string]string{"vault": {"object-lock": `<ObjectLockConfiguration><ObjectLockEnabled>Enabled</ObjectLockEnabled></ObjectLockConfiguration>`}}
+	fake.headers = map[string]map[string]http.Header{"vault": {
+		"a.txt":              retention("GOVERNANCE", 10*day, ""),
+		"a.txt?versionId=a1": retention("COMPLIANCE", 40*day, "OFF"),
+		"b.txt":              retention("COMPLIANCE", 400*day, "ON"),
+		// Expired retentions are ignored
+		"docs/c.txt": retention("GOVERNANCE", -day, "ON"),
+	}}
+	fake.versions = map[string][]fakeVersion{"vault": {
+		version("a.txt", "a2", true, 10),
+		version("a.txt", "a1", false, 15),
+		version("b.txt", "b1", true, 20),
+		version("docs/c.txt", "c1", true, 30),
+		version("docs/denied.txt", "d1", true, 40),
+	}}
+	fake.objectErrors = map[string]map[string]string{"vault": {"docs/denied.txt": "AccessDenied"}}
+
+	for _, test := range []struct {
+		name string
+		args []string
+		// modes and counts are the retentions of the ROOT prefix
+		modes  map[string]*stats.Usage
+		counts []uint64
+		count  uint64
+		heads  uint64
+	}{
+		{"discovered", nil,
+			map[string]*stats.Usage{"GOVERNANCE": {Objects: 1, Size: 10}, "COMPLIANCE": {Objects: 1, Size: 20}}, []uint64{0, 1, 0}, 2, 4},
+		{"configured versions", []string{"--s3.bucket", "vault", "--s3.versions"},
+			map[string]*stats.Usage{"GOVERNANCE": {Objects: 1, Size: 10}, "COMPLIANCE": {Objects: 2, Size: 35}}, []uint64{0, 1, 1}, 3, 5},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			walker := newS3Walker(t, endpoint, append([]string{"--maxDepth", "0", "--s3.object-lock",
+				"--age-buckets", "1d", "--age-buckets", "1M", "--age-buckets", "1y"}, test.args...)...)
+			if err := walker.Walk(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			snapshot := walker.Stats.(*stats.PrometheusStats).Snapshot()
+			series := s3Series(snapshot, "vault", "STANDARD")
+			if series == nil {
+				t.Fatal("the objects were not accounted")
+			}
+
+			root := series.Retention["ROOT"]
+			if root == nil || !reflect.DeepEqual(root.Modes, test.modes) || root.LegalHold != (stats.Usage{Objects: 1, Size: 20}) {
+				t.Errorf("ROOT: expected retentions %v with 1 object of 20 bytes under legal hold, got %+v", test.modes, root)
+			} else if !reflect.DeepEqual(root.RetainUntilHistogram.Counts, test.counts) || root.RetainUntilHistogram.Count != test.count {
+				t.Errorf("ROOT: expected retain until dates %v out of %d, got %v out of %d", test.counts, test.count, root.RetainUntilHistogram.Counts, root.RetainUntilHistogram.Count)
+			}
+			docs := series.Retention["docs"]
+			if docs == nil || len(docs.Modes) != 0 || docs.RetainUntilHistogram.Count != 0 || docs.LegalHold != (stats.Usage{Objects: 1, Size: 30}) {
+				t.Errorf("docs: expected 1 object of 30 bytes under legal hold only, got %+v", docs)
+			}
+			if plain := s3Series(snapshot, "plain", "STANDARD"); plain != nil && len(plain.Retention) != 0 {
+				t.Errorf("the retention of objects of a bucket without object lock was read: %v", plain.Retention)
+			}
+
+			status := walker.Stats.(*stats.PrometheusStats).Status()
+			if errors := status.EnrichmentErrors; !reflect.DeepEqual(errors, map[string]map[string]uint64{FeatureRetention: {ErrorClassAccessDenied: 1}}) {
+				t.Errorf("expected the denied object as only enrichment error, got %v", errors)
+			}
+			var heads uint64
+			for _, count := range status.Requests["HeadObject"] {
+				heads += count
+			}
+			if heads != test.heads {
+				t.Errorf("expected %d HeadObject requests, got %d", test.heads, heads)
+			}
+		})
+	}
+}
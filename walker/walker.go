@@ -1,35 +1,37 @@
 package walker
 
-import log "github.com/sirupsen/logrus"
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/willena/s3-exporter/utils"
+)
 
 type Config struct {
 	*BaseWalkerConfig
-	*S3WalkerConfig
-	*FsWalkerConfig
+	// Options holds the walker specific options, as created by the Registration.NewConfig of the walker
+	Options interface{} `no-flag:"true"`
 }
 
 type Walker interface {
 	Init(config Config, labels map[string]string, labelValue []string) error
-	Walk() error
+	// Walk runs a complete walk and publishes its results. It returns early when ctx is done
+	// or when the maximum walk duration is exceeded (ErrWalkTimeout), keeping the previous results.
+	Walk(ctx context.Context) error
 	ValidateConfig(config Config) error
+	// Collector exposes the results of the walker
+	Collector() prometheus.Collector
 }
 
-func FromConfig(config Config, walkerType string) (Walker, error) {
-	var walker Walker
-
-	switch walkerType {
-	case "s3":
-		walker = &S3Walker{}
-
-	case "fs":
-		walker = &FsWalker{}
-	default:
-		log.Fatalln("Please select one walker implementation")
-		return nil, nil
+// FromConfig creates and initialises the registered walker named walkerType for target;
+// the target name is added to the labels of all its metrics
+func FromConfig(target string, config Config, walkerType string) (Walker, error) {
+	registration, err := Lookup(walkerType)
+	if err != nil {
+		return nil, err
 	}
 
-	err := walker.Init(config, config.CustomLabels, nil)
+	walker := registration.New()
+	err = walker.Init(config, utils.MergeMapsRight(map[string]string{"target": target}, config.CustomLabels), nil)
 
 	return walker, err
-
 }